@@ -0,0 +1,132 @@
+// Command jstore-diff runs the same saves and queries against two
+// stores and reports, where their results differ. By default, it
+// compares the memory store with Elasticsearch:
+//
+//	jstore-diff -right-url http://127.0.0.1:9200
+//
+// The results of the right store can be recorded into a fixture file,
+// against which the left store is compared later without a live
+// cluster:
+//
+//	jstore-diff -record fixtures.json
+//	jstore-diff -fixtures fixtures.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	olivere "github.com/olivere/elastic/v7"
+	"github.com/snabble/go-jstore/v2"
+	"github.com/snabble/go-jstore/v2/elastic"
+	"github.com/snabble/go-jstore/v2/jstoretest"
+	_ "github.com/snabble/go-jstore/v2/memory"
+)
+
+func main() {
+	leftDriver := flag.String("left", "memory", "driver of the left store")
+	leftURL := flag.String("left-url", "", "data source name of the left store")
+	rightDriver := flag.String("right", "elastic", "driver of the right store")
+	rightURL := flag.String("right-url", "http://127.0.0.1:9200", "data source name of the right store")
+	scenariosFile := flag.String("scenarios", "", "JSON file with scenarios, default are the built-in scenarios")
+	recordFile := flag.String("record", "", "record the results of the right store into this file")
+	fixturesFile := flag.String("fixtures", "", "compare the left store with the results recorded in this file")
+	flag.Parse()
+
+	divergences, err := run(*leftDriver, *leftURL, *rightDriver, *rightURL, *scenariosFile, *recordFile, *fixturesFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	for _, divergence := range divergences {
+		fmt.Println(divergence)
+	}
+	if len(divergences) > 0 {
+		os.Exit(1)
+	}
+}
+
+func run(leftDriver, leftURL, rightDriver, rightURL, scenariosFile, recordFile, fixturesFile string) ([]jstoretest.Divergence, error) {
+	if fixturesFile != "" {
+		recordings, err := readRecordings(fixturesFile)
+		if err != nil {
+			return nil, err
+		}
+		left, err := newStore(leftDriver, leftURL)
+		if err != nil {
+			return nil, err
+		}
+		return jstoretest.DiffRecordings(left, recordings...)
+	}
+
+	scenarios := jstoretest.DefaultScenarios()
+	if scenariosFile != "" {
+		var err error
+		if scenarios, err = readScenarios(scenariosFile); err != nil {
+			return nil, err
+		}
+	}
+
+	right, err := newStore(rightDriver, rightURL)
+	if err != nil {
+		return nil, err
+	}
+	if recordFile != "" {
+		recordings, err := jstoretest.Record(right, scenarios...)
+		if err != nil {
+			return nil, err
+		}
+		return nil, writeRecordings(recordFile, recordings)
+	}
+
+	left, err := newStore(leftDriver, leftURL)
+	if err != nil {
+		return nil, err
+	}
+	return jstoretest.Diff(left, right, scenarios...)
+}
+
+// newStore makes writes visible to the following queries, so the
+// results do not depend on the refresh interval of Elasticsearch.
+func newStore(driver, url string) (jstore.Store, error) {
+	store, err := jstore.NewStore(driver, url, elastic.SyncUpdates(), olivere.SetSniff(false))
+	if err != nil {
+		return nil, fmt.Errorf("creating %s store: %w", driver, err)
+	}
+	return store, nil
+}
+
+func readScenarios(file string) ([]jstoretest.Scenario, error) {
+	in, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	scenarios := []jstoretest.Scenario{}
+	if err := json.Unmarshal(in, &scenarios); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", file, err)
+	}
+	return scenarios, nil
+}
+
+func readRecordings(file string) ([]jstoretest.Recording, error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return jstoretest.ReadRecordings(in)
+}
+
+func writeRecordings(file string, recordings []jstoretest.Recording) error {
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := jstoretest.WriteRecordings(out, recordings); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
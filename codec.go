@@ -0,0 +1,91 @@
+package jstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Codec converts objects to the json documents of the store and back.
+// A Codec can be passed as StoreOption to NewStore, NewBucket and
+// WrapStore. The documents have to be json, so the stores are still
+// able to query them.
+type Codec interface {
+	// Marshal returns the json of the object. The returned slice is
+	// owned by the caller and must not be reused by the codec, e.g. as
+	// pooled buffer. Codecs other than JSONCodec are copied defensively.
+	Marshal(object interface{}) ([]byte, error)
+	Unmarshal(document []byte, objectRef interface{}) error
+}
+
+// NumberMode defines, how JSONCodec decodes numbers into interface{}
+// values.
+type NumberMode int
+
+const (
+	// NumbersAsFloat64 decodes numbers as float64.
+	NumbersAsFloat64 NumberMode = iota
+	// NumbersAsJSONNumber decodes numbers as json.Number, which keeps
+	// the precision of large integers.
+	NumbersAsJSONNumber
+)
+
+// JSONCodec uses encoding/json.
+type JSONCodec struct {
+	Numbers NumberMode
+	// Strict rejects documents with fields, which are not part of the
+	// target struct.
+	Strict bool
+}
+
+var (
+	// StandardJSON is the default codec and behaves like json.Marshal
+	// and json.Unmarshal.
+	StandardJSON Codec = JSONCodec{}
+	// StrictJSON rejects unknown fields.
+	StrictJSON Codec = JSONCodec{Strict: true}
+)
+
+func (codec JSONCodec) Marshal(object interface{}) ([]byte, error) {
+	return json.Marshal(object)
+}
+
+func (codec JSONCodec) Unmarshal(document []byte, objectRef interface{}) error {
+	if codec.Numbers == NumbersAsFloat64 && !codec.Strict {
+		return json.Unmarshal(document, objectRef)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	if codec.Numbers == NumbersAsJSONNumber {
+		decoder.UseNumber()
+	}
+	if codec.Strict {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(objectRef); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid character after top-level value")
+	}
+	return nil
+}
+
+// marshalJSON marshals the object with the codec and checks, that the
+// result is json. The result of other codecs than JSONCodec is copied,
+// because the stores keep the documents without copying.
+func marshalJSON(codec Codec, object interface{}) ([]byte, error) {
+	document, err := codec.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := codec.(JSONCodec); ok {
+		return document, nil
+	}
+	if !json.Valid(document) {
+		return nil, fmt.Errorf("codec %T did not produce json", codec)
+	}
+	return append([]byte(nil), document...), nil
+}
@@ -0,0 +1,86 @@
+package jstore_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/snabble/go-jstore/v2"
+	"github.com/snabble/go-jstore/v2/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type yamlishCodec struct {
+	jstore.JSONCodec
+}
+
+func (codec yamlishCodec) Marshal(object interface{}) ([]byte, error) {
+	return []byte("name: ford"), nil
+}
+
+func Test_Codec_Strict(t *testing.T) {
+	store, _ := jstore.NewStore(memory.DriverName, "", jstore.StrictJSON)
+	store.Save(jstore.NewID("project", "person", "ford"), `{"name":"ford","age":42}`)
+
+	result := struct {
+		Name string `json:"name"`
+	}{}
+	err := store.UnmarshalByID(&result, jstore.NewID("project", "person", "ford"))
+	assert.Error(t, err)
+
+	err = jstore.WrapStore(store).UnmarshalByID(&result, jstore.NewID("project", "person", "ford"))
+	assert.NoError(t, err)
+}
+
+func Test_Codec_Numbers(t *testing.T) {
+	memoryStore, _ := memory.NewMemoryStore("")
+	memoryStore.Save(jstore.NewID("project", "counter", "1"), `{"value":9007199254740993}`)
+
+	result := map[string]interface{}{}
+	store := jstore.WrapStore(memoryStore, jstore.JSONCodec{Numbers: jstore.NumbersAsJSONNumber})
+	require.NoError(t, store.UnmarshalByID(&result, jstore.NewID("project", "counter", "1")))
+	assert.Equal(t, json.Number("9007199254740993"), result["value"])
+
+	results := []map[string]interface{}{}
+	require.NoError(t, store.UnmarshalN(&results, "project", "counter", 10))
+	assert.Equal(t, json.Number("9007199254740993"), results[0]["value"])
+
+	result = map[string]interface{}{}
+	store = jstore.WrapStore(memoryStore)
+	require.NoError(t, store.UnmarshalByID(&result, jstore.NewID("project", "counter", "1")))
+	assert.Equal(t, float64(9007199254740992), result["value"])
+}
+
+func Test_Codec_MustProduceJSON(t *testing.T) {
+	memoryStore, _ := memory.NewMemoryStore("")
+	store := jstore.WrapStore(memoryStore, yamlishCodec{})
+
+	_, err := store.Marshal(struct{}{}, jstore.NewID("project", "person", "ford"))
+	assert.Error(t, err)
+}
+
+// reusingCodec marshals into the same buffer on every call.
+type reusingCodec struct {
+	jstore.JSONCodec
+	buffer *[]byte
+}
+
+func (codec reusingCodec) Marshal(object interface{}) ([]byte, error) {
+	j, err := json.Marshal(object)
+	*codec.buffer = append((*codec.buffer)[:0], j...)
+	return *codec.buffer, err
+}
+
+func Test_Codec_ReusedBuffer(t *testing.T) {
+	memoryStore, _ := memory.NewMemoryStore("")
+	store := jstore.WrapStore(memoryStore, reusingCodec{buffer: &[]byte{}})
+
+	_, err := store.Marshal(map[string]string{"name": "ford"}, jstore.NewID("project", "person", "ford"))
+	require.NoError(t, err)
+	_, err = store.Marshal(map[string]string{"name": "zaph"}, jstore.NewID("project", "person", "zaphod"))
+	require.NoError(t, err)
+
+	entity, err := memoryStore.Get(jstore.NewID("project", "person", "ford"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"ford"}`, entity.JSON)
+}
@@ -0,0 +1,41 @@
+package jstore
+
+import (
+	"time"
+)
+
+// Stats describes the documents of one document type. LastModified is
+// zero, if the store does not track it.
+type Stats struct {
+	Count        int64     `json:"count"`
+	SizeInBytes  int64     `json:"sizeInBytes"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// Discoverer is implemented by stores, which are able to list their
+// content.
+type Discoverer interface {
+	ListProjects() ([]string, error)
+	ListDocumentTypes(project string) ([]string, error)
+	Stats(project, documentType string) (Stats, error)
+}
+
+// AsDiscoverer returns the Discoverer of the store, looking through
+// wrapping stores like the one returned by WrapStore.
+func AsDiscoverer(store Store) (Discoverer, bool) {
+	for store != nil {
+		if discoverer, ok := store.(Discoverer); ok {
+			return discoverer, true
+		}
+		store = unwrap(store)
+	}
+	return nil, false
+}
+
+// unwrap returns the store wrapped by store or nil.
+func unwrap(store Store) Store {
+	if wrapper, ok := store.(interface{ Unwrap() Store }); ok {
+		return wrapper.Unwrap()
+	}
+	return nil
+}
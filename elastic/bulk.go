@@ -0,0 +1,420 @@
+package elastic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/snabble/go-jstore/v2"
+)
+
+// Closed is returned by writes after the store was closed.
+var Closed = errors.New("elastic store is closed")
+
+// BulkOption configures the asynchronous writes enabled by AsyncWrites.
+type BulkOption func(config *bulkConfig)
+
+type bulkConfig struct {
+	flushInterval time.Duration
+	actions       int
+	size          int
+	workers       int
+	queueSize     int
+	onFailure     func(id jstore.EntityID, err error)
+}
+
+// FlushInterval commits the pending writes at least every interval.
+// Default is one second.
+func FlushInterval(interval time.Duration) BulkOption {
+	return func(config *bulkConfig) {
+		config.flushInterval = interval
+	}
+}
+
+// BulkActions commits the pending writes of a worker, when there are n
+// of them. Default is 1000.
+func BulkActions(n int) BulkOption {
+	return func(config *bulkConfig) {
+		config.actions = n
+	}
+}
+
+// BulkSize commits the pending writes of a worker, when their size
+// reaches the bytes. Default is 5 MB.
+func BulkSize(bytes int) BulkOption {
+	return func(config *bulkConfig) {
+		config.size = bytes
+	}
+}
+
+// BulkWorkers sets the number of workers committing concurrently.
+// The writes of a document are always committed by the same worker, so
+// they keep their order. Default is 1.
+func BulkWorkers(n int) BulkOption {
+	return func(config *bulkConfig) {
+		config.workers = n
+	}
+}
+
+// QueueSize limits the number of pending writes. Writes block, while
+// the queue is full. Only commits drain the queue, so without a
+// FlushInterval it must be larger than BulkActions times BulkWorkers.
+// Default is twice that size.
+func QueueSize(n int) BulkOption {
+	return func(config *bulkConfig) {
+		config.queueSize = n
+	}
+}
+
+// OnBulkFailure registers a function, which is called for every failed
+// write. Version conflicts are reported as jstore.OptimisticLockingError,
+// conflicting inserts as jstore.AlreadyExists and deletes of missing
+// documents as jstore.NotFound.
+func OnBulkFailure(fn func(id jstore.EntityID, err error)) BulkOption {
+	return func(config *bulkConfig) {
+		config.onFailure = fn
+	}
+}
+
+// AsyncWrites queues Save, Insert and Delete and commits them in bulk
+// requests in the background. The writes return, before they are
+// committed, so their results have no version and failures are only
+// reported to the OnBulkFailure function. Failed writes are not
+// retried. Flush commits the pending writes and Close commits them
+// before closing the store.
+//
+// The refresh policy of the store is applied by Flush instead of every
+// write.
+func AsyncWrites(options ...BulkOption) ElasticStoreOption {
+	return func(store *ElasticStore) error {
+		config := bulkConfig{
+			flushInterval: time.Second,
+			actions:       1000,
+			size:          5 << 20,
+			workers:       1,
+		}
+		for _, option := range options {
+			option(&config)
+		}
+		if store.timestamps != nil {
+			return errors.New("asynchronous writes can not be combined with timestamp indices")
+		}
+		if config.workers <= 0 {
+			return fmt.Errorf("invalid number of bulk workers %d", config.workers)
+		}
+		if config.queueSize <= 0 {
+			config.queueSize = 2 * config.actions * config.workers
+		}
+
+		bulk := &bulkWriter{
+			config:  config,
+			client:  store.client,
+			ctx:     store.cntx(),
+			queue:   make(chan struct{}, config.queueSize),
+			done:    make(chan struct{}),
+			indices: map[string]bool{},
+		}
+		for i := 0; i < config.workers; i++ {
+			requests := make(chan bulkRequest)
+			flushes := make(chan chan error)
+			bulk.requests = append(bulk.requests, requests)
+			bulk.flushes = append(bulk.flushes, flushes)
+			bulk.workers.Add(1)
+			go bulk.work(requests, flushes)
+		}
+		store.bulk = bulk
+		return nil
+	}
+}
+
+// bulkWriter commits the writes of a store in bulk requests. It is used
+// instead of the BulkProcessor of the client, because that keeps the
+// requests of a failed commit in its workers and sends them again with
+// the next commit, so writes reported as failed are written later.
+// Its workers also share a single queue, so two writes of the same
+// document may be committed out of order by different workers.
+type bulkWriter struct {
+	config bulkConfig
+	client *elastic.Client
+	ctx    context.Context
+
+	// queue holds a token for every pending write
+	queue chan struct{}
+	// requests of the workers, each document is routed to the same
+	// worker by the hash of its id
+	requests []chan bulkRequest
+	// flushes of the workers return the result of the commit
+	flushes []chan chan error
+	done    chan struct{}
+	workers sync.WaitGroup
+
+	// writes hold the mutex shared, Close exclusively
+	mutex  sync.RWMutex
+	closed bool
+
+	// indices written since the last flush
+	indicesMutex sync.Mutex
+	indices      map[string]bool
+}
+
+// bulkRequest remembers the id of a request for the failure callback.
+type bulkRequest struct {
+	elastic.BulkableRequest
+	id        jstore.EntityID
+	operation string
+}
+
+func (bulk *bulkWriter) add(index string, id jstore.EntityID, operation string, request elastic.BulkableRequest) error {
+	bulk.mutex.RLock()
+	defer bulk.mutex.RUnlock()
+
+	if bulk.closed {
+		return Closed
+	}
+	bulk.indicesMutex.Lock()
+	bulk.indices[index] = true
+	bulk.indicesMutex.Unlock()
+
+	hash := fnv.New32a()
+	hash.Write([]byte(id.Project + "/" + id.DocumentType + "/" + id.ID))
+	worker := hash.Sum32() % uint32(len(bulk.requests))
+
+	bulk.queue <- struct{}{}
+	bulk.requests[worker] <- bulkRequest{request, id, operation}
+	return nil
+}
+
+// work collects requests and commits them, when the batch is full, the
+// flush interval passed, or on flush.
+func (bulk *bulkWriter) work(requests chan bulkRequest, flushes chan chan error) {
+	defer bulk.workers.Done()
+
+	var tick <-chan time.Time
+	if bulk.config.flushInterval > 0 {
+		ticker := time.NewTicker(bulk.config.flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	service := bulk.client.Bulk()
+	pending := []bulkRequest{}
+	commit := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		response, err := service.Do(bulk.ctx)
+		// the service keeps the requests of a failed commit
+		service.Reset()
+		bulk.after(pending, response, err)
+		pending = []bulkRequest{}
+		return err
+	}
+
+	for {
+		select {
+		case request := <-requests:
+			service.Add(request.BulkableRequest)
+			pending = append(pending, request)
+			if len(pending) >= bulk.config.actions ||
+				(bulk.config.size > 0 && service.EstimatedSizeInBytes() >= int64(bulk.config.size)) {
+				commit()
+			}
+		case <-tick:
+			commit()
+		case result := <-flushes:
+			result <- commit()
+		case <-bulk.done:
+			commit()
+			return
+		}
+	}
+}
+
+// after releases the queue for the committed requests and reports the
+// failures.
+func (bulk *bulkWriter) after(requests []bulkRequest, response *elastic.BulkResponse, err error) {
+	for range requests {
+		<-bulk.queue
+	}
+	if bulk.config.onFailure == nil {
+		return
+	}
+
+	for i, request := range requests {
+		if err != nil {
+			bulk.config.onFailure(request.id, fmt.Errorf("bulk %s of %v: %w", request.operation, request.id, err))
+			continue
+		}
+		if response == nil || i >= len(response.Items) {
+			continue
+		}
+		for _, item := range response.Items[i] {
+			if itemErr := bulkItemError(request, item); itemErr != nil {
+				bulk.config.onFailure(request.id, itemErr)
+			}
+		}
+	}
+}
+
+func bulkItemError(request bulkRequest, item *elastic.BulkResponseItem) error {
+	if item.Error == nil && item.Status < 300 {
+		return nil
+	}
+	switch {
+	case item.Error != nil && item.Error.Type == "version_conflict_engine_exception" && request.operation == "create":
+		return jstore.AlreadyExists
+	case item.Error != nil && item.Error.Type == "version_conflict_engine_exception":
+		return jstore.OptimisticLockingError
+	case item.Status == http.StatusNotFound && request.operation == "delete":
+		return jstore.NotFound
+	case item.Error != nil:
+		return fmt.Errorf("bulk %s of %v: %s: %s", request.operation, request.id, item.Error.Type, item.Error.Reason)
+	default:
+		return fmt.Errorf("bulk %s of %v: status %d", request.operation, request.id, item.Status)
+	}
+}
+
+// flush commits the pending writes of all workers and returns the
+// indices written since the last flush.
+func (bulk *bulkWriter) flush() ([]string, error) {
+	bulk.indicesMutex.Lock()
+	indices := make([]string, 0, len(bulk.indices))
+	for index := range bulk.indices {
+		indices = append(indices, index)
+	}
+	bulk.indices = map[string]bool{}
+	bulk.indicesMutex.Unlock()
+
+	var firstErr error
+	for _, flushes := range bulk.flushes {
+		result := make(chan error)
+		flushes <- result
+		if err := <-result; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return indices, firstErr
+}
+
+// Flush commits the pending asynchronous writes. Unless the refresh
+// policy is RefreshFalse, the written indices are refreshed, so the
+// writes are visible to searches afterwards.
+func (store *ElasticStore) Flush() error {
+	if store.bulk == nil {
+		return nil
+	}
+	store.bulk.mutex.RLock()
+	defer store.bulk.mutex.RUnlock()
+
+	if store.bulk.closed {
+		return Closed
+	}
+	indices, err := store.bulk.flush()
+	if err != nil {
+		return fmt.Errorf("flushing bulk writes: %w", err)
+	}
+	return store.refreshIndices(indices)
+}
+
+// WritesAsync reports, if the store was opened with AsyncWrites. Reads
+// miss the queued writes of such a store, so jstore rejects timestamps
+// on it.
+func (store *ElasticStore) WritesAsync() bool {
+	return store.bulk != nil
+}
+
+// Close commits the pending asynchronous writes and stops the client.
+// The store must not be used afterwards.
+func (store *ElasticStore) Close() error {
+	if store.bulk != nil {
+		store.bulk.mutex.Lock()
+		if store.bulk.closed {
+			store.bulk.mutex.Unlock()
+			return nil
+		}
+		store.bulk.closed = true
+		store.bulk.mutex.Unlock()
+
+		indices, err := store.bulk.flush()
+		close(store.bulk.done)
+		store.bulk.workers.Wait()
+		if err == nil {
+			err = store.refreshIndices(indices)
+		}
+		if err != nil {
+			return fmt.Errorf("closing bulk writes: %w", err)
+		}
+	}
+	store.client.Stop()
+	return nil
+}
+
+func (store *ElasticStore) refreshIndices(indices []string) error {
+	if len(indices) == 0 || store.refresh == "" || store.refresh == RefreshFalse {
+		return nil
+	}
+	_, err := store.client.Refresh(indices...).Do(store.cntx())
+	// indices are missing, if all their writes failed
+	if err != nil && !elastic.IsNotFound(err) {
+		return fmt.Errorf("refreshing %v: %w", indices, err)
+	}
+	return nil
+}
+
+func (store *ElasticStore) saveAsync(id jstore.EntityID, json string, operation string) (jstore.EntityID, error) {
+	if err := store.prepare(id); err != nil {
+		return jstore.EntityID{}, err
+	}
+	index, exists, err := store.writeIndex(id, json)
+	if err != nil {
+		return jstore.EntityID{}, err
+	}
+	if exists && operation == "create" {
+		return jstore.EntityID{}, jstore.AlreadyExists
+	}
+	request := elastic.NewBulkIndexRequest().
+		Index(index).
+		Id(id.ID).
+		OpType(operation).
+		Doc(json)
+
+	if id.Version != jstore.NoVersion && operation == "index" {
+		version, err := checkVersion(id.Version)
+		if err != nil {
+			return jstore.EntityID{}, err
+		}
+		request.IfSeqNo(version.SeqNo)
+		request.IfPrimaryTerm(version.PrimaryTerm)
+	}
+
+	if err := store.bulk.add(index, id, operation, request); err != nil {
+		return jstore.EntityID{}, err
+	}
+	return jstore.NewID(id.Project, id.DocumentType, id.ID), nil
+}
+
+func (store *ElasticStore) deleteAsync(id jstore.EntityID) error {
+	index, err := store.deleteIndex(id)
+	if err != nil {
+		return err
+	}
+	request := elastic.NewBulkDeleteRequest().
+		Index(index).
+		Id(id.ID)
+
+	if id.Version != jstore.NoVersion {
+		version, err := checkVersion(id.Version)
+		if err != nil {
+			return err
+		}
+		request.IfSeqNo(version.SeqNo)
+		request.IfPrimaryTerm(version.PrimaryTerm)
+	}
+
+	return store.bulk.add(index, id, "delete", request)
+}
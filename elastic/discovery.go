@@ -0,0 +1,170 @@
+package elastic
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/snabble/go-jstore/v2"
+)
+
+// IndexNameParser is the reverse of an IndexNamer. It returns the
+// project and the document type of an index created by the store.
+type IndexNameParser func(index string) (project, documentType string, ok bool)
+
+// ParseIndexName allows to insert a custom IndexNameParser, which
+// matches a custom IndexNamer.
+func ParseIndexName(parser IndexNameParser) ElasticStoreOption {
+	return func(store *ElasticStore) error {
+		store.parseIndexName = parser
+		return nil
+	}
+}
+
+// LastModifiedProperty sets the date property holding the time of the
+// last modification of a document, e.g. "updatedAt" maintained by
+// jstore.Timestamps. Stats returns its maximum as LastModified.
+func LastModifiedProperty(property string) ElasticStoreOption {
+	return func(store *ElasticStore) error {
+		store.lastModifiedProperty = property
+		return nil
+	}
+}
+
+var (
+	dateSuffix     = regexp.MustCompile(`-\d{4}\.(\d{2}\.\d{2}|\d{2}|w\d{2})$`)
+	rolloverSuffix = regexp.MustCompile(`-\d{6}$`)
+)
+
+// defaultIndexNameParser parses the index names of the default
+// IndexNamer, the DailyIndexNamer, the RolloverIndexNamer, the
+// TimestampIndex and the versioned indices of mapped document types.
+// The document type is expected to contain no dashes. As index names
+// are lower case, project and document type are returned in lower
+// case.
+func defaultIndexNameParser(index string) (string, string, bool) {
+	if strings.HasPrefix(index, ".") {
+		return "", "", false
+	}
+	index = versionSuffix.ReplaceAllString(index, "")
+	index = dateSuffix.ReplaceAllString(index, "")
+	index = rolloverSuffix.ReplaceAllString(index, "")
+	i := strings.LastIndex(index, "-")
+	if i <= 0 || i == len(index)-1 {
+		return "", "", false
+	}
+	return index[:i], index[i+1:], true
+}
+
+func (store *ElasticStore) ListProjects() ([]string, error) {
+	names, err := store.indexNames()
+	if err != nil {
+		return nil, err
+	}
+
+	projects := map[string]bool{}
+	for _, name := range names {
+		if project, _, ok := store.parseIndexName(name); ok {
+			projects[project] = true
+		}
+	}
+	return sortedSet(projects), nil
+}
+
+func (store *ElasticStore) ListDocumentTypes(project string) ([]string, error) {
+	names, err := store.indexNames()
+	if err != nil {
+		return nil, err
+	}
+
+	documentTypes := map[string]bool{}
+	for _, name := range names {
+		if p, documentType, ok := store.parseIndexName(name); ok && p == project {
+			documentTypes[documentType] = true
+		}
+	}
+	if len(documentTypes) == 0 {
+		return []string{}, jstore.NotFound
+	}
+	return sortedSet(documentTypes), nil
+}
+
+// Stats returns count and primary store size of the document type.
+// Elasticsearch does not track the last modification, so it is only
+// returned with a LastModifiedProperty and left empty otherwise.
+func (store *ElasticStore) Stats(project, documentType string) (jstore.Stats, error) {
+	index := store.indexName(project, documentType, true)
+
+	stats, err := store.countDocuments(index)
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return jstore.Stats{}, jstore.NotFound
+		}
+		return jstore.Stats{}, err
+	}
+
+	rows, err := store.client.CatIndices().
+		Index(index).
+		Bytes("b").
+		Do(store.cntx())
+	if err != nil {
+		return jstore.Stats{}, err
+	}
+
+	for _, row := range rows {
+		size, _ := strconv.ParseInt(row.PriStoreSize, 10, 64)
+		stats.SizeInBytes += size
+	}
+	return stats, nil
+}
+
+// countDocuments returns the count and the last modification of the
+// documents in the index.
+func (store *ElasticStore) countDocuments(index string) (jstore.Stats, error) {
+	if store.lastModifiedProperty == "" {
+		count, err := store.client.Count(index).Do(store.cntx())
+		return jstore.Stats{Count: count}, err
+	}
+
+	resp, err := store.client.Search(index).
+		Size(0).
+		TrackTotalHits(true).
+		Aggregation("lastModified", elastic.NewMaxAggregation().Field(store.lastModifiedProperty)).
+		Do(store.cntx())
+	if err != nil {
+		return jstore.Stats{}, err
+	}
+	stats := jstore.Stats{Count: resp.TotalHits()}
+	// the maximum of a date field is in epoch milliseconds
+	if max, ok := resp.Aggregations.Max("lastModified"); ok && max.Value != nil {
+		stats.LastModified = time.Unix(0, int64(*max.Value)*int64(time.Millisecond)).UTC()
+	}
+	return stats, nil
+}
+
+func (store *ElasticStore) indexNames() ([]string, error) {
+	rows, err := store.client.CatIndices().
+		Columns("index").
+		Do(store.cntx())
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(rows))
+	for _, row := range rows {
+		names = append(names, row.Index)
+	}
+	return names, nil
+}
+
+func sortedSet(set map[string]bool) []string {
+	result := make([]string, 0, len(set))
+	for name := range set {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
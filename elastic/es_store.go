@@ -2,7 +2,9 @@ package elastic
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
@@ -24,6 +26,15 @@ type IndexNamer func(project, documentType string, matchAll bool) string
 
 type ElasticStoreOption func(store *ElasticStore) error
 
+// Refresh policies for write operations. For details see:
+//
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-refresh.html
+const (
+	RefreshFalse   = "false"
+	RefreshTrue    = "true"
+	RefreshWaitFor = "wait_for"
+)
+
 // IndexName allows to insert a custom IndexNamer
 func IndexName(provider IndexNamer) ElasticStoreOption {
 	return func(store *ElasticStore) error {
@@ -39,9 +50,22 @@ func DefaultIndexName() ElasticStoreOption {
 	}
 }
 
+// SyncUpdates forces a refresh of the affected shards on every write.
 func SyncUpdates() ElasticStoreOption {
+	return Refresh(RefreshTrue)
+}
+
+// WaitForRefresh lets every write wait for the next scheduled
+// refresh. This is cheaper than SyncUpdates, but still makes the
+// writes visible to subsequent searches.
+func WaitForRefresh() ElasticStoreOption {
+	return Refresh(RefreshWaitFor)
+}
+
+// Refresh sets the refresh policy used for all writes of the store.
+func Refresh(policy string) ElasticStoreOption {
 	return func(store *ElasticStore) error {
-		store.syncUpdates = true
+		store.refresh = policy
 		return nil
 	}
 }
@@ -78,10 +102,23 @@ func init() {
 }
 
 type ElasticStore struct {
-	client        *elastic.Client
-	syncUpdates   bool
-	healthTimeout time.Duration
-	indexName     IndexNamer
+	client         *elastic.Client
+	refresh        string
+	healthTimeout  time.Duration
+	scanBatchSize  int
+	indexName      IndexNamer
+	parseIndexName IndexNameParser
+	bulk           *bulkWriter
+
+	// prepareIndex creates the index of a document type before writes,
+	// if the IndexNamer needs it
+	prepareIndex func(store *ElasticStore, project, documentType string) error
+	// mappings registered by lower case document type
+	mappings map[string]map[string]interface{}
+	// timestamps routes documents by their timestamp, if set
+	timestamps *timestampRouter
+	// lastModifiedProperty is aggregated by Stats, if set
+	lastModifiedProperty string
 }
 
 func NewElasticStore(baseURL string, options ...jstore.StoreOption) (*ElasticStore, error) {
@@ -98,10 +135,12 @@ func NewElasticStore(baseURL string, options ...jstore.StoreOption) (*ElasticSto
 	}
 
 	store := &ElasticStore{
-		client:        client,
-		syncUpdates:   false,
-		healthTimeout: time.Second,
-		indexName:     defaultIndexName,
+		client:         client,
+		refresh:        RefreshFalse,
+		healthTimeout:  time.Second,
+		scanBatchSize:  1000,
+		indexName:      defaultIndexName,
+		parseIndexName: defaultIndexNameParser,
 	}
 
 	for _, option := range options {
@@ -115,6 +154,29 @@ func NewElasticStore(baseURL string, options ...jstore.StoreOption) (*ElasticSto
 	return store, nil
 }
 
+func (store *ElasticStore) prepare(id jstore.EntityID) error {
+	if store.prepareIndex == nil {
+		return nil
+	}
+	return store.prepareIndex(store, id.Project, id.DocumentType)
+}
+
+// WithRefresh returns a copy of the store, which uses the given
+// refresh policy for its writes, e.g.
+//
+//	store.WithRefresh(RefreshWaitFor).Save(id, json)
+//
+// With AsyncWrites, the copy shares the queue of the store, so its
+// writes are queued like all others and the policy only applies to
+// Flush of the copy:
+//
+//	store.WithRefresh(RefreshTrue).Flush()
+func (store *ElasticStore) WithRefresh(policy string) *ElasticStore {
+	copy := *store
+	copy.refresh = policy
+	return &copy
+}
+
 func (store *ElasticStore) HealthCheck() error {
 	cntx, cancelFunc := context.WithTimeout(store.cntx(), store.healthTimeout)
 	defer cancelFunc()
@@ -130,8 +192,16 @@ func (store *ElasticStore) HealthCheck() error {
 }
 
 func (store *ElasticStore) Delete(id jstore.EntityID) error {
+	if store.bulk != nil {
+		return store.deleteAsync(id)
+	}
+
+	index, err := store.deleteIndex(id)
+	if err != nil {
+		return err
+	}
 	query := store.client.Delete().
-		Index(store.indexName(id.Project, id.DocumentType, false)).
+		Index(index).
 		Id(id.ID)
 
 	if id.Version != jstore.NoVersion {
@@ -142,11 +212,11 @@ func (store *ElasticStore) Delete(id jstore.EntityID) error {
 		query.IfSeqNo(version.SeqNo)
 		query.IfPrimaryTerm(version.PrimaryTerm)
 	}
-	if store.syncUpdates {
-		query = query.Refresh("true")
+	if store.refresh != "" && store.refresh != RefreshFalse {
+		query = query.Refresh(store.refresh)
 	}
 
-	_, err := query.Do(store.cntx())
+	_, err = query.Do(store.cntx())
 
 	if err != nil {
 		if e, ok := err.(*elastic.Error); ok && e.Details != nil && e.Details.Type == "version_conflict_engine_exception" {
@@ -159,8 +229,19 @@ func (store *ElasticStore) Delete(id jstore.EntityID) error {
 }
 
 func (store *ElasticStore) Save(id jstore.EntityID, json string) (jstore.EntityID, error) {
+	if err := store.prepare(id); err != nil {
+		return jstore.EntityID{}, err
+	}
+	if store.bulk != nil {
+		return store.saveAsync(id, json, "index")
+	}
+
+	index, _, err := store.writeIndex(id, json)
+	if err != nil {
+		return jstore.EntityID{}, err
+	}
 	query := store.client.Index().
-		Index(store.indexName(id.Project, id.DocumentType, false)).
+		Index(index).
 		Id(id.ID).
 		BodyString(json)
 
@@ -173,14 +254,14 @@ func (store *ElasticStore) Save(id jstore.EntityID, json string) (jstore.EntityI
 		query.IfPrimaryTerm(version.PrimaryTerm)
 	}
 
-	if store.syncUpdates {
-		query = query.Refresh("true")
+	if store.refresh != "" && store.refresh != RefreshFalse {
+		query = query.Refresh(store.refresh)
 	}
 
 	resp, err := query.Do(store.cntx())
 
 	if err != nil {
-		if e, ok := err.(*elastic.Error); ok && e.Details.Type == "version_conflict_engine_exception" {
+		if e, ok := err.(*elastic.Error); ok && e.Details != nil && e.Details.Type == "version_conflict_engine_exception" {
 			return jstore.EntityID{}, jstore.OptimisticLockingError
 		}
 		return id, err
@@ -194,8 +275,92 @@ func (store *ElasticStore) Save(id jstore.EntityID, json string) (jstore.EntityI
 	}, nil
 }
 
+// SaveRaw saves the document without copying raw, because the body is
+// only read during the request. With AsyncWrites, the document is
+// queued beyond the call, so raw is copied.
+func (store *ElasticStore) SaveRaw(id jstore.EntityID, raw json.RawMessage) (jstore.EntityID, error) {
+	if store.bulk != nil {
+		return store.Save(id, string(raw))
+	}
+	return store.Save(id, jstore.NewRawEntity(id, raw).JSON)
+}
+
+// Insert indexes the document with op_type=create, so it fails with
+// jstore.AlreadyExists, if the document exists in the write index. With
+// AsyncWrites, the failure is reported to the OnBulkFailure function.
+func (store *ElasticStore) Insert(id jstore.EntityID, json string) (jstore.EntityID, error) {
+	if err := store.prepare(id); err != nil {
+		return jstore.EntityID{}, err
+	}
+	if store.bulk != nil {
+		return store.saveAsync(id, json, "create")
+	}
+
+	index, exists, err := store.writeIndex(id, json)
+	if err != nil {
+		return jstore.EntityID{}, err
+	}
+	if exists {
+		return jstore.EntityID{}, jstore.AlreadyExists
+	}
+	query := store.client.Index().
+		Index(index).
+		Id(id.ID).
+		OpType("create").
+		BodyString(json)
+
+	if store.refresh != "" && store.refresh != RefreshFalse {
+		query = query.Refresh(store.refresh)
+	}
+
+	resp, err := query.Do(store.cntx())
+
+	if err != nil {
+		if e, ok := err.(*elastic.Error); ok && (e.Status == http.StatusConflict || e.Details != nil && e.Details.Type == "version_conflict_engine_exception") {
+			return jstore.EntityID{}, jstore.AlreadyExists
+		}
+		return jstore.EntityID{}, fmt.Errorf("inserting entity %v: %w", id, err)
+	}
+
+	return jstore.EntityID{
+		Project:      id.Project,
+		DocumentType: id.DocumentType,
+		ID:           resp.Id,
+		Version:      Version{SeqNo: resp.SeqNo, PrimaryTerm: resp.PrimaryTerm},
+	}, nil
+}
+
+// Get uses the realtime document GET api, so a document is found
+// directly after it was saved, even without refresh.
+//
+// If the IndexNamer spreads the documents over multiple indices, the
+// document can not be addressed directly and Get falls back to a
+// search.
 func (store *ElasticStore) Get(id jstore.EntityID) (jstore.Entity, error) {
-	return store.Find(id.Project, id.DocumentType, jstore.Id(id.ID))
+	index := store.indexName(id.Project, id.DocumentType, false)
+	if index != store.indexName(id.Project, id.DocumentType, true) {
+		return store.Find(id.Project, id.DocumentType, jstore.Id(id.ID))
+	}
+
+	resp, err := store.client.Get().
+		Index(index).
+		Id(id.ID).
+		Do(store.cntx())
+
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return jstore.Entity{}, jstore.NotFound
+		}
+		return jstore.Entity{}, fmt.Errorf("getting entity %v: %w", id, err)
+	}
+	if !resp.Found {
+		return jstore.Entity{}, jstore.NotFound
+	}
+
+	return jstore.NewRawEntity(
+		toVersionedID(id.Project, id.DocumentType, resp.Id, resp.SeqNo, resp.PrimaryTerm),
+		resp.Source,
+	), nil
 }
 
 func (store *ElasticStore) Find(project, documentType string, options ...jstore.Option) (jstore.Entity, error) {
@@ -259,11 +424,7 @@ func (store *ElasticStore) cntx() context.Context {
 }
 
 func toEntity(project, documentType string, hit *elastic.SearchHit) jstore.Entity {
-	return jstore.Entity{
-		EntityID:  toEntityID(project, documentType, hit),
-		ObjectRef: nil,
-		JSON:      string(hit.Source),
-	}
+	return jstore.NewRawEntity(toEntityID(project, documentType, hit), hit.Source)
 }
 
 type Version struct {
@@ -280,24 +441,41 @@ func checkVersion(version jstore.Version) (Version, error) {
 }
 
 func toEntityID(project, documentType string, hit *elastic.SearchHit) jstore.EntityID {
+	return toVersionedID(project, documentType, hit.Id, hit.SeqNo, hit.PrimaryTerm)
+}
+
+func toVersionedID(project, documentType, id string, seqNo, primaryTerm *int64) jstore.EntityID {
 	version := Version{}
-	if hit.SeqNo != nil {
-		version.SeqNo = *hit.SeqNo
+	if seqNo != nil {
+		version.SeqNo = *seqNo
 	}
-	if hit.PrimaryTerm != nil {
-		version.PrimaryTerm = *hit.PrimaryTerm
+	if primaryTerm != nil {
+		version.PrimaryTerm = *primaryTerm
 	}
 	return jstore.EntityID{
 		Project:      project,
 		DocumentType: documentType,
-		ID:           hit.Id,
+		ID:           id,
 		Version:      version,
 	}
 }
 
 func (store *ElasticStore) createSearch(project, documentType string, options ...jstore.Option) (*elastic.SearchService, error) {
+	query, sorters, err := createQuery(options...)
+	if err != nil {
+		return nil, err
+	}
+
 	search := store.SearchIn(project, documentType).SeqNoPrimaryTerm(true)
+	if len(sorters) > 0 {
+		search = search.SortBy(sorters...)
+	}
+	return search.Query(query), nil
+}
+
+func createQuery(options ...jstore.Option) (elastic.Query, []elastic.Sorter, error) {
 	boolQuery := elastic.NewBoolQuery()
+	sorters := []elastic.Sorter{}
 	for _, o := range options {
 		switch o := o.(type) {
 		case jstore.IdOption:
@@ -315,16 +493,25 @@ func (store *ElasticStore) createSearch(project, documentType string, options ..
 			case ">=":
 				boolQuery.Must(elastic.NewRangeQuery(o.Property).Gte(o.Value))
 			default:
-				return nil, fmt.Errorf("unsupported compare option: %s", o.Operation)
+				return nil, nil, fmt.Errorf("unsupported compare option: %s", o.Operation)
 			}
-		case jstore.SortOption:
-			search = search.Sort(o.Property, o.Ascending)
+		case jstore.SortOption, jstore.ModifiedSortOption:
+			sort, _ := jstore.AsSortOption(o)
+			sorter := elastic.NewFieldSort(sort.Property).Order(sort.Ascending)
+			if sort.MissingFirst {
+				sorter = sorter.Missing("_first")
+			}
+			sorters = append(sorters, sorter)
 		default:
-			return nil, fmt.Errorf("unsupported option: %v", o)
+			return nil, nil, fmt.Errorf("unsupported option: %v", o)
 		}
 	}
+	if len(sorters) > 0 {
+		// ties are ordered by id like in the memory store
+		sorters = append(sorters, elastic.NewFieldSort("_id").Asc())
+	}
 
-	return search.Query(boolQuery), nil
+	return boolQuery, sorters, nil
 }
 
 func defaultIndexName(project, documentType string, _ bool) string {
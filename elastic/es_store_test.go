@@ -4,16 +4,23 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"path"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
 
 	"github.com/olivere/elastic/v7"
 	"github.com/snabble/go-jstore/v2"
+	"github.com/snabble/go-jstore/v2/jstoretest"
+	"github.com/snabble/go-jstore/v2/memory"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -196,281 +203,731 @@ func Test_TimeBasedIndex(t *testing.T) {
 	assert.Error(t, err)
 }
 
-func Test_OptimisticLocking_Update(t *testing.T) {
+func Test_TimestampIndex(t *testing.T) {
 	project := randStringBytes(10)
-	store, _ := jstore.NewStore(
-		"elastic",
+	store, err := NewElasticStore(
 		esTestURL(),
 		SyncUpdates(),
 		elastic.SetSniff(false),
+		TimestampIndex("meta.created", Daily),
 	)
+	require.NoError(t, err)
 
-	id, err := store.Marshal(ford, jstore.NewID(project, "person", "ford"))
+	// backfilled
+	old, err := store.Save(jstore.NewID(project, "event", "old"), `{"meta": {"created": "2018-06-01T23:59:00Z"}}`)
+	require.NoError(t, err)
+	_, err = store.Save(jstore.NewID(project, "event", "new"), `{"meta": {"created": 1528070400000}}`)
+	require.NoError(t, err)
+
+	for _, index := range []string{project + "-event-2018.06.01", project + "-event-2018.06.04"} {
+		exists, err := store.client.IndexExists(index).Do(context.Background())
+		require.NoError(t, err)
+		assert.True(t, exists, index)
+	}
 
+	// updates stay in the index of the document
+	entity, err := store.Get(old)
+	require.NoError(t, err)
+	updated, err := store.Save(entity.EntityID, `{"meta": {"created": "2018-06-03T00:00:00Z"}, "updated": true}`)
 	require.NoError(t, err)
-	assert.NotNil(t, id.Version)
+	_, err = store.Save(entity.EntityID, `{"meta": {"created": "2018-06-01T00:00:00Z"}}`)
+	assert.Equal(t, jstore.OptimisticLockingError, err)
 
-	updatedID, err := store.Marshal(Person{"Ford Prefect", 43, day("1980-01-01")}, id)
+	entities, err := store.FindN(project, "event", 10)
 	require.NoError(t, err)
-	assert.NotNil(t, updatedID.Version)
-	assert.NotEqual(t, id.Version, updatedID.Version)
+	assert.Len(t, entities, 2)
 
-	_, err = store.Marshal(Person{"Ford Prefect", 41, day("1980-01-01")}, id)
+	_, err = store.Insert(jstore.NewID(project, "event", "old"), `{"meta": {"created": "2018-06-05T00:00:00Z"}}`)
+	assert.Equal(t, jstore.AlreadyExists, err)
 
-	assert.Equal(t, jstore.OptimisticLockingError, err)
+	_, err = store.Save(jstore.NewID(project, "event", "invalid"), `{"meta": {}}`)
+	assert.Error(t, err)
+
+	require.NoError(t, store.Delete(updated))
+	_, err = store.Get(old)
+	assert.Equal(t, jstore.NotFound, err)
+	assert.Equal(t, jstore.NotFound, store.Delete(old))
+
+	documentTypes, err := store.ListDocumentTypes(project)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"event"}, documentTypes)
 }
 
-func Test_OptimisticLocking_Delete(t *testing.T) {
+func Test_TimestampIndex_Realtime(t *testing.T) {
 	project := randStringBytes(10)
-	store, _ := jstore.NewStore(
-		"elastic",
+	store, err := NewElasticStore(
 		esTestURL(),
-		SyncUpdates(),
 		elastic.SetSniff(false),
+		TimestampIndex("created", Monthly),
 	)
+	require.NoError(t, err)
+	documentType := "event"
+	id := jstore.NewID(project, documentType, "1")
 
-	id, err := store.Marshal(ford, jstore.NewID(project, "person", "ford"))
+	// the writes are not refreshed
+	_, err = store.Save(id, `{"created": "2018-06-01T00:00:00Z"}`)
+	require.NoError(t, err)
+	_, err = store.Save(id, `{"created": "2018-07-01T00:00:00Z"}`)
 	require.NoError(t, err)
-	store.Marshal(Person{"Ford Prefect", 43, day("1980-01-01")}, id)
+	_, err = store.Insert(id, `{"created": "2018-08-01T00:00:00Z"}`)
+	assert.Equal(t, jstore.AlreadyExists, err)
+	require.NoError(t, store.Delete(id))
+	assert.Equal(t, jstore.NotFound, store.Delete(id))
 
-	err = store.Delete(id)
+	_, err = store.client.Refresh(project + "-event-*").Do(context.Background())
+	require.NoError(t, err)
+	count, err := store.client.Count(project + "-event-*").Do(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+}
 
-	assert.Equal(t, jstore.OptimisticLockingError, err)
+func Test_TimestampIndex_Options(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	for _, options := range [][]jstore.StoreOption{
+		{AsyncWrites(), TimestampIndex("created", Daily)},
+		{TimestampIndex("created", Daily), AsyncWrites()},
+		{TimestampIndex("created", Granularity(42))},
+	} {
+		options = append(options, elastic.SetSniff(false), elastic.SetHealthcheck(false))
+		_, err := NewElasticStore(server.URL, options...)
+		assert.Error(t, err)
+	}
+}
+
+func Test_TimestampIndex_DashedDocumentType(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	store, err := NewElasticStore(server.URL,
+		elastic.SetSniff(false),
+		elastic.SetHealthcheck(false),
+		TimestampIndex("created", Daily),
+	)
+	require.NoError(t, err)
+
+	id := jstore.NewID("project", "event-archive", "1")
+	_, err = store.Save(id, `{"created": "2018-06-01T12:00:00Z"}`)
+	assert.Error(t, err)
+	_, err = store.Insert(id, `{"created": "2018-06-01T12:00:00Z"}`)
+	assert.Error(t, err)
+	assert.Error(t, store.Delete(id))
+	assert.Zero(t, requests)
 }
 
-func Test_FindInMissingProject(t *testing.T) {
-	b, err := jstore.NewBucket(
+func Test_TimestampIndex_Names(t *testing.T) {
+	id := jstore.NewID("Project", "Event", "1")
+	for _, test := range []struct {
+		granularity Granularity
+		document    string
+		index       string
+	}{
+		{Daily, `{"created": "2018-06-01T23:59:00-02:00"}`, "project-event-2018.06.02"},
+		{Weekly, `{"created": "2018-12-31T00:00:00Z"}`, "project-event-2019.w01"},
+		{Monthly, `{"created": 1527811200000}`, "project-event-2018.06"},
+	} {
+		router := &timestampRouter{property: []string{"created"}, granularity: test.granularity}
+		index, err := router.documentIndex(id, test.document)
+		require.NoError(t, err)
+		assert.Equal(t, test.index, index)
+		project, documentType, ok := defaultIndexNameParser(index)
+		assert.True(t, ok)
+		assert.Equal(t, "project", project)
+		assert.Equal(t, "event", documentType)
+	}
+
+	router := &timestampRouter{property: []string{"created"}, granularity: Monthly}
+	for _, document := range []string{`{}`, `{"created": "yesterday"}`, `{"created": true}`, `[]`} {
+		_, err := router.documentIndex(id, document)
+		assert.Error(t, err, document)
+	}
+
+	namer := router.indexNamer(func() time.Time { return day("2018-06-15") })
+	assert.Equal(t, "project-event-2018.06", namer("Project", "Event", false))
+	assert.Equal(t, "project-event-*", namer("Project", "Event", true))
+}
+
+func Test_Get_Realtime(t *testing.T) {
+	project := randStringBytes(10)
+	store, err := jstore.NewStore(
 		"elastic",
 		esTestURL(),
-		randStringBytes(10),
-		"person",
-		SyncUpdates(),
 		elastic.SetSniff(false),
 	)
-	assert.NoError(t, err)
+	require.NoError(t, err)
 
-	// find one person by id
-	_, err = b.Find(jstore.Id("ford"))
+	id, err := store.Marshal(ford, jstore.NewID(project, "person", "ford"))
+	require.NoError(t, err)
+
+	// found without refresh
+	entity, err := store.Get(jstore.NewID(project, "person", "ford"))
+	require.NoError(t, err)
+	assert.Equal(t, id, entity.EntityID)
+
+	_, err = store.Get(jstore.NewID(project, "person", "zaphod"))
+	assert.Equal(t, jstore.NotFound, err)
+
+	_, err = store.Get(jstore.NewID(randStringBytes(10), "person", "ford"))
 	assert.Equal(t, jstore.NotFound, err)
 }
 
-func Test_CompareOptions(t *testing.T) {
+func Test_WaitForRefresh(t *testing.T) {
 	project := randStringBytes(10)
-	b, err := jstore.NewBucket(
-		"elastic",
+	esStore, err := NewElasticStore(
 		esTestURL(),
-		project,
-		"person",
-		SyncUpdates(),
-		IndexTemplate("template-person-test", personMapping),
 		elastic.SetSniff(false),
 	)
+	require.NoError(t, err)
 
-	assert.NoError(t, err)
+	_, err = esStore.WithRefresh(RefreshWaitFor).Save(jstore.NewID(project, "person", "ford"), `{"name":"Ford Prefect"}`)
+	require.NoError(t, err)
 
-	_, err = b.Marshal(ford, jstore.NewID(project, "persons", "ford"))
-	assert.NoError(t, err)
-	_, err = b.Marshal(marvin, jstore.NewID(project, "persons", "marvin"))
-	assert.NoError(t, err)
-	_, err = b.Marshal(zaphod, jstore.NewID(project, "persons", "zaphod"))
-	assert.NoError(t, err)
+	entities, err := esStore.FindN(project, "person", 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(entities))
+}
 
-	tests := []struct {
-		name     string
-		options  []jstore.Option
-		expected *Person
-	}{
-		{
-			"integer equal",
-			[]jstore.Option{jstore.Eq("age", 42)},
-			&ford,
-		},
-		{
-			"string equal",
-			[]jstore.Option{jstore.Eq("name", "Ford Prefect")},
-			&ford,
-		},
-		{
-			" > ",
-			[]jstore.Option{jstore.Gt("age", 2000)},
-			&zaphod,
-		},
-		{
-			" > not found",
-			[]jstore.Option{jstore.Gt("age", 4200)},
-			nil,
-		},
-		{
-			" >= ",
-			[]jstore.Option{jstore.Gte("age", 4200)},
-			&zaphod,
-		},
-		{
-			" < not found",
-			[]jstore.Option{jstore.Lt("age", 42)},
-			nil,
-		},
-		{
-			" <= ",
-			[]jstore.Option{jstore.Lte("age", 42)},
-			&ford,
-		},
-		{
-			" gt and lt ",
-			[]jstore.Option{jstore.Gt("age", 42), jstore.Lt("age", 4200)},
-			&marvin,
-		},
-		{
-			"date =",
-			[]jstore.Option{jstore.Eq("birthDay", day("2042-01-01"))},
-			&marvin,
-		},
-		{
-			" < on date",
-			[]jstore.Option{jstore.Lt("birthDay", day("1980-01-01"))},
-			&zaphod,
-		},
-		{
-			" <= and >= on date",
-			[]jstore.Option{jstore.Lte("birthDay", day("1980-01-01")), jstore.Gte("birthDay", day("1980-01-01"))},
-			&ford,
-		},
-	}
+func Test_Conformance(t *testing.T) {
+	jstoretest.RunConformance(t, func(t *testing.T) jstore.Store {
+		store, err := NewElasticStore(
+			esTestURL(),
+			SyncUpdates(),
+			elastic.SetSniff(false),
+		)
+		require.NoError(t, err)
+		return store
+	})
+}
+
+func Test_Differential(t *testing.T) {
+	esStore, err := NewElasticStore(
+		esTestURL(),
+		SyncUpdates(),
+		elastic.SetSniff(false),
+	)
+	require.NoError(t, err)
+	memoryStore, err := memory.NewMemoryStore("")
+	require.NoError(t, err)
 
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
+	jstoretest.RunDifferential(t, memoryStore, esStore)
+}
 
-			result := &Person{}
-			err = b.Unmarshal(&result, test.options...)
-			if test.expected == nil {
-				assert.Equal(t, jstore.NotFound, err)
-			} else {
-				assert.NoError(t, err)
-				assert.Equal(t, test.expected, result)
-			}
-		})
+func Test_CreateQuery_SortTiebreak(t *testing.T) {
+	_, sorters, err := createQuery(jstore.SortBy("rank", true, jstore.MissingFirst()), jstore.SortBy("score", false))
+	require.NoError(t, err)
+
+	sources := []interface{}{}
+	for _, sorter := range sorters {
+		source, err := sorter.Source()
+		require.NoError(t, err)
+		sources = append(sources, source)
 	}
+	j, err := json.Marshal(sources)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"rank": {"order": "asc", "missing": "_first"}},
+		{"score": {"order": "desc"}},
+		{"_id": {"order": "asc"}}
+	]`, string(j))
+
+	_, sorters, err = createQuery(jstore.Id("ford"))
+	require.NoError(t, err)
+	assert.Empty(t, sorters)
 }
 
-func Test_FindN(t *testing.T) {
+func Test_Scan_Batches(t *testing.T) {
 	project := randStringBytes(10)
-	b, err := jstore.NewBucket(
-		"elastic",
+	esStore, err := NewElasticStore(
 		esTestURL(),
-		project,
-		"person",
 		SyncUpdates(),
+		ScanBatchSize(7),
 		elastic.SetSniff(false),
 	)
-	assert.NoError(t, err)
+	require.NoError(t, err)
 
 	for i := 0; i < 50; i++ {
-		p := Person{
-			Name: "person-" + strconv.Itoa(i),
-			Age:  i,
-		}
-		_, err := b.Marshal(p, jstore.NewID(project, "person", strconv.Itoa(i)))
-		assert.NoError(t, err)
+		_, err := esStore.Save(jstore.NewID(project, "person", strconv.Itoa(i)), fmt.Sprintf(`{"age":%d}`, i))
+		require.NoError(t, err)
 	}
 
-	// find a subset
-	docs, err := b.FindN(20)
-	assert.NoError(t, err)
-	assert.Equal(t, 20, len(docs))
+	it := esStore.Scan(project, "person")
+	defer it.Close()
 
-	for _, d := range docs {
-		p := Person{}
-		err = json.Unmarshal([]byte(d.JSON), &p)
-		assert.NoError(t, err)
-		assert.Contains(t, p.Name, "person-")
+	ids := map[string]bool{}
+	for it.Next() {
+		ids[it.Entity().ID] = true
 	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, 50, len(ids))
+}
 
-	// find all
-	docs, err = b.FindN(1000)
-	assert.NoError(t, err)
-	assert.Equal(t, 50, len(docs))
+func Test_Discovery(t *testing.T) {
+	project := randStringBytes(10)
+	esStore, err := NewElasticStore(
+		esTestURL(),
+		SyncUpdates(),
+		elastic.SetSniff(false),
+	)
+	require.NoError(t, err)
+
+	_, err = esStore.Save(jstore.NewID(project, "person", "ford"), `{"name":"Ford Prefect"}`)
+	require.NoError(t, err)
+	_, err = esStore.Save(jstore.NewID(project, "spaceship", "heartOfGold"), `{"name":"Heart Of Gold"}`)
+	require.NoError(t, err)
+
+	projects, err := esStore.ListProjects()
+	require.NoError(t, err)
+	assert.Contains(t, projects, project)
+
+	documentTypes, err := esStore.ListDocumentTypes(project)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"person", "spaceship"}, documentTypes)
+
+	stats, err := esStore.Stats(project, "person")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Count)
+	assert.True(t, stats.SizeInBytes > 0)
+
+	_, err = esStore.Stats(project, "unknown")
+	assert.Equal(t, jstore.NotFound, err)
+}
+
+func Test_Stats_LastModified(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/project-person/_search":
+			body, _ := io.ReadAll(r.Body)
+			assert.Contains(t, string(body), `"max":{"field":"updatedAt"}`)
+			w.Write([]byte(`{"hits": {"total": {"value": 2, "relation": "eq"}},
+				"aggregations": {"lastModified": {"value": 2272190400000, "value_as_string": "2042-01-01T12:00:00.000Z"}}}`))
+		case "/_cat/indices/project-person":
+			w.Write([]byte(`[{"index": "project-person", "pri.store.size": "1024"}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	store, err := NewElasticStore(server.URL, elastic.SetSniff(false), elastic.SetHealthcheck(false), LastModifiedProperty("updatedAt"))
+	require.NoError(t, err)
+
+	stats, err := store.Stats("project", "person")
+	require.NoError(t, err)
+	assert.Equal(t, jstore.Stats{
+		Count:        2,
+		SizeInBytes:  1024,
+		LastModified: time.Date(2042, 1, 1, 12, 0, 0, 0, time.UTC),
+	}, stats)
 }
 
-func Test_FindN_SortBy(t *testing.T) {
+func Test_DefaultIndexNameParser(t *testing.T) {
+	for _, test := range []struct {
+		index                string
+		project, documentype string
+		ok                   bool
+	}{
+		{"project-person", "project", "person", true},
+		{"my-project-person", "my-project", "person", true},
+		{"project-person-2018.06.01", "project", "person", true},
+		{"project-person-000002", "project", "person", true},
+		{"project-person_v2", "project", "person", true},
+		{"project-person-2018.06", "project", "person", true},
+		{"project-person-2018.w22", "project", "person", true},
+		{".kibana", "", "", false},
+		{"person", "", "", false},
+	} {
+		t.Run(test.index, func(t *testing.T) {
+			project, documentType, ok := defaultIndexNameParser(test.index)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.project, project)
+			assert.Equal(t, test.documentype, documentType)
+		})
+	}
+}
+
+func Test_AsyncWrites(t *testing.T) {
 	project := randStringBytes(10)
-	b, err := jstore.NewBucket(
-		"elastic",
+	failures := make(chan error, 10)
+	store, err := NewElasticStore(
 		esTestURL(),
-		project,
-		"person",
 		SyncUpdates(),
 		elastic.SetSniff(false),
+		AsyncWrites(BulkActions(10), BulkWorkers(2), OnBulkFailure(func(id jstore.EntityID, err error) {
+			failures <- err
+		})),
 	)
-	assert.NoError(t, err)
+	require.NoError(t, err)
+	defer store.Close()
 
-	for i := 0; i < 50; i++ {
-		p := Person{
-			Name: "person-" + strconv.Itoa(i),
-			Age:  i,
+	for i := 0; i < 25; i++ {
+		_, err := store.Save(jstore.NewID(project, "event", strconv.Itoa(i)), fmt.Sprintf(`{"count":%d}`, i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, store.Flush())
+
+	entities, err := store.FindN(project, "event", 100)
+	require.NoError(t, err)
+	assert.Equal(t, 25, len(entities))
+
+	_, err = store.Insert(jstore.NewID(project, "event", "0"), `{"count":0}`)
+	require.NoError(t, err)
+	require.NoError(t, store.Flush())
+	require.Len(t, failures, 1)
+	assert.Equal(t, jstore.AlreadyExists, <-failures)
+
+	require.NoError(t, store.Close())
+	_, err = store.Save(jstore.NewID(project, "event", "late"), `{}`)
+	assert.Equal(t, Closed, err)
+}
+
+// fakeBulkServer answers bulk requests. Deletes of the id "missing" and
+// creates of the id "present" fail. Whole requests fail, if handle
+// returns false.
+func fakeBulkServer(t *testing.T, handle func() bool) (*httptest.Server, *int32) {
+	var items int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !handle() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		response := elastic.BulkResponse{}
+		decoder := json.NewDecoder(r.Body)
+		for decoder.More() {
+			action := map[string]map[string]interface{}{}
+			require.NoError(t, decoder.Decode(&action))
+			for operation, meta := range action {
+				item := &elastic.BulkResponseItem{Index: meta["_index"].(string), Id: meta["_id"].(string), Status: http.StatusOK}
+				switch {
+				case operation == "delete" && item.Id == "missing":
+					item.Status = http.StatusNotFound
+				case operation == "create" && item.Id == "present":
+					item.Status = http.StatusConflict
+					item.Error = &elastic.ErrorDetails{Type: "version_conflict_engine_exception"}
+				}
+				if operation != "delete" {
+					require.NoError(t, decoder.Decode(&map[string]interface{}{}))
+				}
+				response.Items = append(response.Items, map[string]*elastic.BulkResponseItem{operation: item})
+				atomic.AddInt32(&items, 1)
+			}
 		}
-		_, err := b.Marshal(p, jstore.NewID(project, "person", strconv.Itoa(i)))
-		assert.NoError(t, err)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	return server, &items
+}
+
+func Test_AsyncWrites_Failures(t *testing.T) {
+	server, items := fakeBulkServer(t, func() bool { return true })
+	defer server.Close()
+
+	failures := map[string]error{}
+	mutex := sync.Mutex{}
+	store, err := NewElasticStore(
+		server.URL,
+		elastic.SetSniff(false),
+		elastic.SetHealthcheck(false),
+		AsyncWrites(FlushInterval(0), OnBulkFailure(func(id jstore.EntityID, err error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			failures[id.ID] = err
+		})),
+	)
+	require.NoError(t, err)
+
+	_, err = store.Save(jstore.NewID("project", "event", "ford"), `{}`)
+	require.NoError(t, err)
+	_, err = store.Insert(jstore.NewID("project", "event", "present"), `{}`)
+	require.NoError(t, err)
+	require.NoError(t, store.Delete(jstore.NewID("project", "event", "missing")))
+	assert.Equal(t, int32(0), atomic.LoadInt32(items))
+
+	require.NoError(t, store.Close())
+	assert.Equal(t, int32(3), atomic.LoadInt32(items))
+	assert.Equal(t, map[string]error{"present": jstore.AlreadyExists, "missing": jstore.NotFound}, failures)
+}
+
+func Test_AsyncWrites_FailedCommit(t *testing.T) {
+	var requests int32
+	server, items := fakeBulkServer(t, func() bool { return atomic.AddInt32(&requests, 1) > 1 })
+	defer server.Close()
+
+	failures := map[string]error{}
+	mutex := sync.Mutex{}
+	store, err := NewElasticStore(
+		server.URL,
+		elastic.SetSniff(false),
+		elastic.SetHealthcheck(false),
+		AsyncWrites(FlushInterval(0), QueueSize(2), OnBulkFailure(func(id jstore.EntityID, err error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			failures[id.ID] = err
+		})),
+	)
+	require.NoError(t, err)
+
+	_, err = store.Save(jstore.NewID("project", "event", "a"), `{}`)
+	require.NoError(t, err)
+	assert.Error(t, store.Flush())
+	assert.Contains(t, failures, "a")
+
+	// the failed write is neither retried nor keeps its queue token
+	for _, id := range []string{"b", "c"} {
+		_, err = store.Save(jstore.NewID("project", "event", id), `{}`)
+		require.NoError(t, err)
+	}
+	done := make(chan error)
+	go func() { done <- store.Flush() }()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("flush after a failed commit hangs")
 	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(items))
+	require.NoError(t, store.Close())
+}
 
-	// ascending
-	docs, err := b.FindN(1000, jstore.SortBy("age", true))
-	assert.NoError(t, err)
-	assert.Equal(t, 50, len(docs))
-	age := -1
-	for _, d := range docs {
-		p := Person{}
-		err = json.Unmarshal([]byte(d.JSON), &p)
-		assert.NoError(t, err)
-		assert.Contains(t, p.Name, "person-")
-		assert.True(t, age < p.Age)
-		age = p.Age
-	}
-
-	// descending
-	docs, err = b.FindN(1000, jstore.SortBy("age", false))
-	assert.NoError(t, err)
-	assert.Equal(t, 50, len(docs))
-	age = 999999
-	for _, d := range docs {
-		p := Person{}
-		err = json.Unmarshal([]byte(d.JSON), &p)
-		assert.NoError(t, err)
-		assert.Contains(t, p.Name, "person-")
-		assert.True(t, age > p.Age)
-		age = p.Age
+func Test_AsyncWrites_SaveRaw(t *testing.T) {
+	var body []byte
+	mutex := sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		body, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"items": [{"index": {"status": 201}}]}`))
+	}))
+	defer server.Close()
+
+	store, err := NewElasticStore(
+		server.URL,
+		elastic.SetSniff(false),
+		elastic.SetHealthcheck(false),
+		AsyncWrites(FlushInterval(0)),
+	)
+	require.NoError(t, err)
+
+	raw := json.RawMessage(`{"name":"ford"}`)
+	_, err = store.SaveRaw(jstore.NewID("project", "event", "1"), raw)
+	require.NoError(t, err)
+	// the caller reuses its buffer
+	copy(raw, `{"name":"zaph"}`)
+	require.NoError(t, store.Close())
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Contains(t, string(body), `{"name":"ford"}`)
+}
+
+func Test_AsyncWrites_WithRefresh(t *testing.T) {
+	paths := []string{}
+	mutex := sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		paths = append(paths, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	store, err := NewElasticStore(
+		server.URL,
+		elastic.SetSniff(false),
+		elastic.SetHealthcheck(false),
+		Refresh(RefreshFalse),
+		AsyncWrites(FlushInterval(0)),
+	)
+	require.NoError(t, err)
+
+	// the write is queued and refreshed by the flush of the copy
+	_, err = store.WithRefresh(RefreshWaitFor).Save(jstore.NewID("project", "event", "1"), `{}`)
+	require.NoError(t, err)
+	mutex.Lock()
+	assert.Empty(t, paths)
+	mutex.Unlock()
+
+	require.NoError(t, store.WithRefresh(RefreshTrue).Flush())
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []string{"/_bulk", "/project-event/_refresh"}, paths)
+}
+
+func Test_AsyncWrites_Timestamps(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	store, err := NewElasticStore(
+		server.URL,
+		elastic.SetSniff(false),
+		elastic.SetHealthcheck(false),
+		AsyncWrites(FlushInterval(0)),
+	)
+	require.NoError(t, err)
+	defer store.Close()
+
+	_, err = jstore.WrapStore(store, jstore.Timestamps()).Save(jstore.NewID("project", "event", "1"), `{}`)
+	assert.Error(t, err)
+	assert.Zero(t, requests)
+}
+
+func Test_AsyncWrites_SameWorker(t *testing.T) {
+	bulk := &bulkWriter{queue: make(chan struct{}, 100), indices: map[string]bool{}}
+	for i := 0; i < 4; i++ {
+		bulk.requests = append(bulk.requests, make(chan bulkRequest, 100))
+	}
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, bulk.add("project-event", jstore.NewID("project", "event", "ford"), "index", nil))
+		require.NoError(t, bulk.add("project-event", jstore.NewID("project", "event", strconv.Itoa(i)), "index", nil))
+	}
+
+	// the writes of ford are taken by a single worker in order
+	workers := 0
+	for _, requests := range bulk.requests {
+		fords := 0
+		for len(requests) > 0 {
+			if request := <-requests; request.id.ID == "ford" {
+				fords++
+			}
+		}
+		if fords > 0 {
+			workers++
+			assert.Equal(t, 20, fords)
+		}
 	}
+	assert.Equal(t, 1, workers)
 }
 
-func Test_Delete(t *testing.T) {
+func Test_AsyncWrites_Backpressure(t *testing.T) {
+	release := make(chan struct{})
+	server, items := fakeBulkServer(t, func() bool { <-release; return true })
+	defer server.Close()
+
+	store, err := NewElasticStore(
+		server.URL,
+		elastic.SetSniff(false),
+		elastic.SetHealthcheck(false),
+		AsyncWrites(FlushInterval(0), BulkActions(2), QueueSize(2)),
+	)
+	require.NoError(t, err)
+
+	saved := make(chan int, 10)
+	go func() {
+		for i := 0; i < 3; i++ {
+			store.Save(jstore.NewID("project", "event", strconv.Itoa(i)), `{}`)
+			saved <- i
+		}
+	}()
+
+	assert.Equal(t, 0, <-saved)
+	assert.Equal(t, 1, <-saved)
+	select {
+	case <-saved:
+		t.Fatal("the third write should wait for the queue")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	assert.Equal(t, 2, <-saved)
+	require.NoError(t, store.Close())
+	assert.Equal(t, int32(3), atomic.LoadInt32(items))
+}
+
+func Test_Timestamps(t *testing.T) {
 	project := randStringBytes(10)
-	b, err := jstore.NewBucket(
+	now := time.Date(2042, 1, 1, 12, 0, 0, 0, time.UTC)
+	store, err := jstore.NewStore(
 		"elastic",
 		esTestURL(),
-		project,
-		"person",
-		SyncUpdates(),
 		elastic.SetSniff(false),
+		SyncUpdates(),
+		jstore.Timestamps(),
+		jstore.TimeSource(func() time.Time { return now }),
 	)
-	assert.NoError(t, err)
+	require.NoError(t, err)
 
-	_, err = b.Marshal(ford, jstore.NewID(project, "person", "ford"))
-	assert.NoError(t, err)
-	_, err = b.Marshal(zaphod, jstore.NewID(project, "person", "zaphod"))
-	assert.NoError(t, err)
+	_, err = store.Marshal(ford, jstore.NewID(project, "person", "ford"))
+	require.NoError(t, err)
+	created := now
+	now = now.Add(time.Hour)
+	_, err = store.Marshal(ford, jstore.NewID(project, "person", "ford"))
+	require.NoError(t, err)
+	_, err = store.Marshal(marvin, jstore.NewID(project, "person", "marvin"))
+	require.NoError(t, err)
 
-	// ford is there
-	var result Person
-	assert.NoError(t, b.Unmarshal(&result, jstore.Id("ford")))
+	entity, err := store.Get(jstore.NewID(project, "person", "ford"))
+	require.NoError(t, err)
+	assert.Contains(t, entity.JSON, `"createdAt":"2042-01-01T12:00:00.000Z"`)
+	assert.Contains(t, entity.JSON, `"updatedAt":"2042-01-01T13:00:00.000Z"`)
 
-	// delete ford
-	assert.NoError(t, b.Delete(jstore.NewID(project, "person", "ford")))
+	entities, err := store.FindN(project, "person", 10, jstore.Gt("createdAt", created))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(entities))
+	assert.Equal(t, "marvin", entities[0].ID)
+}
 
-	// fort is away
-	err = b.Unmarshal(&result, jstore.Id("ford"))
-	assert.Equal(t, jstore.NotFound, err)
+func Benchmark_Save(b *testing.B) {
+	project := randStringBytes(10)
+	store, err := NewElasticStore(esTestURL(), elastic.SetSniff(false))
+	require.NoError(b, err)
+	document := benchmarkDocument()
+	b.SetBytes(int64(len(document)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := store.Save(jstore.NewID(project, "person", strconv.Itoa(i%100)), string(document))
+		require.NoError(b, err)
+	}
+}
+
+func Benchmark_SaveRaw(b *testing.B) {
+	project := randStringBytes(10)
+	store, err := NewElasticStore(esTestURL(), elastic.SetSniff(false))
+	require.NoError(b, err)
+	document := benchmarkDocument()
+	b.SetBytes(int64(len(document)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := store.SaveRaw(jstore.NewID(project, "person", strconv.Itoa(i%100)), document)
+		require.NoError(b, err)
+	}
+}
+
+func Benchmark_Get(b *testing.B) {
+	project := randStringBytes(10)
+	store, err := NewElasticStore(esTestURL(), elastic.SetSniff(false))
+	require.NoError(b, err)
+	_, err = store.SaveRaw(jstore.NewID(project, "person", "ford"), benchmarkDocument())
+	require.NoError(b, err)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := store.Get(jstore.NewID(project, "person", "ford"))
+		require.NoError(b, err)
+	}
+}
 
-	// but zaphod is still there
-	assert.NoError(t, b.Unmarshal(&result, jstore.Id("zaphod")))
+// benchmarkDocument returns a document of about 100kb.
+func benchmarkDocument() json.RawMessage {
+	items := make([]Person, 1000)
+	for i := range items {
+		items[i] = ford
+	}
+	document, _ := json.Marshal(map[string]interface{}{"items": items})
+	return document
 }
 
 func Test_SearchIn(t *testing.T) {
@@ -570,6 +1027,332 @@ func Test_IndexTemplate(t *testing.T) {
 	assert.True(t, exists)
 }
 
+func Test_Rollover(t *testing.T) {
+	project := randStringBytes(10)
+	store, err := NewElasticStore(
+		esTestURL(),
+		SyncUpdates(),
+		elastic.SetSniff(false),
+		RolloverIndices(""),
+	)
+	require.NoError(t, err)
+
+	first, err := store.Save(jstore.NewID(project, "event", "1"), `{"name": "first"}`)
+	require.NoError(t, err)
+
+	rolledOver, err := store.Rollover(project, "event", RolloverConditions{MaxDocs: 100})
+	require.NoError(t, err)
+	assert.False(t, rolledOver)
+	rolledOver, err = store.Rollover(project, "event", RolloverConditions{MaxDocs: 1})
+	require.NoError(t, err)
+	assert.True(t, rolledOver)
+
+	_, err = store.Save(jstore.NewID(project, "event", "2"), `{"name": "second"}`)
+	require.NoError(t, err)
+
+	entity, err := store.Get(first)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name": "first"}`, entity.JSON)
+
+	entities, err := store.FindN(project, "event", 10)
+	require.NoError(t, err)
+	assert.Len(t, entities, 2)
+
+	documentTypes, err := store.ListDocumentTypes(project)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"event"}, documentTypes)
+}
+
+func Test_RolloverTemplate(t *testing.T) {
+	bootstrap := &rolloverBootstrap{policy: "events"}
+	template := bootstrap.template("p-event", "p-event-write")
+	assert.Equal(t, rolloverTemplateOrder, template["order"])
+	patterns := template["index_patterns"].([]string)
+	require.Len(t, patterns, 1)
+
+	matches := func(index string) bool {
+		matches, err := path.Match(patterns[0], index)
+		require.NoError(t, err)
+		return matches
+	}
+	assert.True(t, matches("p-event-000001"))
+	assert.True(t, matches("p-event-000042"))
+	// indices of project p-event and of project p with other types
+	assert.False(t, matches("p-event-x-000001"))
+	assert.False(t, matches("p-eventlog-000001"))
+
+	for _, documentType := range []string{"event-log", "0event"} {
+		assert.Error(t, bootstrap.prepare(nil, "p", documentType), documentType)
+	}
+}
+
+func Test_LifecyclePolicy(t *testing.T) {
+	lifecycle := Lifecycle{
+		Rollover:    RolloverConditions{MaxAge: 24 * time.Hour, MaxSize: "50gb"},
+		DeleteAfter: 30 * 24 * time.Hour,
+	}
+	body, err := json.Marshal(lifecycle.body())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"policy": {"phases": {
+		"hot": {"actions": {"rollover": {"max_age": "1d", "max_size": "50gb"}}},
+		"delete": {"min_age": "30d", "actions": {"delete": {}}}
+	}}}`, string(body))
+
+	store, err := NewElasticStore(esTestURL(), elastic.SetSniff(false))
+	require.NoError(t, err)
+	assert.Error(t, store.PutLifecyclePolicy("empty", Lifecycle{}))
+
+	name := "jstore-" + randStringBytes(10)
+	_, err = NewElasticStore(esTestURL(), elastic.SetSniff(false), LifecyclePolicy(name, lifecycle))
+	require.NoError(t, err)
+	policies, err := store.client.XPackIlmGetLifecycle().Policy(name).Do(context.Background())
+	require.NoError(t, err)
+	assert.Contains(t, policies, name)
+}
+
+func Test_FormatDuration(t *testing.T) {
+	for duration, expected := range map[time.Duration]string{
+		48 * time.Hour:          "2d",
+		36 * time.Hour:          "36h",
+		90 * time.Minute:        "90m",
+		time.Second:             "1s",
+		1500 * time.Millisecond: "1500ms",
+	} {
+		assert.Equal(t, expected, formatDuration(duration))
+	}
+}
+
+func Test_Mapping_Reindex(t *testing.T) {
+	project := randStringBytes(10)
+	mapping := func(ageType string) ElasticStoreOption {
+		return DocumentTypeMapping("person", map[string]interface{}{
+			"properties": map[string]interface{}{
+				"name": map[string]string{"type": "keyword"},
+				"age":  map[string]string{"type": ageType},
+			},
+		})
+	}
+
+	// a document type without mapping is left to dynamic mapping
+	store, err := NewElasticStore(esTestURL(), SyncUpdates(), elastic.SetSniff(false))
+	require.NoError(t, err)
+	id, err := store.Save(jstore.NewID(project, "person", "1"), `{"name": "Bob", "age": 42}`)
+	require.NoError(t, err)
+	_, err = store.Save(jstore.NewID(project, "person", "2"), `{"name": "Alice", "age": 23}`)
+	require.NoError(t, err)
+
+	store, err = NewElasticStore(esTestURL(), SyncUpdates(), elastic.SetSniff(false), mapping("integer"))
+	require.NoError(t, err)
+	drifts, err := store.CheckMapping(project, "person")
+	require.NoError(t, err)
+	assert.Equal(t, []MappingDrift{
+		{Field: "age", Expected: "integer", Actual: "long"},
+		{Field: "name", Expected: "keyword", Actual: "text"},
+	}, drifts)
+
+	reindexing, err := store.Reindex(project, "person")
+	require.NoError(t, err)
+	assert.Equal(t, project+"-person_v1", reindexing.Destination)
+	require.Eventually(t, func() bool {
+		progress, err := store.ReindexProgress(reindexing)
+		require.NoError(t, err)
+		return progress.Completed
+	}, 10*time.Second, 100*time.Millisecond)
+	progress, err := store.ReindexProgress(reindexing)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), progress.Created)
+	assert.Empty(t, progress.Failures)
+
+	// written after the documents were copied
+	_, err = store.Save(id, `{"name": "Bob", "age": 43}`)
+	require.NoError(t, err)
+	require.NoError(t, store.Delete(jstore.NewID(project, "person", "2")))
+	require.NoError(t, store.CompleteReindex(reindexing))
+	_, err = store.Get(jstore.NewID(project, "person", "2"))
+	assert.Equal(t, jstore.NotFound, err)
+
+	drifts, err = store.CheckMapping(project, "person")
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+	entity, err := store.Get(id)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name": "Bob", "age": 43}`, entity.JSON)
+
+	// the mapping changes again
+	store, err = NewElasticStore(esTestURL(), SyncUpdates(), elastic.SetSniff(false), mapping("long"))
+	require.NoError(t, err)
+	reindexing, err = store.Reindex(project, "person")
+	require.NoError(t, err)
+	assert.Equal(t, project+"-person_v2", reindexing.Destination)
+	require.Eventually(t, func() bool {
+		return store.CompleteReindex(reindexing) == nil
+	}, 10*time.Second, 100*time.Millisecond)
+
+	documentTypes, err := store.ListDocumentTypes(project)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"person"}, documentTypes)
+	entities, err := store.FindN(project, "person", 10)
+	require.NoError(t, err)
+	assert.Len(t, entities, 1)
+}
+
+func Test_CompleteReindex_Failures(t *testing.T) {
+	paths := []string{}
+	mutex := sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		paths = append(paths, r.URL.Path)
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"completed": true, "task": {"status": {"total": 2}},
+			"response": {"total": 2, "created": 1, "failures": [
+				{"index": "p-person_v2", "id": "1", "cause": {"type": "mapper_parsing_exception", "reason": "failed to parse field [age]"}}
+			]}}`))
+	}))
+	defer server.Close()
+
+	store, err := NewElasticStore(server.URL, elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	require.NoError(t, err)
+	reindexing := &Reindexing{TaskID: "node:1", Alias: "p-person", Source: "p-person_v1", Destination: "p-person_v2"}
+
+	progress, err := store.ReindexProgress(reindexing)
+	require.NoError(t, err)
+	assert.Equal(t, ReindexProgress{
+		Total:     2,
+		Created:   1,
+		Failures:  []string{"p-person_v2/1: mapper_parsing_exception: failed to parse field [age]"},
+		Completed: true,
+	}, progress)
+
+	err = store.CompleteReindex(reindexing)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "copied 1 of 2 documents")
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []string{"/_tasks/node:1", "/_tasks/node:1"}, paths)
+}
+
+func Test_CompleteReindex_DeletesRemoved(t *testing.T) {
+	requests := []string{}
+	bulkBody := ""
+	mutex := sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/_tasks/"):
+			w.Write([]byte(`{"completed": true, "response": {"total": 2, "created": 2}}`))
+		case r.URL.Path == "/_reindex":
+			w.Write([]byte(`{"total": 2, "version_conflicts": 2}`))
+		case r.URL.Path == "/p-person_v2/_search":
+			w.Write([]byte(`{"_scroll_id": "scroll", "hits": {"hits": [
+				{"_index": "p-person_v2", "_id": "1"}, {"_index": "p-person_v2", "_id": "2"}]}}`))
+		case r.URL.Path == "/_search/scroll" && r.Method == "DELETE":
+			w.Write([]byte(`{"succeeded": true}`))
+		case r.URL.Path == "/_search/scroll":
+			w.Write([]byte(`{"_scroll_id": "scroll", "hits": {"hits": []}}`))
+		case r.URL.Path == "/_mget":
+			w.Write([]byte(`{"docs": [
+				{"_index": "p-person_v1", "_id": "1", "found": true},
+				{"_index": "p-person_v1", "_id": "2", "found": false}]}`))
+		case r.URL.Path == "/_bulk":
+			bulkBody = string(body)
+			w.Write([]byte(`{"items": [{"delete": {"_index": "p-person_v2", "_id": "2", "status": 200}}]}`))
+		default:
+			w.Write([]byte(`{"acknowledged": true}`))
+		}
+	}))
+	defer server.Close()
+
+	store, err := NewElasticStore(server.URL, elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	require.NoError(t, err)
+	reindexing := &Reindexing{TaskID: "node:1", Alias: "p-person", Source: "p-person_v1", Destination: "p-person_v2"}
+
+	require.NoError(t, store.CompleteReindex(reindexing))
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, `{"delete":{"_index":"p-person_v2","_id":"2"}}`+"\n", bulkBody)
+	assert.Equal(t, "POST /_aliases", requests[len(requests)-1])
+}
+
+func Test_Mapping_FirstWrite(t *testing.T) {
+	project := randStringBytes(10)
+	store, err := NewElasticStore(
+		esTestURL(),
+		SyncUpdates(),
+		elastic.SetSniff(false),
+		DocumentTypeMapping("person", map[string]interface{}{
+			"properties": map[string]interface{}{
+				"name": map[string]interface{}{
+					"type":   "text",
+					"fields": map[string]interface{}{"raw": map[string]string{"type": "keyword"}},
+				},
+			},
+		}),
+	)
+	require.NoError(t, err)
+
+	_, err = store.CheckMapping(project, "person")
+	assert.Equal(t, jstore.NotFound, err)
+
+	_, err = store.Save(jstore.NewID(project, "person", "1"), `{"name": "Bob"}`)
+	require.NoError(t, err)
+	drifts, err := store.CheckMapping(project, "person")
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+
+	exists, err := store.client.IndexExists(project + "-person_v1").Do(context.Background())
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	_, err = store.CheckMapping(project, "address")
+	assert.Error(t, err)
+}
+
+func Test_CompareMappings(t *testing.T) {
+	expected := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{
+				"type":   "text",
+				"fields": map[string]interface{}{"raw": map[string]interface{}{"type": "keyword"}},
+			},
+			"address": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"city": map[string]interface{}{"type": "keyword"},
+				},
+			},
+		},
+	}
+	actual := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{"type": "text"},
+			"address": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"city": map[string]interface{}{"type": "text"},
+				},
+			},
+			"dynamic": map[string]interface{}{"type": "long"},
+		},
+	}
+	assert.Equal(t, []MappingDrift{
+		{Field: "address.city", Expected: "keyword", Actual: "text"},
+		{Field: "name.raw", Expected: "keyword", Actual: ""},
+	}, compareMappings(expected, actual))
+	assert.Empty(t, compareMappings(expected, expected))
+}
+
+func Test_Mapping_Options(t *testing.T) {
+	_, err := NewElasticStore(esTestURL(), elastic.SetSniff(false), RolloverIndices(""), DocumentTypeMapping("person", map[string]interface{}{}))
+	assert.Error(t, err)
+	_, err = NewElasticStore(esTestURL(), elastic.SetSniff(false), DocumentTypeMapping("person", map[string]interface{}{}), RolloverIndices(""))
+	assert.Error(t, err)
+	_, err = NewElasticStore(esTestURL(), elastic.SetSniff(false), DocumentTypeMapping("person", []string{}))
+	assert.Error(t, err)
+}
+
 const letterBytes = "abcdefghijklmnopqrstuvwxyz"
 
 func init() {
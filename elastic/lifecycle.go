@@ -0,0 +1,247 @@
+package elastic
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RolloverIndexNamer names aliases instead of indices. Writes go to the
+// write alias <project>-<documentType>-write, which points to the
+// current index. Reads use the read alias <project>-<documentType>,
+// which points to all indices. The indices are named
+// <project>-<documentType>-000001 and so on.
+//
+// The aliases and the indices behind them are created by the
+// RolloverIndices option. Updates and deletes only reach documents in
+// the current index, so rollover suits append only documents like
+// events. Document types must not contain dashes or start with 0, so
+// the indices of one document type can not be taken for another's.
+func RolloverIndexNamer(project, documentType string, matchAll bool) string {
+	alias := defaultIndexName(project, documentType, true)
+	if !matchAll {
+		return alias + writeAliasSuffix
+	}
+	return alias
+}
+
+const (
+	writeAliasSuffix = "-write"
+
+	// rolloverTemplateOrder lets the templates of the rollover indices
+	// override the settings of general templates with the default
+	// order 0.
+	rolloverTemplateOrder = 10
+)
+
+// RolloverIndices uses the RolloverIndexNamer and creates the aliases
+// and the first index of a document type on the first write. With a
+// policy, the indices are managed by the ILM policy of that name, e.g.
+// one installed by LifecyclePolicy. Without, they are rolled over by
+// calling Rollover.
+func RolloverIndices(policy string) ElasticStoreOption {
+	return func(store *ElasticStore) error {
+		if store.mappings != nil || store.timestamps != nil {
+			return errors.New("rollover indices can not be combined with mappings or timestamp indices")
+		}
+		store.indexName = RolloverIndexNamer
+		store.prepareIndex = (&rolloverBootstrap{policy: policy}).prepare
+		return nil
+	}
+}
+
+// RolloverConditions trigger a rollover, when any of them is met. Zero
+// values are ignored.
+type RolloverConditions struct {
+	MaxAge time.Duration
+	// MaxSize is the size of the primary shards, e.g. "50gb".
+	MaxSize string
+	MaxDocs int64
+}
+
+func (conditions RolloverConditions) toMap() map[string]interface{} {
+	result := map[string]interface{}{}
+	if conditions.MaxAge > 0 {
+		result["max_age"] = formatDuration(conditions.MaxAge)
+	}
+	if conditions.MaxSize != "" {
+		result["max_size"] = conditions.MaxSize
+	}
+	if conditions.MaxDocs > 0 {
+		result["max_docs"] = conditions.MaxDocs
+	}
+	return result
+}
+
+// Lifecycle describes an ILM policy, which rolls the indices over and
+// deletes them after the retention period. For details see:
+//
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/index-lifecycle-management.html
+type Lifecycle struct {
+	Rollover RolloverConditions
+	// DeleteAfter is the retention period after the rollover. Zero
+	// keeps the indices forever.
+	DeleteAfter time.Duration
+}
+
+func (lifecycle Lifecycle) body() map[string]interface{} {
+	phases := map[string]interface{}{
+		"hot": map[string]interface{}{
+			"actions": map[string]interface{}{
+				"rollover": lifecycle.Rollover.toMap(),
+			},
+		},
+	}
+	if lifecycle.DeleteAfter > 0 {
+		phases["delete"] = map[string]interface{}{
+			"min_age": formatDuration(lifecycle.DeleteAfter),
+			"actions": map[string]interface{}{
+				"delete": map[string]interface{}{},
+			},
+		}
+	}
+	return map[string]interface{}{
+		"policy": map[string]interface{}{
+			"phases": phases,
+		},
+	}
+}
+
+// LifecyclePolicy installs or updates the ILM policy on creation of
+// the store.
+func LifecyclePolicy(name string, lifecycle Lifecycle) ElasticStoreOption {
+	return func(store *ElasticStore) error {
+		return store.PutLifecyclePolicy(name, lifecycle)
+	}
+}
+
+// PutLifecyclePolicy installs or updates the ILM policy.
+func (store *ElasticStore) PutLifecyclePolicy(name string, lifecycle Lifecycle) error {
+	if len(lifecycle.Rollover.toMap()) == 0 {
+		return fmt.Errorf("lifecycle policy %s has no rollover conditions", name)
+	}
+	response, err := store.client.XPackIlmPutLifecycle().
+		Policy(name).
+		BodyJson(lifecycle.body()).
+		Do(store.cntx())
+	if err != nil {
+		return fmt.Errorf("putting lifecycle policy %s: %w", name, err)
+	}
+	if !response.Acknowledged {
+		return fmt.Errorf("lifecycle policy %s not acknowledged", name)
+	}
+	return nil
+}
+
+// Rollover rolls the write alias of the document type over to a new
+// index, if any of the conditions is met. Without conditions, it rolls
+// over unconditionally. It returns, if the alias was rolled over.
+func (store *ElasticStore) Rollover(project, documentType string, conditions RolloverConditions) (bool, error) {
+	alias := RolloverIndexNamer(project, documentType, false)
+	response, err := store.client.RolloverIndex(alias).
+		Conditions(conditions.toMap()).
+		Do(store.cntx())
+	if err != nil {
+		return false, fmt.Errorf("rolling over %s: %w", alias, err)
+	}
+	return response.RolledOver, nil
+}
+
+// rolloverBootstrap creates the template, the aliases and the first
+// index of a document type once.
+type rolloverBootstrap struct {
+	policy string
+	done   sync.Map
+}
+
+func (bootstrap *rolloverBootstrap) prepare(store *ElasticStore, project, documentType string) error {
+	if strings.Contains(documentType, "-") || strings.HasPrefix(documentType, "0") {
+		return fmt.Errorf("invalid document type %q for rollover indices", documentType)
+	}
+	readAlias := RolloverIndexNamer(project, documentType, true)
+	if _, ok := bootstrap.done.Load(readAlias); ok {
+		return nil
+	}
+	writeAlias := readAlias + writeAliasSuffix
+
+	exists, err := store.client.IndexExists(writeAlias).Do(store.cntx())
+	if err != nil {
+		return fmt.Errorf("checking alias %s: %w", writeAlias, err)
+	}
+	if !exists {
+		if err := bootstrap.create(store, readAlias, writeAlias); err != nil {
+			return err
+		}
+	}
+	bootstrap.done.Store(readAlias, true)
+	return nil
+}
+
+func (bootstrap *rolloverBootstrap) create(store *ElasticStore, readAlias, writeAlias string) error {
+	templateResponse, err := store.client.IndexPutTemplate("jstore-" + readAlias).
+		BodyJson(bootstrap.template(readAlias, writeAlias)).
+		Do(store.cntx())
+	if err != nil {
+		return fmt.Errorf("creating template for %s: %w", readAlias, err)
+	}
+	if !templateResponse.Acknowledged {
+		return fmt.Errorf("creation of template for %s not acknowledged", readAlias)
+	}
+
+	_, err = store.client.CreateIndex(readAlias + "-000001").
+		BodyJson(map[string]interface{}{
+			"aliases": map[string]interface{}{
+				writeAlias: map[string]interface{}{"is_write_index": true},
+			},
+		}).
+		Do(store.cntx())
+	if err != nil {
+		if isAlreadyExists(err) {
+			// created concurrently
+			return nil
+		}
+		return fmt.Errorf("creating first index of %s: %w", readAlias, err)
+	}
+	return nil
+}
+
+// template adds the read alias and the policy to the indices created
+// by rollovers. Their names start with 0, which the document types of
+// other indices matching the prefix can not.
+func (bootstrap *rolloverBootstrap) template(readAlias, writeAlias string) map[string]interface{} {
+	settings := map[string]interface{}{}
+	if bootstrap.policy != "" {
+		settings["index.lifecycle.name"] = bootstrap.policy
+		settings["index.lifecycle.rollover_alias"] = writeAlias
+	}
+	return map[string]interface{}{
+		"index_patterns": []string{readAlias + "-0*"},
+		"order":          rolloverTemplateOrder,
+		"settings":       settings,
+		"aliases": map[string]interface{}{
+			readAlias: map[string]interface{}{},
+		},
+	}
+}
+
+// formatDuration formats the duration in the largest time unit of
+// elasticsearch, which represents it exactly.
+func formatDuration(d time.Duration) string {
+	for _, unit := range []struct {
+		duration time.Duration
+		suffix   string
+	}{
+		{24 * time.Hour, "d"},
+		{time.Hour, "h"},
+		{time.Minute, "m"},
+		{time.Second, "s"},
+	} {
+		if d%unit.duration == 0 {
+			return strconv.FormatInt(int64(d/unit.duration), 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(d.Milliseconds(), 10) + "ms"
+}
@@ -0,0 +1,504 @@
+package elastic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/snabble/go-jstore/v2"
+)
+
+// DocumentTypeMapping registers the mapping of a document type in all
+// projects, e.g.
+//
+//	DocumentTypeMapping("person", map[string]interface{}{
+//		"properties": map[string]interface{}{
+//			"name": map[string]string{"type": "keyword"},
+//		},
+//	})
+//
+// On the first write, the index of the document type is created as
+// <index>_v1 with the mapping and the alias <index>, which is used for
+// reads and writes. CheckMapping compares the mapping with the live
+// index and Reindex migrates the documents to an index with the
+// current mapping.
+//
+// The IndexNamer must use a single index per document type.
+func DocumentTypeMapping(documentType string, mapping interface{}) ElasticStoreOption {
+	return func(store *ElasticStore) error {
+		normalized, err := normalizeMapping(mapping)
+		if err != nil {
+			return fmt.Errorf("mapping of %s: %w", documentType, err)
+		}
+		if store.mappings == nil {
+			if store.prepareIndex != nil || store.timestamps != nil {
+				return errors.New("mappings can not be combined with rollover or timestamp indices")
+			}
+			store.mappings = map[string]map[string]interface{}{}
+			store.prepareIndex = (&mappingBootstrap{}).prepare
+		}
+		store.mappings[strings.ToLower(documentType)] = normalized
+		return nil
+	}
+}
+
+func normalizeMapping(mapping interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(mapping)
+	if err != nil {
+		return nil, err
+	}
+	normalized := map[string]interface{}{}
+	if err := json.Unmarshal(b, &normalized); err != nil {
+		return nil, fmt.Errorf("mapping is no JSON object: %w", err)
+	}
+	return normalized, nil
+}
+
+// mappedIndex returns the alias and the registered mapping of the
+// document type.
+func (store *ElasticStore) mappedIndex(project, documentType string) (string, map[string]interface{}, error) {
+	mapping, ok := store.mappings[strings.ToLower(documentType)]
+	if !ok {
+		return "", nil, fmt.Errorf("no mapping registered for %s", documentType)
+	}
+	alias := store.indexName(project, documentType, false)
+	if alias != store.indexName(project, documentType, true) {
+		return "", nil, fmt.Errorf("mapped document type %s needs a single index", documentType)
+	}
+	return alias, mapping, nil
+}
+
+// mappingBootstrap creates the first index of the mapped document
+// types once.
+type mappingBootstrap struct {
+	done sync.Map
+}
+
+func (bootstrap *mappingBootstrap) prepare(store *ElasticStore, project, documentType string) error {
+	if _, ok := store.mappings[strings.ToLower(documentType)]; !ok {
+		return nil
+	}
+	alias, mapping, err := store.mappedIndex(project, documentType)
+	if err != nil {
+		return err
+	}
+	if _, ok := bootstrap.done.Load(alias); ok {
+		return nil
+	}
+
+	exists, err := store.client.IndexExists(alias).Do(store.cntx())
+	if err != nil {
+		return fmt.Errorf("checking index %s: %w", alias, err)
+	}
+	if !exists {
+		err := store.createMappedIndex(versionedIndexName(alias, 1), mapping, alias)
+		if err != nil && !isAlreadyExists(err) {
+			return err
+		}
+	}
+	bootstrap.done.Store(alias, true)
+	return nil
+}
+
+// createMappedIndex creates the index with the mapping and, if given,
+// the alias.
+func (store *ElasticStore) createMappedIndex(index string, mapping map[string]interface{}, alias string) error {
+	body := map[string]interface{}{"mappings": mapping}
+	if alias != "" {
+		body["aliases"] = map[string]interface{}{alias: map[string]interface{}{}}
+	}
+	response, err := store.client.CreateIndex(index).BodyJson(body).Do(store.cntx())
+	if err != nil {
+		return fmt.Errorf("creating index %s: %w", index, err)
+	}
+	if !response.Acknowledged {
+		return fmt.Errorf("creation of index %s not acknowledged", index)
+	}
+	return nil
+}
+
+func isAlreadyExists(err error) bool {
+	var e *elastic.Error
+	return errors.As(err, &e) && e.Details != nil && e.Details.Type == "resource_already_exists_exception"
+}
+
+var versionSuffix = regexp.MustCompile(`_v(\d+)$`)
+
+func versionedIndexName(alias string, version int) string {
+	return alias + "_v" + strconv.Itoa(version)
+}
+
+// indexVersion returns the version of a versioned index and 0 for an
+// index created before its mapping was registered.
+func indexVersion(index string) int {
+	match := versionSuffix.FindStringSubmatch(index)
+	if match == nil {
+		return 0
+	}
+	version, _ := strconv.Atoi(match[1])
+	return version
+}
+
+// MappingDrift is a field, whose type in the live index differs from
+// the registered mapping. Actual is empty, if the field is not mapped.
+type MappingDrift struct {
+	Field    string
+	Expected string
+	Actual   string
+}
+
+func (drift MappingDrift) String() string {
+	if drift.Actual == "" {
+		return fmt.Sprintf("%s: expected %s, but not mapped", drift.Field, drift.Expected)
+	}
+	return fmt.Sprintf("%s: expected %s, but mapped as %s", drift.Field, drift.Expected, drift.Actual)
+}
+
+// CheckMapping compares the registered mapping of the document type
+// with the mapping of the live index. Fields mapped dynamically in
+// addition to the registered ones are no drift. It returns
+// jstore.NotFound, if the index does not exist yet.
+func (store *ElasticStore) CheckMapping(project, documentType string) ([]MappingDrift, error) {
+	alias, mapping, err := store.mappedIndex(project, documentType)
+	if err != nil {
+		return nil, err
+	}
+	_, live, err := store.liveMapping(alias)
+	if err != nil {
+		return nil, err
+	}
+	return compareMappings(mapping, live), nil
+}
+
+// liveMapping returns the index behind the alias and its mapping.
+func (store *ElasticStore) liveMapping(alias string) (string, map[string]interface{}, error) {
+	response, err := store.client.GetMapping().Index(alias).Do(store.cntx())
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return "", nil, jstore.NotFound
+		}
+		return "", nil, fmt.Errorf("getting mapping of %s: %w", alias, err)
+	}
+	if len(response) != 1 {
+		return "", nil, fmt.Errorf("%s resolves to %d indices", alias, len(response))
+	}
+	for index, value := range response {
+		indexMapping, _ := value.(map[string]interface{})
+		mapping, _ := indexMapping["mappings"].(map[string]interface{})
+		return index, mapping, nil
+	}
+	return "", nil, nil
+}
+
+func compareMappings(expected, actual map[string]interface{}) []MappingDrift {
+	expectedFields := map[string]string{}
+	flattenMapping("", expected, expectedFields)
+	actualFields := map[string]string{}
+	flattenMapping("", actual, actualFields)
+
+	drifts := []MappingDrift{}
+	for field, fieldType := range expectedFields {
+		if actualFields[field] != fieldType {
+			drifts = append(drifts, MappingDrift{Field: field, Expected: fieldType, Actual: actualFields[field]})
+		}
+	}
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].Field < drifts[j].Field })
+	return drifts
+}
+
+// flattenMapping collects the types of the properties and multi-fields
+// by their dotted path.
+func flattenMapping(prefix string, mapping map[string]interface{}, fields map[string]string) {
+	for _, key := range []string{"properties", "fields"} {
+		children, _ := mapping[key].(map[string]interface{})
+		for name, child := range children {
+			field, _ := child.(map[string]interface{})
+			fieldType, _ := field["type"].(string)
+			if fieldType == "" {
+				fieldType = "object"
+			}
+			fields[prefix+name] = fieldType
+			flattenMapping(prefix+name+".", field, fields)
+		}
+	}
+}
+
+// Reindexing is a running reindex of a document type into a new index.
+type Reindexing struct {
+	TaskID      string
+	Alias       string
+	Source      string
+	Destination string
+}
+
+// ReindexProgress is the status of a Reindexing reported by the tasks
+// api. Failures describe the documents, which could not be copied,
+// e.g. because of mapping conflicts.
+type ReindexProgress struct {
+	Total     int64
+	Created   int64
+	Updated   int64
+	Deleted   int64
+	Failures  []string
+	Completed bool
+}
+
+// reindexStatus is the status or the response of a reindex.
+type reindexStatus struct {
+	Total            int64 `json:"total"`
+	Created          int64 `json:"created"`
+	Updated          int64 `json:"updated"`
+	Deleted          int64 `json:"deleted"`
+	VersionConflicts int64 `json:"version_conflicts"`
+	Failures         []struct {
+		Index string `json:"index"`
+		ID    string `json:"id"`
+		Cause struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"cause"`
+	} `json:"failures"`
+}
+
+func (status reindexStatus) failures() []string {
+	failures := []string{}
+	for _, failure := range status.Failures {
+		failures = append(failures, fmt.Sprintf("%s/%s: %s: %s", failure.Index, failure.ID, failure.Cause.Type, failure.Cause.Reason))
+	}
+	return failures
+}
+
+// Reindex creates the next version of the index of the document type
+// with the registered mapping, e.g. person_v2 after person_v1, and
+// starts copying the documents into it in the background. The alias
+// keeps pointing to the old index, until CompleteReindex swaps it, so
+// reads and writes continue during the reindex.
+//
+// The documents keep their versions in the new index, so
+// CompleteReindex copies the documents written during the reindex
+// again and deletes the documents deleted during the reindex.
+func (store *ElasticStore) Reindex(project, documentType string) (*Reindexing, error) {
+	alias, mapping, err := store.mappedIndex(project, documentType)
+	if err != nil {
+		return nil, err
+	}
+	source, _, err := store.liveMapping(alias)
+	if err != nil {
+		return nil, err
+	}
+	destination := versionedIndexName(alias, indexVersion(source)+1)
+	if err := store.createMappedIndex(destination, mapping, ""); err != nil {
+		return nil, err
+	}
+
+	task, err := store.client.Reindex().
+		SourceIndex(source).
+		Destination(elastic.NewReindexDestination().Index(destination).VersionType("external")).
+		WaitForCompletion(false).
+		DoAsync(store.cntx())
+	if err != nil {
+		return nil, fmt.Errorf("reindexing %s into %s: %w", source, destination, err)
+	}
+	return &Reindexing{
+		TaskID:      task.TaskId,
+		Alias:       alias,
+		Source:      source,
+		Destination: destination,
+	}, nil
+}
+
+// ReindexProgress returns the progress of the reindex.
+func (store *ElasticStore) ReindexProgress(reindexing *Reindexing) (ReindexProgress, error) {
+	// the task response of the client lacks the response of the
+	// reindex with its failures
+	response, err := store.client.PerformRequest(store.cntx(), elastic.PerformRequestOptions{
+		Method: "GET",
+		Path:   "/_tasks/" + url.PathEscape(reindexing.TaskID),
+	})
+	if err != nil {
+		return ReindexProgress{}, fmt.Errorf("getting task %s: %w", reindexing.TaskID, err)
+	}
+	task := struct {
+		Completed bool                           `json:"completed"`
+		Task      struct{ Status reindexStatus } `json:"task"`
+		Response  *reindexStatus                 `json:"response"`
+		Error     *elastic.ErrorDetails          `json:"error"`
+	}{}
+	if err := json.Unmarshal(response.Body, &task); err != nil {
+		return ReindexProgress{}, fmt.Errorf("parsing task %s: %w", reindexing.TaskID, err)
+	}
+	if task.Error != nil {
+		return ReindexProgress{}, fmt.Errorf("reindexing %s into %s: %s: %s", reindexing.Source, reindexing.Destination, task.Error.Type, task.Error.Reason)
+	}
+
+	status := task.Task.Status
+	if task.Response != nil {
+		status = *task.Response
+	}
+	return ReindexProgress{
+		Total:     status.Total,
+		Created:   status.Created,
+		Updated:   status.Updated,
+		Deleted:   status.Deleted,
+		Failures:  status.failures(),
+		Completed: task.Completed,
+	}, nil
+}
+
+// CompleteReindex swaps the alias from the old to the new index, after
+// the reindex completed without failures. It blocks writes to the old
+// index, copies the documents written during the reindex again,
+// deletes the documents deleted during the reindex from the new index
+// and swaps the alias in one atomic request. Writes during these steps fail
+// instead of getting lost.
+//
+// The old index is kept with its write block, unless it was created
+// before the mapping was registered and has the name of the alias. Then
+// it is deleted by the request swapping the alias.
+func (store *ElasticStore) CompleteReindex(reindexing *Reindexing) error {
+	progress, err := store.ReindexProgress(reindexing)
+	if err != nil {
+		return err
+	}
+	if !progress.Completed {
+		return fmt.Errorf("reindexing %s into %s not completed", reindexing.Source, reindexing.Destination)
+	}
+	if len(progress.Failures) > 0 || progress.Created+progress.Updated != progress.Total {
+		return fmt.Errorf("reindexing %s into %s copied %d of %d documents: %s", reindexing.Source, reindexing.Destination,
+			progress.Created+progress.Updated, progress.Total, strings.Join(progress.Failures, ", "))
+	}
+
+	if err := store.blockWrites(reindexing.Source, true); err != nil {
+		return err
+	}
+	if err := store.swapAlias(reindexing); err != nil {
+		if unblockErr := store.blockWrites(reindexing.Source, false); unblockErr != nil {
+			return fmt.Errorf("%v, unblocking writes: %w", err, unblockErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (store *ElasticStore) blockWrites(index string, block bool) error {
+	_, err := store.client.IndexPutSettings(index).
+		BodyJson(map[string]interface{}{"index.blocks.write": block}).
+		Do(store.cntx())
+	if err != nil {
+		return fmt.Errorf("setting write block of %s to %v: %w", index, block, err)
+	}
+	return nil
+}
+
+// swapAlias copies the documents changed during the reindex, deletes
+// the removed ones and swaps the alias, while writes to the old index
+// are blocked.
+func (store *ElasticStore) swapAlias(reindexing *Reindexing) error {
+	// documents, which are already up to date, conflict with their
+	// own version
+	response, err := store.client.PerformRequest(store.cntx(), elastic.PerformRequestOptions{
+		Method: "POST",
+		Path:   "/_reindex",
+		Params: url.Values{"refresh": []string{"true"}},
+		Body: map[string]interface{}{
+			"conflicts": "proceed",
+			"source":    map[string]interface{}{"index": reindexing.Source},
+			"dest":      map[string]interface{}{"index": reindexing.Destination, "version_type": "external"},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("copying changes of %s into %s: %w", reindexing.Source, reindexing.Destination, err)
+	}
+	status := reindexStatus{}
+	if err := json.Unmarshal(response.Body, &status); err != nil {
+		return fmt.Errorf("parsing reindex of %s: %w", reindexing.Source, err)
+	}
+	if failures := status.failures(); len(failures) > 0 {
+		return fmt.Errorf("copying changes of %s into %s: %s", reindexing.Source, reindexing.Destination, strings.Join(failures, ", "))
+	}
+	if err := store.deleteRemoved(reindexing); err != nil {
+		return err
+	}
+
+	var remove elastic.AliasAction = elastic.NewAliasRemoveAction(reindexing.Alias).Index(reindexing.Source)
+	if reindexing.Source == reindexing.Alias {
+		remove = elastic.NewAliasRemoveIndexAction(reindexing.Source)
+	}
+	aliasResponse, err := store.client.Alias().
+		Action(elastic.NewAliasAddAction(reindexing.Alias).Index(reindexing.Destination), remove).
+		Do(store.cntx())
+	if err != nil {
+		return fmt.Errorf("swapping alias %s to %s: %w", reindexing.Alias, reindexing.Destination, err)
+	}
+	if !aliasResponse.Acknowledged {
+		return fmt.Errorf("swapping alias %s to %s not acknowledged", reindexing.Alias, reindexing.Destination)
+	}
+	return nil
+}
+
+// deleteRemoved deletes the documents from the new index, which are
+// missing in the old index, because they were deleted during the
+// reindex.
+func (store *ElasticStore) deleteRemoved(reindexing *Reindexing) error {
+	scroll := store.client.Scroll(reindexing.Destination).
+		SearchSource(elastic.NewSearchSource().FetchSource(false).SortBy(elastic.SortByDoc{})).
+		Size(store.scanBatchSize).
+		KeepAlive("1m")
+	defer scroll.Clear(store.cntx())
+
+	for {
+		resp, err := scroll.Do(store.cntx())
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("listing documents of %s: %w", reindexing.Destination, err)
+		}
+		if resp.Hits == nil || len(resp.Hits.Hits) == 0 {
+			return nil
+		}
+
+		mget := store.client.Mget().Realtime(true)
+		for _, hit := range resp.Hits.Hits {
+			mget.Add(elastic.NewMultiGetItem().
+				Index(reindexing.Source).
+				Id(hit.Id).
+				FetchSource(elastic.NewFetchSourceContext(false)))
+		}
+		found, err := mget.Do(store.cntx())
+		if err != nil {
+			return fmt.Errorf("looking up documents in %s: %w", reindexing.Source, err)
+		}
+
+		bulk := store.client.Bulk().Refresh("true")
+		for _, doc := range found.Docs {
+			if doc.Error != nil {
+				return fmt.Errorf("looking up %s in %s: %s: %s", doc.Id, reindexing.Source, doc.Error.Type, doc.Error.Reason)
+			}
+			if !doc.Found {
+				bulk.Add(elastic.NewBulkDeleteRequest().Index(reindexing.Destination).Id(doc.Id))
+			}
+		}
+		if bulk.NumberOfActions() == 0 {
+			continue
+		}
+		deleted, err := bulk.Do(store.cntx())
+		if err != nil {
+			return fmt.Errorf("deleting removed documents from %s: %w", reindexing.Destination, err)
+		}
+		for _, item := range deleted.Failed() {
+			if item.Status != http.StatusNotFound {
+				return fmt.Errorf("deleting %s from %s: %s", item.Id, reindexing.Destination, item.Error.Reason)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package elastic
+
+import (
+	"io"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/snabble/go-jstore/v2"
+)
+
+// ScanBatchSize sets the number of documents fetched per scroll
+// request of Scan.
+func ScanBatchSize(size int) ElasticStoreOption {
+	return func(store *ElasticStore) error {
+		store.scanBatchSize = size
+		return nil
+	}
+}
+
+// Scan iterates over all matching documents using the scroll api.
+// Without SortOptions, the documents are returned in index order,
+// which is the most efficient way to scroll.
+func (store *ElasticStore) Scan(project, documentType string, options ...jstore.Option) jstore.Iterator {
+	query, sorters, err := createQuery(options...)
+	if err != nil {
+		return jstore.ErrorIterator(err)
+	}
+	if len(sorters) == 0 {
+		sorters = append(sorters, elastic.SortByDoc{})
+	}
+
+	source := elastic.NewSearchSource().
+		Query(query).
+		SortBy(sorters...).
+		SeqNoAndPrimaryTerm(true)
+
+	scroll := store.client.
+		Scroll(store.indexName(project, documentType, true)).
+		SearchSource(source).
+		Size(store.scanBatchSize).
+		KeepAlive("1m")
+
+	return &scrollIterator{
+		store:        store,
+		project:      project,
+		documentType: documentType,
+		scroll:       scroll,
+	}
+}
+
+type scrollIterator struct {
+	store        *ElasticStore
+	project      string
+	documentType string
+	scroll       *elastic.ScrollService
+
+	hits    []*elastic.SearchHit
+	current jstore.Entity
+	done    bool
+	err     error
+}
+
+func (it *scrollIterator) Next() bool {
+	for len(it.hits) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+
+		resp, err := it.scroll.Do(it.store.cntx())
+		if err == io.EOF || elastic.IsNotFound(err) {
+			it.done = true
+			return false
+		}
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if resp.Hits == nil || len(resp.Hits.Hits) == 0 {
+			it.done = true
+			return false
+		}
+		it.hits = resp.Hits.Hits
+	}
+
+	it.current = toEntity(it.project, it.documentType, it.hits[0])
+	it.hits = it.hits[1:]
+	return true
+}
+
+func (it *scrollIterator) Entity() jstore.Entity {
+	return it.current
+}
+
+func (it *scrollIterator) Err() error {
+	return it.err
+}
+
+func (it *scrollIterator) Close() error {
+	it.done = true
+	it.hits = nil
+	return it.scroll.Clear(it.store.cntx())
+}
@@ -0,0 +1,217 @@
+package elastic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/snabble/go-jstore/v2"
+)
+
+// Granularity is the period of time covered by one index of
+// TimestampIndex.
+type Granularity int
+
+const (
+	// Daily indices are named <project>-<documentType>-2006.01.02.
+	Daily Granularity = iota
+	// Weekly indices are named by the ISO week, e.g.
+	// <project>-<documentType>-2006.w01.
+	Weekly
+	// Monthly indices are named <project>-<documentType>-2006.01.
+	Monthly
+)
+
+func (granularity Granularity) suffix(t time.Time) string {
+	t = t.UTC()
+	switch granularity {
+	case Weekly:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04d.w%02d", year, week)
+	case Monthly:
+		return t.Format("2006.01")
+	default:
+		return t.Format("2006.01.02")
+	}
+}
+
+// TimestampIndex stores every document in the index of the period of
+// its timestamp property, so backfilled or delayed documents land in
+// the index of their own time instead of the current one. The property
+// is a dotted path to a date string in RFC 3339 format or to epoch
+// milliseconds.
+//
+// Save, Insert and Delete look up the index of an existing document
+// with realtime reads, so they see all previous writes without a
+// refresh. Save first reads the index of the timestamp. If the document
+// is not there, like on every Insert and Delete, all indices of the
+// document type are read, which costs two more requests. Insert fails,
+// if the document exists in any index. An existing document stays in
+// its index, even if its timestamp changes.
+//
+// As the lookups are synchronous, TimestampIndex can not be combined
+// with AsyncWrites. Document types must not contain dashes, so the
+// indices of one document type do not match those of another.
+func TimestampIndex(property string, granularity Granularity) ElasticStoreOption {
+	return func(store *ElasticStore) error {
+		if granularity < Daily || granularity > Monthly {
+			return fmt.Errorf("invalid granularity %d", granularity)
+		}
+		if store.prepareIndex != nil || store.bulk != nil {
+			return errors.New("timestamp indices can not be combined with rollover indices, mappings or asynchronous writes")
+		}
+		router := &timestampRouter{property: strings.Split(property, "."), granularity: granularity}
+		store.indexName = router.indexNamer(time.Now)
+		store.timestamps = router
+		return nil
+	}
+}
+
+type timestampRouter struct {
+	property    []string
+	granularity Granularity
+}
+
+// indexNamer returns the namer of the indices. Without a document, the
+// index of the current period is used.
+func (router *timestampRouter) indexNamer(now func() time.Time) IndexNamer {
+	return func(project, documentType string, matchAll bool) string {
+		if matchAll {
+			return router.indexName(project, documentType, "*")
+		}
+		return router.indexName(project, documentType, router.granularity.suffix(now()))
+	}
+}
+
+func (router *timestampRouter) indexName(project, documentType, suffix string) string {
+	return defaultIndexName(project, documentType, false) + "-" + suffix
+}
+
+// documentIndex returns the index of the period of the timestamp in the
+// document.
+func (router *timestampRouter) documentIndex(id jstore.EntityID, document string) (string, error) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(document), &value); err != nil {
+		return "", fmt.Errorf("parsing document %v: %w", id, err)
+	}
+	for _, key := range router.property {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			value = nil
+			break
+		}
+		value = object[key]
+	}
+
+	path := strings.Join(router.property, ".")
+	var timestamp time.Time
+	switch v := value.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return "", fmt.Errorf("timestamp %s of document %v: %w", path, id, err)
+		}
+		timestamp = t
+	case float64:
+		timestamp = time.Unix(0, int64(v)*int64(time.Millisecond))
+	case nil:
+		return "", fmt.Errorf("document %v has no timestamp %s", id, path)
+	default:
+		return "", fmt.Errorf("timestamp %s of document %v is no date: %v", path, id, v)
+	}
+	return router.indexName(id.Project, id.DocumentType, router.granularity.suffix(timestamp)), nil
+}
+
+// writeIndex returns the index to write the document to and, if the
+// document exists there already.
+func (store *ElasticStore) writeIndex(id jstore.EntityID, document string) (string, bool, error) {
+	if store.timestamps == nil {
+		return store.indexName(id.Project, id.DocumentType, false), false, nil
+	}
+	if err := checkTimestampDocumentType(id.DocumentType); err != nil {
+		return "", false, err
+	}
+	index, err := store.timestamps.documentIndex(id, document)
+	if err != nil {
+		return "", false, err
+	}
+
+	// documents are usually updated with the same timestamp
+	exists, err := store.client.Exists().Index(index).Id(id.ID).Do(store.cntx())
+	if err != nil && !elastic.IsNotFound(err) {
+		return "", false, fmt.Errorf("looking up index of %v: %w", id, err)
+	}
+	if exists {
+		return index, true, nil
+	}
+
+	found, ok, err := store.lookupIndex(id)
+	if err != nil || ok {
+		return found, ok, err
+	}
+	return index, false, nil
+}
+
+// checkTimestampDocumentType rejects document types with dashes, because
+// the indices of <type> are matched by <project>-<type>-*, which also
+// matches the indices of <type>-foo.
+func checkTimestampDocumentType(documentType string) error {
+	if strings.Contains(documentType, "-") {
+		return fmt.Errorf("invalid document type %q for timestamp indices", documentType)
+	}
+	return nil
+}
+
+// deleteIndex returns the index containing the document to delete.
+func (store *ElasticStore) deleteIndex(id jstore.EntityID) (string, error) {
+	if store.timestamps == nil {
+		return store.indexName(id.Project, id.DocumentType, false), nil
+	}
+	if err := checkTimestampDocumentType(id.DocumentType); err != nil {
+		return "", err
+	}
+	index, found, err := store.lookupIndex(id)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", jstore.NotFound
+	}
+	return index, nil
+}
+
+// lookupIndex reads the document from all indices of the document type
+// in realtime and returns the index containing it.
+func (store *ElasticStore) lookupIndex(id jstore.EntityID) (string, bool, error) {
+	rows, err := store.client.CatIndices().
+		Index(store.indexName(id.Project, id.DocumentType, true)).
+		Columns("index").
+		Do(store.cntx())
+	if err != nil && !elastic.IsNotFound(err) {
+		return "", false, fmt.Errorf("looking up index of %v: %w", id, err)
+	}
+	if len(rows) == 0 {
+		return "", false, nil
+	}
+
+	mget := store.client.Mget().Realtime(true)
+	for _, row := range rows {
+		mget.Add(elastic.NewMultiGetItem().
+			Index(row.Index).
+			Id(id.ID).
+			FetchSource(elastic.NewFetchSourceContext(false)))
+	}
+	resp, err := mget.Do(store.cntx())
+	if err != nil {
+		return "", false, fmt.Errorf("looking up index of %v: %w", id, err)
+	}
+	for _, doc := range resp.Docs {
+		if doc.Found {
+			return doc.Index, true, nil
+		}
+	}
+	return "", false, nil
+}
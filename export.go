@@ -0,0 +1,119 @@
+package jstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Record is one line of a newline delimited json dump.
+type Record struct {
+	Project      string          `json:"project"`
+	DocumentType string          `json:"documentType"`
+	ID           string          `json:"id"`
+	Document     json.RawMessage `json:"document"`
+}
+
+// ConflictPolicy decides, what happens on import of a document which
+// already exists in the target store.
+type ConflictPolicy int
+
+const (
+	// ConflictSkip keeps the existing document.
+	ConflictSkip ConflictPolicy = iota
+	// ConflictOverwrite replaces the existing document.
+	ConflictOverwrite
+	// ConflictFail aborts the import.
+	ConflictFail
+)
+
+type ImportOptions struct {
+	OnConflict ConflictPolicy
+}
+
+// Export writes all documents of the given document types within the
+// project to the writer, one Record per line. The documents are
+// streamed using Scan, so the export of a store without Scanner fails
+// with ScanLimitExceeded instead of being incomplete.
+func Export(store Store, project string, documentTypes []string, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	for _, documentType := range documentTypes {
+		if err := exportDocumentType(store, project, documentType, encoder); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportDocumentType(store Store, project, documentType string, encoder *json.Encoder) error {
+	it := Scan(store, project, documentType)
+	defer it.Close()
+
+	for it.Next() {
+		entity := it.Entity()
+		err := encoder.Encode(Record{
+			Project:      entity.Project,
+			DocumentType: entity.DocumentType,
+			ID:           entity.ID,
+			Document:     entity.Raw(),
+		})
+		if err != nil {
+			return fmt.Errorf("exporting %s/%s/%s: %w", project, documentType, entity.ID, err)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("exporting %s/%s: %w", project, documentType, err)
+	}
+	return nil
+}
+
+// Import reads Records from the reader and saves them in the store.
+// It returns the number of saved documents. Unless the policy is
+// ConflictOverwrite, the documents are saved with Insert, so documents
+// written concurrently are not overwritten.
+func Import(store Store, r io.Reader, options ImportOptions) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+
+	count := 0
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		record := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return count, fmt.Errorf("import line %d: %w", line, err)
+		}
+		if record.Project == "" || record.DocumentType == "" || record.ID == "" || len(record.Document) == 0 {
+			return count, fmt.Errorf("import line %d: project, documentType, id and document are required", line)
+		}
+
+		id := NewID(record.Project, record.DocumentType, record.ID)
+		// the document of the record is not used elsewhere
+		var err error
+		if options.OnConflict == ConflictOverwrite {
+			_, err = store.Save(id, bytesToString(record.Document))
+		} else {
+			_, err = Insert(store, id, bytesToString(record.Document))
+		}
+		switch {
+		case errors.Is(err, AlreadyExists) && options.OnConflict == ConflictSkip:
+			continue
+		case errors.Is(err, AlreadyExists):
+			return count, fmt.Errorf("import line %d: %v: %w", line, id, err)
+		case err != nil:
+			return count, fmt.Errorf("import line %d: %w", line, err)
+		}
+		count++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return count, fmt.Errorf("import line %d: %w", line+1, err)
+	}
+	return count, nil
+}
@@ -0,0 +1,106 @@
+package jstore_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/snabble/go-jstore/v2"
+	"github.com/snabble/go-jstore/v2/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ExportImport(t *testing.T) {
+	source, err := jstore.NewStore(memory.DriverName, "")
+	require.NoError(t, err)
+
+	_, err = source.Save(jstore.NewID("project", "person", "ford"), `{"name":"Ford Prefect"}`)
+	require.NoError(t, err)
+	_, err = source.Save(jstore.NewID("project", "ship", "heartOfGold"), `{"name":"Heart Of Gold"}`)
+	require.NoError(t, err)
+	_, err = source.Save(jstore.NewID("other", "person", "marvin"), `{"name":"Marvin"}`)
+	require.NoError(t, err)
+
+	dump := &bytes.Buffer{}
+	require.NoError(t, jstore.Export(source, "project", []string{"person", "ship", "missing"}, dump))
+	assert.Equal(t, 2, strings.Count(dump.String(), "\n"))
+
+	target, err := jstore.NewStore(memory.DriverName, "")
+	require.NoError(t, err)
+
+	count, err := jstore.Import(target, dump, jstore.ImportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	entity, err := target.Get(jstore.NewID("project", "ship", "heartOfGold"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"Heart Of Gold"}`, entity.JSON)
+
+	_, err = target.Get(jstore.NewID("other", "person", "marvin"))
+	assert.Equal(t, jstore.NotFound, err)
+}
+
+func Test_Import_ConflictPolicies(t *testing.T) {
+	dump := `{"project":"project","documentType":"person","id":"ford","document":{"name":"Ford"}}
+{"project":"project","documentType":"person","id":"zaphod","document":{"name":"Zaphod"}}
+`
+	for _, test := range []struct {
+		name          string
+		policy        jstore.ConflictPolicy
+		expectedCount int
+		expectedName  string
+		expectedError error
+	}{
+		{"skip", jstore.ConflictSkip, 1, "Ford Prefect", nil},
+		{"overwrite", jstore.ConflictOverwrite, 2, "Ford", nil},
+		{"fail", jstore.ConflictFail, 0, "Ford Prefect", jstore.AlreadyExists},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := jstore.NewStore(memory.DriverName, "")
+			require.NoError(t, err)
+			_, err = store.Save(jstore.NewID("project", "person", "ford"), `{"name":"Ford Prefect"}`)
+			require.NoError(t, err)
+
+			count, err := jstore.Import(store, strings.NewReader(dump), jstore.ImportOptions{OnConflict: test.policy})
+			assert.True(t, errors.Is(err, test.expectedError))
+			assert.Equal(t, test.expectedCount, count)
+
+			result := struct{ Name string }{}
+			require.NoError(t, store.Unmarshal(&result, "project", "person", jstore.Id("ford")))
+			assert.Equal(t, test.expectedName, result.Name)
+		})
+	}
+}
+
+// concurrentWriterStore saves a document right before every Insert.
+type concurrentWriterStore struct {
+	jstore.Store
+}
+
+func (store concurrentWriterStore) Insert(id jstore.EntityID, json string) (jstore.EntityID, error) {
+	store.Store.Save(id, `{"name":"Concurrent"}`)
+	return jstore.Insert(store.Store, id, json)
+}
+
+func Test_Import_ConcurrentWrite(t *testing.T) {
+	memoryStore, _ := memory.NewMemoryStore("")
+	dump := `{"project":"project","documentType":"person","id":"ford","document":{"name":"Ford"}}`
+
+	count, err := jstore.Import(concurrentWriterStore{memoryStore}, strings.NewReader(dump), jstore.ImportOptions{OnConflict: jstore.ConflictSkip})
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	entity, err := memoryStore.Get(jstore.NewID("project", "person", "ford"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"Concurrent"}`, entity.JSON)
+}
+
+func Test_Import_InvalidRecord(t *testing.T) {
+	store, err := jstore.NewStore(memory.DriverName, "")
+	require.NoError(t, err)
+
+	_, err = jstore.Import(store, strings.NewReader(`{"project":"project","id":"ford","document":{}}`), jstore.ImportOptions{})
+	assert.Error(t, err)
+}
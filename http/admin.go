@@ -0,0 +1,85 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	jstore "github.com/snabble/go-jstore/v2"
+)
+
+type AdminPermit func(r *http.Request) bool
+
+// AdminRoute exposes the content of the store:
+//
+//	GET /admin/projects                              lists the projects
+//	GET /admin/projects/{project}                    lists the document types
+//	GET /admin/projects/{project}/{documentType}     returns the stats
+//
+// The lastModified of the stats is null, if the store does not track
+// it.
+func AdminRoute(
+	root *mux.Router,
+	discoverer jstore.Discoverer,
+	permit AdminPermit,
+) {
+	handle := func(path string, handler func(w Response, r *http.Request)) {
+		root.Handle(path, checkAdminAccessHandler(permit, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler(Response{Writer: w}, r)
+		}))).
+			Methods(http.MethodGet)
+	}
+
+	handle("/admin/projects", func(w Response, r *http.Request) {
+		projects, err := discoverer.ListProjects()
+		if err != nil {
+			w.SendError(err)
+			return
+		}
+		w.Send(http.StatusOK, struct {
+			Projects []string `json:"projects"`
+		}{projects})
+	})
+
+	handle("/admin/projects/{project}", func(w Response, r *http.Request) {
+		documentTypes, err := discoverer.ListDocumentTypes(mux.Vars(r)["project"])
+		if err != nil {
+			w.SendError(err)
+			return
+		}
+		w.Send(http.StatusOK, struct {
+			DocumentTypes []string `json:"documentTypes"`
+		}{documentTypes})
+	})
+
+	handle("/admin/projects/{project}/{documentType}", func(w Response, r *http.Request) {
+		vars := mux.Vars(r)
+		stats, err := discoverer.Stats(vars["project"], vars["documentType"])
+		if err != nil {
+			w.SendError(err)
+			return
+		}
+		response := struct {
+			Count        int64      `json:"count"`
+			SizeInBytes  int64      `json:"sizeInBytes"`
+			LastModified *time.Time `json:"lastModified"`
+		}{Count: stats.Count, SizeInBytes: stats.SizeInBytes}
+		if !stats.LastModified.IsZero() {
+			response.LastModified = &stats.LastModified
+		}
+		w.Send(http.StatusOK, response)
+	})
+}
+
+// checkAdminAccessHandler returns a new handler, which checks, that
+// the client is permitted to access the admin routes.
+func checkAdminAccessHandler(permit AdminPermit, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !permit(r) {
+			sendError(w, errors.New("forbidden"), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
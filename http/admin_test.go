@@ -0,0 +1,69 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	jstore "github.com/snabble/go-jstore/v2"
+	"github.com/snabble/go-jstore/v2/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Admin(t *testing.T) {
+	store, _ := jstore.NewStore(memory.DriverName, "")
+	store.Save(jstore.NewID("project", "entity", "earth"), `{"message":"hello world"}`)
+	store.Save(jstore.NewID("project", "other", "mars"), `{"message":"hello mars"}`)
+
+	discoverer, ok := jstore.AsDiscoverer(store)
+	require.True(t, ok)
+
+	router := mux.NewRouter()
+	AdminRoute(router, discoverer, func(r *http.Request) bool { return true })
+
+	response := getRequest(router, "http://test/admin/projects")
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"projects":["project"]}`, response.Body.String())
+
+	response = getRequest(router, "http://test/admin/projects/project")
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"documentTypes":["entity","other"]}`, response.Body.String())
+
+	response = getRequest(router, "http://test/admin/projects/project/entity")
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `"count":1`)
+
+	response = getRequest(router, "http://test/admin/projects/unknown/entity")
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
+
+// untrackedStats is a discoverer without the time of the last
+// modification.
+type untrackedStats struct {
+	jstore.Discoverer
+}
+
+func (untrackedStats) Stats(project, documentType string) (jstore.Stats, error) {
+	return jstore.Stats{Count: 1, SizeInBytes: 42}, nil
+}
+
+func Test_Admin_UntrackedLastModified(t *testing.T) {
+	router := mux.NewRouter()
+	AdminRoute(router, untrackedStats{}, func(r *http.Request) bool { return true })
+
+	response := getRequest(router, "http://test/admin/projects/project/entity")
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"count":1,"sizeInBytes":42,"lastModified":null}`, response.Body.String())
+}
+
+func Test_Admin_ChecksPermits(t *testing.T) {
+	store, _ := jstore.NewStore(memory.DriverName, "")
+	discoverer, _ := jstore.AsDiscoverer(store)
+
+	router := mux.NewRouter()
+	AdminRoute(router, discoverer, func(r *http.Request) bool { return false })
+
+	response := getRequest(router, "http://test/admin/projects")
+	assert.Equal(t, http.StatusForbidden, response.Code)
+}
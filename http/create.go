@@ -19,14 +19,14 @@ func create(store Store,
 			return
 		}
 
-		_, err = store.Marshal(entity, jstore.NewID(r.Project, r.DocumentType, id))
+		savedID, err := createEntity(store, entity, jstore.NewID(r.Project, r.DocumentType, id))
 
 		if err != nil {
 			w.SendError(err)
 			return
 		}
 
-		selfLink := urls.Entity(r.Project, r.DocumentType, id)
+		selfLink := urls.Entity(r.Project, r.DocumentType, savedID.ID)
 
 		w.AddHeader("Location", selfLink.String())
 
@@ -37,3 +37,19 @@ func create(store Store,
 		}
 	}
 }
+
+func createEntity(store Store, entity interface{}, id jstore.EntityID) (jstore.EntityID, error) {
+	creator, ok := store.(Creator)
+	switch {
+	case !ok:
+		if _, err := store.Marshal(entity, id); err != nil {
+			return jstore.EntityID{}, err
+		}
+		return id, nil
+	case id.ID == "":
+		// an empty id lets the store generate one
+		return creator.MarshalCreate(entity, id.Project, id.DocumentType)
+	default:
+		return creator.MarshalInsert(entity, id)
+	}
+}
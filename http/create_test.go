@@ -93,6 +93,73 @@ func Test_Create_Success_Without_Body_Response(t *testing.T) {
 	assert.Equal(t, "", string(bodyBytes))
 }
 
+func Test_Create_GeneratesID(t *testing.T) {
+	store, _ := jstore.NewStore(memory.DriverName, "", jstore.GenerateIDs(func() (string, error) {
+		return "generated", nil
+	}))
+	router := mux.NewRouter()
+
+	Expose(
+		router,
+		store,
+		allPermited,
+		allPermited,
+		allPermited,
+		allPermited,
+		nullQueryExtractor,
+		func(r Request) (string, interface{}, error) {
+			return "", TestEntity{Message: "hello world"}, nil
+		},
+		func() interface{} {
+			return TestEntity{}
+		},
+		nullWithLinks,
+		documentTypes,
+		map[string]string{},
+	)
+
+	response := postRequest(router, "http://test/project/entity", `{"message":"hello world"}`)
+
+	require.Equal(t, http.StatusCreated, response.Code)
+	assert.Equal(t, "/project/entity/generated", response.Header().Get("Location"))
+
+	_, err := store.Get(jstore.NewID("project", "entity", "generated"))
+	assert.NoError(t, err)
+}
+
+func Test_Create_Conflict(t *testing.T) {
+	store, _ := jstore.NewStore(memory.DriverName, "")
+	router := mux.NewRouter()
+
+	Expose(
+		router,
+		store,
+		allPermited,
+		allPermited,
+		allPermited,
+		allPermited,
+		nullQueryExtractor,
+		func(r Request) (string, interface{}, error) {
+			return "id", TestEntity{Message: "hello mars"}, nil
+		},
+		func() interface{} {
+			return TestEntity{}
+		},
+		nullWithLinks,
+		documentTypes,
+		map[string]string{},
+	)
+	store.Marshal(TestEntity{Message: "hello world"}, jstore.NewID("project", "entity", "id"))
+
+	response := postRequest(router, "http://test/project/entity", `{"message":"hello mars"}`)
+
+	require.Equal(t, http.StatusConflict, response.Code)
+
+	entity, err := store.Get(jstore.NewID("project", "entity", "id"))
+	require.NoError(t, err)
+	assert.Equal(t, `{"message":"hello world"}`, entity.JSON)
+}
+
 func Test_Create_ExtractionErrors(t *testing.T) {
 	for _, test := range []struct {
 		name           string
@@ -168,3 +235,42 @@ func Test_Create_ChecksPermits(t *testing.T) {
 
 	require.Equal(t, http.StatusForbidden, response.Code)
 }
+
+// basicStore implements none of the optional interfaces.
+type basicStore struct {
+	Store
+}
+
+func Test_Create_BasicStore(t *testing.T) {
+	store, _ := jstore.NewStore(memory.DriverName, "")
+	router := mux.NewRouter()
+
+	Expose(
+		router,
+		basicStore{store},
+		allPermited,
+		allPermited,
+		allPermited,
+		allPermited,
+		nullQueryExtractor,
+		func(r Request) (string, interface{}, error) {
+			return "id", TestEntity{Message: "hello mars"}, nil
+		},
+		func() interface{} {
+			return &TestEntity{}
+		},
+		nullWithLinks,
+		documentTypes,
+		map[string]string{},
+	)
+	store.Marshal(TestEntity{Message: "hello world"}, jstore.NewID("project", "entity", "id"))
+
+	// stores without Creator overwrite like Marshal
+	response := postRequest(router, "http://test/project/entity", `{"message":"hello mars"}`)
+	require.Equal(t, http.StatusCreated, response.Code)
+	assert.Equal(t, "/project/entity/id", response.Header().Get("Location"))
+
+	response = getRequest(router, "http://test/project/entity/id")
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"message":"hello mars"}`, response.Body.String())
+}
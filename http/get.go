@@ -11,7 +11,12 @@ func get(store Store, provider EntityProvider, withLinks WithLinks, urls *URLBui
 		var entity interface{}
 		entity = provider()
 
-		err := store.Unmarshal(entity, r.Project, r.DocumentType, jstore.Id(r.ID))
+		var err error
+		if unmarshaler, ok := store.(IDUnmarshaler); ok {
+			err = unmarshaler.UnmarshalByID(entity, r.EntityID())
+		} else {
+			err = store.Unmarshal(entity, r.Project, r.DocumentType, jstore.Id(r.ID))
+		}
 
 		if err != nil {
 			w.SendError(err)
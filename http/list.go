@@ -1,7 +1,6 @@
 package http
 
 import (
-	"encoding/json"
 	"net/http"
 
 	jstore "github.com/snabble/go-jstore/v2"
@@ -16,12 +15,17 @@ func list(
 	withLinks WithLinks,
 	urls *URLBuilder,
 ) func(w Response, r Request) {
+	unmarshal := jstore.UnmarshalEntity
+	if unmarshaler, ok := store.(jstore.EntityUnmarshaler); ok {
+		unmarshal = unmarshaler.UnmarshalEntity
+	}
+
 	toResources := func(items []jstore.Entity) ([]interface{}, error) {
 		entities := make([]interface{}, 0, len(items))
 
 		for _, item := range items {
 			entity := provider()
-			err := json.Unmarshal([]byte(item.JSON), entity)
+			err := unmarshal(item, entity)
 			if err != nil {
 				return []interface{}{}, err
 			}
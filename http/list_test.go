@@ -289,3 +289,31 @@ func unmarshalTestEntityList(t *testing.T, expected, actual string) (bool, TestE
 
 	return true, expectedList, actualList
 }
+
+func Test_List_StoreCodec(t *testing.T) {
+	store, _ := jstore.NewStore(memory.DriverName, "", jstore.StrictJSON)
+	router := mux.NewRouter()
+	Expose(
+		router,
+		store,
+		allPermited,
+		allPermited,
+		allPermited,
+		allPermited,
+		nullQueryExtractor,
+		nullBodyExtractor,
+		func() interface{} {
+			return &TestEntity{}
+		},
+		func(entity interface{}, links Links) interface{} {
+			return TestEntityWithLinks{*entity.(*TestEntity), links}
+		},
+		documentTypes,
+		map[string]string{},
+	)
+	store.Save(jstore.NewID("project", "entity", "earth"), `{"message":"hello world","unknown":true}`)
+
+	response := getRequest(router, "http://test/project/entity")
+
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+}
@@ -23,6 +23,21 @@ type Store interface {
 	FindN(project, documentType string, maxResults int, options ...jstore.Option) ([]jstore.Entity, error)
 }
 
+// Creator is implemented by stores, which create documents without
+// overwriting existing ones, like jstore.JStore. Stores without Creator
+// save created documents with Marshal.
+type Creator interface {
+	MarshalCreate(object interface{}, project, documentType string) (jstore.EntityID, error)
+	MarshalInsert(object interface{}, id jstore.EntityID) (jstore.EntityID, error)
+}
+
+// IDUnmarshaler is implemented by stores, which read a document by its
+// id, like jstore.JStore. Stores without IDUnmarshaler are read with
+// Unmarshal and jstore.Id.
+type IDUnmarshaler interface {
+	UnmarshalByID(entityOrObjectRef interface{}, id jstore.EntityID) error
+}
+
 func Expose(
 	router *mux.Router,
 	store Store,
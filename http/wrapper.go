@@ -71,6 +71,9 @@ func selectStatusCode(err error) int {
 		if err == jstore.NotFound {
 			return http.StatusNotFound
 		}
+		if err == jstore.AlreadyExists {
+			return http.StatusConflict
+		}
 	}
 	return http.StatusInternalServerError
 }
@@ -0,0 +1,78 @@
+package jstore
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IDGenerator creates ids for new documents.
+type IDGenerator func() (string, error)
+
+var (
+	// UUIDv4 generates random UUIDs.
+	UUIDv4 IDGenerator = func() (string, error) {
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return "", err
+		}
+		return id.String(), nil
+	}
+
+	// UUIDv7 generates time ordered UUIDs as specified by RFC 9562.
+	UUIDv7 IDGenerator = func() (string, error) {
+		id := uuid.UUID{}
+		if _, err := rand.Read(id[6:]); err != nil {
+			return "", err
+		}
+		putMillis(id[:6], time.Now())
+		id[6] = (id[6] & 0x0f) | 0x70 // version 7
+		id[8] = (id[8] & 0x3f) | 0x80 // RFC 4122 variant
+		return id.String(), nil
+	}
+
+	// ULID generates lexicographically sortable ids. For details see:
+	//
+	// https://github.com/ulid/spec
+	ULID IDGenerator = func() (string, error) {
+		id := [16]byte{}
+		if _, err := rand.Read(id[6:]); err != nil {
+			return "", err
+		}
+		putMillis(id[:6], time.Now())
+		return encodeCrockford(id), nil
+	}
+)
+
+// GenerateIDs sets the IDGenerator used by Create. Default is UUIDv4.
+func GenerateIDs(generator IDGenerator) JStoreOption {
+	return func(store *marshalStore) {
+		store.generateID = generator
+	}
+}
+
+// putMillis writes the unix time in milliseconds as 48 bit big endian.
+func putMillis(b []byte, t time.Time) {
+	millis := make([]byte, 8)
+	binary.BigEndian.PutUint64(millis, uint64(t.UnixNano()/int64(time.Millisecond)))
+	copy(b, millis[2:])
+}
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// encodeCrockford encodes the 128 bits as 26 characters of 5 bits,
+// with two leading zero bits of padding.
+func encodeCrockford(id [16]byte) string {
+	high := binary.BigEndian.Uint64(id[:8])
+	low := binary.BigEndian.Uint64(id[8:])
+
+	out := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordAlphabet[low&0x1f]
+		low = (low >> 5) | (high << 59)
+		high >>= 5
+	}
+	return string(out)
+}
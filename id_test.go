@@ -0,0 +1,121 @@
+package jstore_test
+
+import (
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/snabble/go-jstore/v2"
+	"github.com/snabble/go-jstore/v2/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_IDGenerators(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		generator jstore.IDGenerator
+		format    *regexp.Regexp
+		sortable  bool
+	}{
+		{
+			name:      "UUIDv4",
+			generator: jstore.UUIDv4,
+			format:    regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		},
+		{
+			name:      "UUIDv7",
+			generator: jstore.UUIDv7,
+			format:    regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+			sortable:  true,
+		},
+		{
+			name:      "ULID",
+			generator: jstore.ULID,
+			format:    regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`),
+			sortable:  true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			seen := map[string]bool{}
+			prefixes := []string{}
+			for i := 0; i < 1000; i++ {
+				id, err := test.generator()
+				require.NoError(t, err)
+				assert.Regexp(t, test.format, id)
+				assert.False(t, seen[id], "duplicate id %s", id)
+				seen[id] = true
+				// the first 48 bits contain the time
+				prefixes = append(prefixes, id[:8])
+			}
+			if test.sortable {
+				assert.True(t, sort.StringsAreSorted(prefixes))
+			}
+		})
+	}
+}
+
+func Test_Create(t *testing.T) {
+	store, err := jstore.NewStore(memory.DriverName, "", jstore.GenerateIDs(func() (string, error) {
+		return "generated", nil
+	}))
+	require.NoError(t, err)
+
+	id, err := store.Create("project", "person", `{"name":"ford"}`)
+	require.NoError(t, err)
+	assert.Equal(t, "generated", id.ID)
+
+	entity, err := store.Get(jstore.NewID("project", "person", "generated"))
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"ford"}`, entity.JSON)
+
+	_, err = store.Create("project", "person", `{"name":"marvin"}`)
+	assert.Equal(t, jstore.AlreadyExists, err)
+
+	bucket := store.Bucket("project", "robot")
+	id, err = bucket.MarshalCreate(map[string]string{"name": "marvin"})
+	require.NoError(t, err)
+	assert.Equal(t, jstore.NewID("project", "robot", "generated"), jstore.NewID(id.Project, id.DocumentType, id.ID))
+}
+
+func Test_Insert(t *testing.T) {
+	memoryStore, _ := memory.NewMemoryStore("")
+	for name, store := range map[string]jstore.JStore{
+		"inserter": jstore.WrapStore(memoryStore),
+		"fallback": jstore.WrapStore(unhealthyStore{memoryStore}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			id := jstore.NewID(name, "person", "ford")
+
+			_, err := store.Insert(id, `{"name":"ford"}`)
+			require.NoError(t, err)
+
+			_, err = store.Insert(id, `{"name":"marvin"}`)
+			assert.Equal(t, jstore.AlreadyExists, err)
+
+			_, err = store.Bucket(name, "person").MarshalInsert(map[string]string{"name": "marvin"}, id)
+			assert.Equal(t, jstore.AlreadyExists, err)
+
+			entity, err := store.Get(id)
+			require.NoError(t, err)
+			assert.Equal(t, `{"name":"ford"}`, entity.JSON)
+		})
+	}
+}
+
+func Test_MirrorStore_Insert(t *testing.T) {
+	primary, _ := memory.NewMemoryStore("")
+	secondary, _ := memory.NewMemoryStore("")
+	secondary.Save(jstore.NewID("project", "person", "ford"), `{"name":"stale"}`)
+	store := jstore.WrapStore(jstore.MirrorStore(primary, secondary))
+
+	_, err := store.Insert(jstore.NewID("project", "person", "ford"), `{"name":"ford"}`)
+	require.NoError(t, err)
+
+	entity, err := secondary.Get(jstore.NewID("project", "person", "ford"))
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"ford"}`, entity.JSON)
+
+	_, err = store.Insert(jstore.NewID("project", "person", "ford"), `{"name":"marvin"}`)
+	assert.Equal(t, jstore.AlreadyExists, err)
+}
@@ -0,0 +1,465 @@
+// Package jstoretest provides utilities for testing jstore providers
+// and code using jstore.
+package jstoretest
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/snabble/go-jstore/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Factory creates the store under test. It is called once per test
+// case. Every test case uses its own random project, so the returned
+// stores may share their backend.
+type Factory func(t *testing.T) jstore.Store
+
+type Option func(cfg *config)
+
+type config struct {
+	skip map[string]bool
+}
+
+// Skip skips the named test cases of the suite, e.g. for semantics a
+// provider does not support yet.
+func Skip(names ...string) Option {
+	return func(cfg *config) {
+		for _, name := range names {
+			cfg.skip[name] = true
+		}
+	}
+}
+
+type conformanceTest struct {
+	name string
+	run  func(t *testing.T, store jstore.Store, project string)
+}
+
+var conformanceTests = []conformanceTest{
+	{"CRUD", testCRUD},
+	{"Versioning", testVersioning},
+	{"CompareOptions", testCompareOptions},
+	{"Sorting", testSorting},
+	{"MultiKeySorting", testMultiKeySorting},
+	{"MaxResults", testMaxResults},
+	{"EmptyStore", testEmptyStore},
+	{"MissingProperties", testMissingProperties},
+	{"UnicodeIDs", testUnicodeIDs},
+	{"Scan", testScan},
+	{"Insert", testInsert},
+}
+
+// RunConformance runs the suite of tests, every jstore provider has to
+// pass against the stores created by the factory.
+func RunConformance(t *testing.T, factory Factory, options ...Option) {
+	cfg := config{skip: map[string]bool{}}
+	for _, option := range options {
+		option(&cfg)
+	}
+
+	for _, test := range conformanceTests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			if cfg.skip[test.name] {
+				t.Skip("skipped by configuration")
+			}
+			test.run(t, factory(t), randomProject())
+		})
+	}
+}
+
+type person struct {
+	Name     string    `json:"name"`
+	Age      int       `json:"age"`
+	Height   float64   `json:"height"`
+	BirthDay time.Time `json:"birthDay"`
+	Nickname string    `json:"nickname,omitempty"`
+}
+
+var (
+	ford   = person{"ford", 42, 1.78, day("1980-01-01"), ""}
+	marvin = person{"marvin", 1010, 2.05, day("2042-01-01"), "paranoid"}
+	zaphod = person{"zaphod", 4200, 1.92, day("1900-01-01"), ""}
+)
+
+func testCRUD(t *testing.T, store jstore.Store, project string) {
+	id := jstore.NewID(project, "person", "ford")
+
+	saved, err := store.Save(id, toJSON(t, ford))
+	require.NoError(t, err)
+	assert.Equal(t, project, saved.Project)
+	assert.Equal(t, "person", saved.DocumentType)
+	assert.Equal(t, "ford", saved.ID)
+
+	entity, err := store.Get(id)
+	require.NoError(t, err)
+	assert.Equal(t, saved, entity.EntityID)
+	assert.Equal(t, ford, fromJSON(t, entity.JSON))
+
+	entity, err = store.Find(project, "person", jstore.Id("ford"))
+	require.NoError(t, err)
+	assert.Equal(t, saved, entity.EntityID)
+
+	updated, err := store.Save(id, toJSON(t, marvin))
+	require.NoError(t, err)
+	entity, err = store.Get(id)
+	require.NoError(t, err)
+	assert.Equal(t, updated, entity.EntityID)
+	assert.Equal(t, marvin, fromJSON(t, entity.JSON))
+
+	require.NoError(t, store.Delete(id))
+	_, err = store.Get(id)
+	assert.Equal(t, jstore.NotFound, err)
+	_, err = store.Find(project, "person", jstore.Id("ford"))
+	assert.Equal(t, jstore.NotFound, err)
+}
+
+func testVersioning(t *testing.T, store jstore.Store, project string) {
+	id := jstore.NewID(project, "person", "ford")
+
+	first, err := store.Save(id, toJSON(t, ford))
+	require.NoError(t, err)
+	assert.NotEqual(t, jstore.NoVersion, first.Version)
+
+	second, err := store.Save(first, toJSON(t, marvin))
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Version, second.Version)
+
+	t.Run("stale version on update", func(t *testing.T) {
+		_, err := store.Save(first, toJSON(t, zaphod))
+		assert.Equal(t, jstore.OptimisticLockingError, err)
+	})
+
+	t.Run("stale version on delete", func(t *testing.T) {
+		assert.Equal(t, jstore.OptimisticLockingError, store.Delete(first))
+	})
+
+	t.Run("unversioned save outdates previous versions", func(t *testing.T) {
+		third, err := store.Save(id, toJSON(t, zaphod))
+		require.NoError(t, err)
+		assert.NotEqual(t, second.Version, third.Version)
+
+		_, err = store.Save(first, toJSON(t, ford))
+		assert.Equal(t, jstore.OptimisticLockingError, err)
+		_, err = store.Save(second, toJSON(t, ford))
+		assert.Equal(t, jstore.OptimisticLockingError, err)
+
+		entity, err := store.Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, third.Version, entity.Version)
+		assert.Equal(t, zaphod, fromJSON(t, entity.JSON))
+	})
+
+	t.Run("version of get is usable for update", func(t *testing.T) {
+		entity, err := store.Get(id)
+		require.NoError(t, err)
+		_, err = store.Save(entity.EntityID, toJSON(t, ford))
+		assert.NoError(t, err)
+	})
+
+	t.Run("current version on delete", func(t *testing.T) {
+		entity, err := store.Get(id)
+		require.NoError(t, err)
+		require.NoError(t, store.Delete(entity.EntityID))
+		_, err = store.Get(id)
+		assert.Equal(t, jstore.NotFound, err)
+	})
+}
+
+func testCompareOptions(t *testing.T, store jstore.Store, project string) {
+	save(t, store, project, "ford", ford)
+	save(t, store, project, "marvin", marvin)
+	save(t, store, project, "zaphod", zaphod)
+
+	tests := []struct {
+		name     string
+		options  []jstore.Option
+		expected []string
+	}{
+		{"id", []jstore.Option{jstore.Id("marvin")}, []string{"marvin"}},
+		{"unknown id", []jstore.Option{jstore.Id("arthur")}, []string{}},
+		{"string equal", []jstore.Option{jstore.Eq("name", "ford")}, []string{"ford"}},
+		{"int equal", []jstore.Option{jstore.Eq("age", 42)}, []string{"ford"}},
+		{"int64 equal", []jstore.Option{jstore.Eq("age", int64(1010))}, []string{"marvin"}},
+		{"float equal", []jstore.Option{jstore.Eq("height", 1.92)}, []string{"zaphod"}},
+		{"time equal", []jstore.Option{jstore.Eq("birthDay", day("2042-01-01"))}, []string{"marvin"}},
+		{"gt", []jstore.Option{jstore.Gt("age", 1010)}, []string{"zaphod"}},
+		{"gte", []jstore.Option{jstore.Gte("age", 1010)}, []string{"marvin", "zaphod"}},
+		{"lt", []jstore.Option{jstore.Lt("age", 1010)}, []string{"ford"}},
+		{"lte", []jstore.Option{jstore.Lte("age", 1010)}, []string{"ford", "marvin"}},
+		{"gt nothing", []jstore.Option{jstore.Gt("age", 4200)}, []string{}},
+		{"float range", []jstore.Option{jstore.Gt("height", 1.8), jstore.Lt("height", 2.0)}, []string{"zaphod"}},
+		{"time lt", []jstore.Option{jstore.Lt("birthDay", day("1980-01-01"))}, []string{"zaphod"}},
+		{"time lte and gte", []jstore.Option{jstore.Lte("birthDay", day("1980-01-01")), jstore.Gte("birthDay", day("1980-01-01"))}, []string{"ford"}},
+		{"time gt", []jstore.Option{jstore.Gt("birthDay", day("1980-01-01"))}, []string{"marvin"}},
+		{"combined", []jstore.Option{jstore.Gt("age", 42), jstore.Eq("name", "marvin")}, []string{"marvin"}},
+		{"id and compare", []jstore.Option{jstore.Id("ford"), jstore.Gt("age", 100)}, []string{}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			entities, err := store.FindN(project, "person", 10, test.options...)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, test.expected, ids(entities))
+
+			entity, err := store.Find(project, "person", test.options...)
+			if len(test.expected) == 0 {
+				assert.Equal(t, jstore.NotFound, err)
+			} else {
+				require.NoError(t, err)
+				assert.Contains(t, test.expected, entity.ID)
+			}
+		})
+	}
+}
+
+func testSorting(t *testing.T, store jstore.Store, project string) {
+	save(t, store, project, "ford", ford)
+	save(t, store, project, "marvin", marvin)
+	save(t, store, project, "zaphod", zaphod)
+
+	tests := []struct {
+		name     string
+		options  []jstore.Option
+		expected []string
+	}{
+		{"int ascending", []jstore.Option{jstore.SortBy("age", true)}, []string{"ford", "marvin", "zaphod"}},
+		{"int descending", []jstore.Option{jstore.SortBy("age", false)}, []string{"zaphod", "marvin", "ford"}},
+		{"float ascending", []jstore.Option{jstore.SortBy("height", true)}, []string{"ford", "zaphod", "marvin"}},
+		{"time ascending", []jstore.Option{jstore.SortBy("birthDay", true)}, []string{"zaphod", "ford", "marvin"}},
+		{"time descending", []jstore.Option{jstore.SortBy("birthDay", false)}, []string{"marvin", "ford", "zaphod"}},
+		{"filtered", []jstore.Option{jstore.Gt("age", 42), jstore.SortBy("age", false)}, []string{"zaphod", "marvin"}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			entities, err := store.FindN(project, "person", 10, test.options...)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, ids(entities))
+
+			entity, err := store.Find(project, "person", test.options...)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected[0], entity.ID)
+		})
+	}
+}
+
+func testMultiKeySorting(t *testing.T, store jstore.Store, project string) {
+	for id, document := range map[string]string{
+		"a": `{"rank":1,"score":3}`,
+		"b": `{"rank":1,"score":1}`,
+		"c": `{"rank":2,"score":2}`,
+		"d": `{"score":4}`,
+	} {
+		_, err := store.Save(jstore.NewID(project, "result", id), document)
+		require.NoError(t, err)
+	}
+
+	tests := []struct {
+		name     string
+		options  []jstore.Option
+		expected []string
+	}{
+		{"secondary key", []jstore.Option{jstore.SortBy("rank", true), jstore.SortBy("score", false)}, []string{"a", "b", "c", "d"}},
+		{"secondary key ascending", []jstore.Option{jstore.SortBy("rank", true), jstore.SortBy("score", true)}, []string{"b", "a", "c", "d"}},
+		{"missing last descending", []jstore.Option{jstore.SortBy("rank", false), jstore.SortBy("score", true)}, []string{"c", "b", "a", "d"}},
+		{"missing first", []jstore.Option{jstore.SortBy("rank", true, jstore.MissingFirst()), jstore.SortBy("score", true)}, []string{"d", "b", "a", "c"}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			entities, err := store.FindN(project, "result", 10, test.options...)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, ids(entities))
+		})
+	}
+}
+
+func testMaxResults(t *testing.T, store jstore.Store, project string) {
+	for i := 0; i < 25; i++ {
+		save(t, store, project, strconv.Itoa(i), person{Name: "person", Age: i})
+	}
+
+	entities, err := store.FindN(project, "person", 10)
+	require.NoError(t, err)
+	assert.Equal(t, 10, len(entities))
+
+	entities, err = store.FindN(project, "person", 100)
+	require.NoError(t, err)
+	assert.Equal(t, 25, len(entities))
+
+	entities, err = store.FindN(project, "person", 5, jstore.SortBy("age", false))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"24", "23", "22", "21", "20"}, ids(entities))
+}
+
+func testEmptyStore(t *testing.T, store jstore.Store, project string) {
+	_, err := store.Get(jstore.NewID(project, "person", "ford"))
+	assert.Equal(t, jstore.NotFound, err)
+
+	_, err = store.Find(project, "person", jstore.Id("ford"))
+	assert.Equal(t, jstore.NotFound, err)
+
+	_, err = store.Find(project, "person")
+	assert.Equal(t, jstore.NotFound, err)
+
+	_, err = store.FindN(project, "person", 10)
+	assert.Equal(t, jstore.NotFound, err)
+
+	t.Run("other document type", func(t *testing.T) {
+		_, err := store.Save(jstore.NewID(project, "spaceship", "heartofgold"), `{"name":"heartofgold"}`)
+		require.NoError(t, err)
+
+		_, err = store.Get(jstore.NewID(project, "person", "heartofgold"))
+		assert.Equal(t, jstore.NotFound, err)
+	})
+}
+
+func testMissingProperties(t *testing.T, store jstore.Store, project string) {
+	save(t, store, project, "ford", ford)
+	save(t, store, project, "marvin", marvin)
+
+	tests := []struct {
+		name     string
+		options  []jstore.Option
+		expected []string
+	}{
+		{"equal on partially missing property", []jstore.Option{jstore.Eq("nickname", "paranoid")}, []string{"marvin"}},
+		{"equal on missing property", []jstore.Option{jstore.Eq("unknown", "value")}, []string{}},
+		{"range on missing property", []jstore.Option{jstore.Gt("unknown", 1)}, []string{}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			entities, err := store.FindN(project, "person", 10, test.options...)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, test.expected, ids(entities))
+		})
+	}
+}
+
+func testUnicodeIDs(t *testing.T, store jstore.Store, project string) {
+	for _, id := range []string{"ünïcödé", "日本語", "🚀-rocket", "with space", "slash/in/id"} {
+		id := id
+		t.Run(id, func(t *testing.T) {
+			entityID := jstore.NewID(project, "person", id)
+			saved, err := store.Save(entityID, toJSON(t, ford))
+			require.NoError(t, err)
+			assert.Equal(t, id, saved.ID)
+
+			entity, err := store.Get(entityID)
+			require.NoError(t, err)
+			assert.Equal(t, id, entity.ID)
+
+			entity, err = store.Find(project, "person", jstore.Id(id))
+			require.NoError(t, err)
+			assert.Equal(t, id, entity.ID)
+
+			require.NoError(t, store.Delete(entityID))
+			_, err = store.Get(entityID)
+			assert.Equal(t, jstore.NotFound, err)
+		})
+	}
+}
+
+func testScan(t *testing.T, store jstore.Store, project string) {
+	it := jstore.Scan(store, project, "person")
+	assert.False(t, it.Next())
+	assert.NoError(t, it.Err())
+	assert.NoError(t, it.Close())
+
+	for i := 0; i < 25; i++ {
+		save(t, store, project, strconv.Itoa(i), person{Name: "person", Age: i})
+	}
+
+	it = jstore.Scan(store, project, "person", jstore.Gte("age", 5), jstore.SortBy("age", true))
+	defer it.Close()
+
+	scanned := []string{}
+	for it.Next() {
+		scanned = append(scanned, it.Entity().ID)
+		assert.NotEqual(t, jstore.NoVersion, it.Entity().Version)
+	}
+	require.NoError(t, it.Err())
+
+	expected := []string{}
+	for i := 5; i < 25; i++ {
+		expected = append(expected, strconv.Itoa(i))
+	}
+	assert.Equal(t, expected, scanned)
+}
+
+func testInsert(t *testing.T, store jstore.Store, project string) {
+	id := jstore.NewID(project, "person", "ford")
+
+	inserted, err := jstore.Insert(store, id, toJSON(t, ford))
+	require.NoError(t, err)
+	assert.Equal(t, "ford", inserted.ID)
+	assert.NotEqual(t, jstore.NoVersion, inserted.Version)
+
+	_, err = jstore.Insert(store, id, toJSON(t, marvin))
+	assert.Equal(t, jstore.AlreadyExists, err)
+
+	entity, err := store.Get(id)
+	require.NoError(t, err)
+	assert.Equal(t, inserted, entity.EntityID)
+	assert.Equal(t, ford, fromJSON(t, entity.JSON))
+
+	require.NoError(t, store.Delete(id))
+	_, err = jstore.Insert(store, id, toJSON(t, marvin))
+	assert.NoError(t, err)
+}
+
+func save(t *testing.T, store jstore.Store, project, id string, p person) {
+	_, err := store.Save(jstore.NewID(project, "person", id), toJSON(t, p))
+	require.NoError(t, err)
+}
+
+func toJSON(t *testing.T, p person) string {
+	out, err := json.Marshal(p)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func fromJSON(t *testing.T, in string) person {
+	p := person{}
+	require.NoError(t, json.Unmarshal([]byte(in), &p))
+	return p
+}
+
+func ids(entities []jstore.Entity) []string {
+	result := make([]string, 0, len(entities))
+	for _, entity := range entities {
+		result = append(result, entity.ID)
+	}
+	return result
+}
+
+func day(theDay string) time.Time {
+	t, err := time.Parse("2006-01-02", theDay)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+const letters = "abcdefghijklmnopqrstuvwxyz"
+
+var random = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+func randomProject() string {
+	b := make([]byte, 12)
+	for i := range b {
+		b[i] = letters[random.Intn(len(letters))]
+	}
+	return string(b)
+}
@@ -0,0 +1,353 @@
+package jstoretest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/snabble/go-jstore/v2"
+)
+
+// Scenario is a sequence of saves and queries, which is run against two
+// stores to find differences in their semantics, e.g. between the
+// memory store used in unit tests and Elasticsearch.
+//
+// Queries without sort options are compared as sets, so they should
+// request all matching documents. Queries with sort options are
+// compared in order, so their sort keys should be unique.
+type Scenario struct {
+	Name      string     `json:"name"`
+	Documents []Document `json:"documents"`
+	Queries   []Query    `json:"queries"`
+}
+
+// Document is saved before the queries of a scenario run.
+type Document struct {
+	DocumentType string          `json:"documentType"`
+	ID           string          `json:"id"`
+	JSON         json.RawMessage `json:"json"`
+}
+
+// Query is a Find or, with MaxResults set, a FindN.
+type Query struct {
+	Name         string        `json:"name"`
+	DocumentType string        `json:"documentType"`
+	MaxResults   int           `json:"maxResults,omitempty"`
+	Options      []QueryOption `json:"options,omitempty"`
+}
+
+// QueryOption is the serializable form of a jstore.Option. Op is one
+// of "id", "sort" or the operation of a CompareOption like "=" or "<".
+type QueryOption struct {
+	Op       string      `json:"op"`
+	Property string      `json:"property,omitempty"`
+	Value    interface{} `json:"value"`
+
+	// Time compares the value as RFC 3339 timestamp or sorts by time.
+	Time bool `json:"time,omitempty"`
+
+	Ascending    bool `json:"ascending,omitempty"`
+	MissingFirst bool `json:"missingFirst,omitempty"`
+}
+
+// Option returns the jstore.Option.
+func (option QueryOption) Option() (jstore.Option, error) {
+	switch option.Op {
+	case "id":
+		id, ok := option.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("id must be a string, got %T", option.Value)
+		}
+		return jstore.Id(id), nil
+	case "sort":
+		modifiers := []jstore.SortModifier{}
+		if option.Time {
+			modifiers = append(modifiers, jstore.AsTime())
+		}
+		if option.MissingFirst {
+			modifiers = append(modifiers, jstore.MissingFirst())
+		}
+		return jstore.SortBy(option.Property, option.Ascending, modifiers...), nil
+	case "=", "<", "<=", ">", ">=":
+		value := option.Value
+		if option.Time {
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("time must be a string, got %T", value)
+			}
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return nil, err
+			}
+			value = t
+		}
+		return jstore.CompareOption{Property: option.Property, Operation: option.Op, Value: value}, nil
+	default:
+		return nil, fmt.Errorf("unsupported query option: %s", option.Op)
+	}
+}
+
+func (query Query) sorted() bool {
+	for _, option := range query.Options {
+		if option.Op == "sort" {
+			return true
+		}
+	}
+	return false
+}
+
+// Result is the outcome of a query. Only the presence of an error is
+// compared, because the messages differ between stores.
+type Result struct {
+	Query string   `json:"query"`
+	IDs   []string `json:"ids"`
+	Error string   `json:"error,omitempty"`
+}
+
+// Divergence is a query with different results in two stores.
+type Divergence struct {
+	Scenario string `json:"scenario"`
+	Query    string `json:"query"`
+	Reason   string `json:"reason"`
+	Left     Result `json:"left"`
+	Right    Result `json:"right"`
+}
+
+func (d Divergence) String() string {
+	return fmt.Sprintf("%s/%s: %s differs: %s vs %s", d.Scenario, d.Query, d.Reason, d.Left.describe(), d.Right.describe())
+}
+
+func (result Result) describe() string {
+	if result.Error != "" {
+		return "error " + result.Error
+	}
+	return fmt.Sprint(result.IDs)
+}
+
+// Recording holds the results of a scenario in one store. Recordings
+// of Elasticsearch allow differential tests without a live cluster.
+type Recording struct {
+	Scenario Scenario `json:"scenario"`
+	Results  []Result `json:"results"`
+}
+
+// RunScenario saves the documents in a new random project of the store
+// and returns the results of the queries.
+func RunScenario(store jstore.Store, scenario Scenario) ([]Result, error) {
+	project := randomProject()
+	for _, document := range scenario.Documents {
+		id := jstore.NewID(project, document.DocumentType, document.ID)
+		if _, err := jstore.SaveRaw(store, id, document.JSON); err != nil {
+			return nil, fmt.Errorf("saving %s: %w", document.ID, err)
+		}
+	}
+
+	results := make([]Result, 0, len(scenario.Queries))
+	for _, query := range scenario.Queries {
+		options := make([]jstore.Option, 0, len(query.Options))
+		for _, queryOption := range query.Options {
+			option, err := queryOption.Option()
+			if err != nil {
+				return nil, fmt.Errorf("query %s: %w", query.Name, err)
+			}
+			options = append(options, option)
+		}
+
+		result := Result{Query: query.Name, IDs: []string{}}
+		if query.MaxResults == 0 {
+			entity, err := store.Find(project, query.DocumentType, options...)
+			if err == nil {
+				result.IDs = append(result.IDs, entity.ID)
+			} else if !errors.Is(err, jstore.NotFound) {
+				result.Error = err.Error()
+			}
+		} else {
+			entities, err := store.FindN(project, query.DocumentType, query.MaxResults, options...)
+			if err != nil && !errors.Is(err, jstore.NotFound) {
+				result.Error = err.Error()
+			}
+			for _, entity := range entities {
+				result.IDs = append(result.IDs, entity.ID)
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// CompareResults returns the queries of the scenario with different
+// results on the left and right.
+func CompareResults(scenario Scenario, left, right []Result) []Divergence {
+	divergences := []Divergence{}
+	for i, query := range scenario.Queries {
+		if i >= len(left) || i >= len(right) {
+			break
+		}
+		reason := compareResult(query, left[i], right[i])
+		if reason != "" {
+			divergences = append(divergences, Divergence{
+				Scenario: scenario.Name,
+				Query:    query.Name,
+				Reason:   reason,
+				Left:     left[i],
+				Right:    right[i],
+			})
+		}
+	}
+	return divergences
+}
+
+func compareResult(query Query, left, right Result) string {
+	if (left.Error == "") != (right.Error == "") {
+		return "error"
+	}
+	if left.Error != "" {
+		return ""
+	}
+	if !reflect.DeepEqual(sortedCopy(left.IDs), sortedCopy(right.IDs)) {
+		return "result set"
+	}
+	if query.sorted() && !reflect.DeepEqual(left.IDs, right.IDs) {
+		return "order"
+	}
+	return ""
+}
+
+func sortedCopy(ids []string) []string {
+	result := append([]string{}, ids...)
+	sort.Strings(result)
+	return result
+}
+
+// Diff runs the scenarios against both stores and returns the
+// divergences.
+func Diff(left, right jstore.Store, scenarios ...Scenario) ([]Divergence, error) {
+	divergences := []Divergence{}
+	for _, scenario := range scenarios {
+		leftResults, err := RunScenario(left, scenario)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", scenario.Name, err)
+		}
+		rightResults, err := RunScenario(right, scenario)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", scenario.Name, err)
+		}
+		divergences = append(divergences, CompareResults(scenario, leftResults, rightResults)...)
+	}
+	return divergences, nil
+}
+
+// Record runs the scenarios against the store and returns the results
+// for later use with DiffRecordings.
+func Record(store jstore.Store, scenarios ...Scenario) ([]Recording, error) {
+	recordings := make([]Recording, 0, len(scenarios))
+	for _, scenario := range scenarios {
+		results, err := RunScenario(store, scenario)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", scenario.Name, err)
+		}
+		recordings = append(recordings, Recording{Scenario: scenario, Results: results})
+	}
+	return recordings, nil
+}
+
+// DiffRecordings runs the recorded scenarios against the store and
+// returns the divergences. The recordings are on the left.
+func DiffRecordings(store jstore.Store, recordings ...Recording) ([]Divergence, error) {
+	divergences := []Divergence{}
+	for _, recording := range recordings {
+		results, err := RunScenario(store, recording.Scenario)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", recording.Scenario.Name, err)
+		}
+		divergences = append(divergences, CompareResults(recording.Scenario, recording.Results, results)...)
+	}
+	return divergences, nil
+}
+
+// WriteRecordings writes the recordings as JSON.
+func WriteRecordings(w io.Writer, recordings []Recording) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(recordings)
+}
+
+// ReadRecordings reads recordings written by WriteRecordings.
+func ReadRecordings(r io.Reader) ([]Recording, error) {
+	recordings := []Recording{}
+	if err := json.NewDecoder(r).Decode(&recordings); err != nil {
+		return nil, fmt.Errorf("reading recordings: %w", err)
+	}
+	return recordings, nil
+}
+
+// RunDifferential fails the test for every divergence between the
+// stores in the scenarios. Without scenarios, DefaultScenarios are
+// used.
+func RunDifferential(t *testing.T, left, right jstore.Store, scenarios ...Scenario) {
+	if len(scenarios) == 0 {
+		scenarios = DefaultScenarios()
+	}
+	divergences, err := Diff(left, right, scenarios...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, divergence := range divergences {
+		t.Error(divergence)
+	}
+}
+
+// DefaultScenarios returns scenarios, in which the memory store and
+// Elasticsearch agree.
+func DefaultScenarios() []Scenario {
+	people := []Document{
+		{"person", "ford", json.RawMessage(`{"name":"ford","age":42,"height":1.78,"birthDay":"1980-01-01T00:00:00Z"}`)},
+		{"person", "marvin", json.RawMessage(`{"name":"marvin","age":1010,"height":2.05,"birthDay":"2042-01-01T00:00:00Z","nickname":"paranoid"}`)},
+		{"person", "zaphod", json.RawMessage(`{"name":"zaphod","age":4200,"height":1.92,"birthDay":"1900-01-01T00:00:00Z"}`)},
+	}
+
+	return []Scenario{
+		{
+			Name:      "compare",
+			Documents: people,
+			Queries: []Query{
+				{Name: "id", DocumentType: "person", Options: []QueryOption{{Op: "id", Value: "marvin"}}},
+				{Name: "eq string", DocumentType: "person", MaxResults: 10, Options: []QueryOption{{Op: "=", Property: "name", Value: "ford"}}},
+				{Name: "eq number", DocumentType: "person", MaxResults: 10, Options: []QueryOption{{Op: "=", Property: "age", Value: 42}}},
+				{Name: "lt number", DocumentType: "person", MaxResults: 10, Options: []QueryOption{{Op: "<", Property: "age", Value: 1010}}},
+				{Name: "lte number", DocumentType: "person", MaxResults: 10, Options: []QueryOption{{Op: "<=", Property: "age", Value: 1010}}},
+				{Name: "gt float", DocumentType: "person", MaxResults: 10, Options: []QueryOption{{Op: ">", Property: "height", Value: 1.8}}},
+				{Name: "gte time", DocumentType: "person", MaxResults: 10, Options: []QueryOption{{Op: ">=", Property: "birthDay", Value: "1980-01-01T00:00:00Z", Time: true}}},
+				{Name: "combined", DocumentType: "person", MaxResults: 10, Options: []QueryOption{{Op: ">", Property: "age", Value: 100}, {Op: "<", Property: "height", Value: 2}}},
+				{Name: "no match", DocumentType: "person", MaxResults: 10, Options: []QueryOption{{Op: "=", Property: "name", Value: "arthur"}}},
+				{Name: "unknown type", DocumentType: "robot", MaxResults: 10},
+			},
+		},
+		{
+			Name:      "sorting",
+			Documents: people,
+			Queries: []Query{
+				{Name: "number ascending", DocumentType: "person", MaxResults: 10, Options: []QueryOption{{Op: "sort", Property: "age", Ascending: true}}},
+				{Name: "float descending", DocumentType: "person", MaxResults: 10, Options: []QueryOption{{Op: "sort", Property: "height"}}},
+				{Name: "time", DocumentType: "person", MaxResults: 10, Options: []QueryOption{{Op: "sort", Property: "birthDay", Ascending: true, Time: true}}},
+				{Name: "max results", DocumentType: "person", MaxResults: 2, Options: []QueryOption{{Op: "sort", Property: "age"}}},
+				{Name: "filtered", DocumentType: "person", MaxResults: 10, Options: []QueryOption{{Op: ">", Property: "age", Value: 100}, {Op: "sort", Property: "age", Ascending: true}}},
+				{Name: "first", DocumentType: "person", Options: []QueryOption{{Op: "sort", Property: "age", Ascending: true}}},
+			},
+		},
+		{
+			Name:      "missing properties",
+			Documents: people,
+			Queries: []Query{
+				{Name: "eq partially missing", DocumentType: "person", MaxResults: 10, Options: []QueryOption{{Op: "=", Property: "nickname", Value: "paranoid"}}},
+				{Name: "eq missing", DocumentType: "person", MaxResults: 10, Options: []QueryOption{{Op: "=", Property: "unknown", Value: "value"}}},
+				{Name: "range missing", DocumentType: "person", MaxResults: 10, Options: []QueryOption{{Op: ">", Property: "unknown", Value: 1}}},
+			},
+		},
+	}
+}
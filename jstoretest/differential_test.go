@@ -0,0 +1,118 @@
+package jstoretest_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/snabble/go-jstore/v2"
+	"github.com/snabble/go-jstore/v2/jstoretest"
+	"github.com/snabble/go-jstore/v2/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reversedStore returns the results of FindN in reverse order.
+type reversedStore struct {
+	jstore.Store
+}
+
+func (store reversedStore) FindN(project, documentType string, maxResults int, options ...jstore.Option) ([]jstore.Entity, error) {
+	entities, err := store.Store.FindN(project, documentType, maxResults, options...)
+	for i, j := 0, len(entities)-1; i < j; i, j = i+1, j-1 {
+		entities[i], entities[j] = entities[j], entities[i]
+	}
+	return entities, err
+}
+
+func Test_Diff_NoDivergence(t *testing.T) {
+	left, _ := memory.NewMemoryStore("")
+	right, _ := memory.NewMemoryStore("", memory.SortedIndex("*", "age"))
+
+	jstoretest.RunDifferential(t, left, right)
+}
+
+func Test_Diff_Divergences(t *testing.T) {
+	left, _ := memory.NewMemoryStore("")
+	right, _ := memory.NewMemoryStore("")
+	fake := jstoretest.NewFakeStore(reversedStore{right})
+	fake.On(jstoretest.OpFind).Fail(errors.New("failed"))
+
+	scenario := jstoretest.Scenario{
+		Name: "people",
+		Documents: []jstoretest.Document{
+			{DocumentType: "person", ID: "ford", JSON: []byte(`{"age":42}`)},
+			{DocumentType: "person", ID: "marvin", JSON: []byte(`{"age":1010}`)},
+		},
+		Queries: []jstoretest.Query{
+			{Name: "unsorted", DocumentType: "person", MaxResults: 10},
+			{Name: "sorted", DocumentType: "person", MaxResults: 10, Options: []jstoretest.QueryOption{{Op: "sort", Property: "age"}}},
+			{Name: "find", DocumentType: "person", Options: []jstoretest.QueryOption{{Op: "=", Property: "age", Value: 42}}},
+		},
+	}
+
+	divergences, err := jstoretest.Diff(left, fake, scenario)
+	require.NoError(t, err)
+	require.Len(t, divergences, 2)
+	assert.Equal(t, "sorted", divergences[0].Query)
+	assert.Equal(t, "order", divergences[0].Reason)
+	assert.Equal(t, []string{"marvin", "ford"}, divergences[0].Left.IDs)
+	assert.Equal(t, "find", divergences[1].Query)
+	assert.Equal(t, "error", divergences[1].Reason)
+	assert.Equal(t, "people/find: error differs: [ford] vs error failed", divergences[1].String())
+}
+
+func Test_Diff_InvalidOption(t *testing.T) {
+	store, _ := memory.NewMemoryStore("")
+	_, err := jstoretest.Diff(store, store, jstoretest.Scenario{
+		Name:    "invalid",
+		Queries: []jstoretest.Query{{Name: "like", DocumentType: "person", Options: []jstoretest.QueryOption{{Op: "like"}}}},
+	})
+	assert.Error(t, err)
+}
+
+func Test_Diff_Recordings(t *testing.T) {
+	recorded, _ := memory.NewMemoryStore("")
+	recordings, err := jstoretest.Record(recorded, jstoretest.DefaultScenarios()...)
+	require.NoError(t, err)
+
+	buffer := &bytes.Buffer{}
+	require.NoError(t, jstoretest.WriteRecordings(buffer, recordings))
+	recordings, err = jstoretest.ReadRecordings(buffer)
+	require.NoError(t, err)
+
+	store, _ := memory.NewMemoryStore("")
+	divergences, err := jstoretest.DiffRecordings(store, recordings...)
+	require.NoError(t, err)
+	assert.Empty(t, divergences)
+
+	recordings[0].Results[1].IDs = []string{"marvin"}
+	divergences, err = jstoretest.DiffRecordings(store, recordings...)
+	require.NoError(t, err)
+	require.Len(t, divergences, 1)
+	assert.Equal(t, "result set", divergences[0].Reason)
+}
+
+// Test_Diff_ElasticRecordings compares the memory store with results of
+// Elasticsearch in testdata/elastic.json, which can be re-recorded with
+// jstore-diff -record.
+func Test_Diff_ElasticRecordings(t *testing.T) {
+	file, err := os.Open("testdata/elastic.json")
+	require.NoError(t, err)
+	defer file.Close()
+	recordings, err := jstoretest.ReadRecordings(file)
+	require.NoError(t, err)
+	require.NotEmpty(t, recordings)
+
+	// the query values must survive writing the recordings
+	buffer := &bytes.Buffer{}
+	require.NoError(t, jstoretest.WriteRecordings(buffer, recordings))
+	recordings, err = jstoretest.ReadRecordings(buffer)
+	require.NoError(t, err)
+
+	store, _ := memory.NewMemoryStore("")
+	divergences, err := jstoretest.DiffRecordings(store, recordings...)
+	require.NoError(t, err)
+	assert.Empty(t, divergences)
+}
@@ -0,0 +1,370 @@
+package jstoretest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/snabble/go-jstore/v2"
+)
+
+// Operations of the Store interface and the optional interfaces
+// Inserter, RawSaver and Scanner, as used in Calls and Rules.
+const (
+	OpDelete      = "Delete"
+	OpSave        = "Save"
+	OpGet         = "Get"
+	OpFind        = "Find"
+	OpFindN       = "FindN"
+	OpHealthCheck = "HealthCheck"
+	OpInsert      = "Insert"
+	OpSaveRaw     = "SaveRaw"
+	OpScan        = "Scan"
+)
+
+// Call is a call on a FakeStore with its arguments and results.
+type Call struct {
+	Operation string
+
+	// Arguments. Which of them are set depends on the operation.
+	ID           jstore.EntityID
+	Project      string
+	DocumentType string
+	JSON         string
+	MaxResults   int
+	Options      []jstore.Option
+
+	// Results
+	ResultID jstore.EntityID
+	Results  []jstore.Entity
+	Err      error
+}
+
+// Rule scripts the behaviour of a FakeStore for matching calls.
+type Rule struct {
+	operation    string
+	project      string
+	documentType string
+	id           string
+	nth          int
+	times        int
+	err          error
+	delay        time.Duration
+
+	matched int
+	applied int
+}
+
+// ForProject restricts the rule to calls on the project.
+func (rule *Rule) ForProject(project string) *Rule {
+	rule.project = project
+	return rule
+}
+
+// ForDocumentType restricts the rule to calls on the document type.
+func (rule *Rule) ForDocumentType(documentType string) *Rule {
+	rule.documentType = documentType
+	return rule
+}
+
+// ForID restricts the rule to calls on the document id.
+func (rule *Rule) ForID(id string) *Rule {
+	rule.id = id
+	return rule
+}
+
+// OnCall applies the rule to the nth matching call only, counting
+// from 1.
+func (rule *Rule) OnCall(nth int) *Rule {
+	rule.nth = nth
+	return rule
+}
+
+// Times applies the rule to the first n matching calls only.
+func (rule *Rule) Times(n int) *Rule {
+	rule.times = n
+	return rule
+}
+
+// Fail returns the error instead of calling the wrapped store.
+func (rule *Rule) Fail(err error) *Rule {
+	rule.err = err
+	return rule
+}
+
+// NotFound returns jstore.NotFound instead of calling the wrapped
+// store.
+func (rule *Rule) NotFound() *Rule {
+	return rule.Fail(jstore.NotFound)
+}
+
+// Delay waits the duration before the call is executed.
+func (rule *Rule) Delay(delay time.Duration) *Rule {
+	rule.delay = delay
+	return rule
+}
+
+func (rule *Rule) matches(call Call) bool {
+	// rules without effect do not count the calls
+	if rule.operation != call.Operation || (rule.err == nil && rule.delay == 0) {
+		return false
+	}
+	if rule.project != "" && rule.project != call.Project {
+		return false
+	}
+	if rule.documentType != "" && rule.documentType != call.DocumentType {
+		return false
+	}
+	if rule.id != "" && rule.id != call.ID.ID {
+		return false
+	}
+
+	rule.matched++
+	if rule.nth > 0 && rule.matched != rule.nth {
+		return false
+	}
+	if rule.times > 0 && rule.applied >= rule.times {
+		return false
+	}
+	rule.applied++
+	return true
+}
+
+// FakeStore wraps a store, records all calls and can be scripted to
+// fail or delay certain calls, e.g.
+//
+//	fake.On(OpSave).ForDocumentType("person").OnCall(3).Fail(jstore.OptimisticLockingError)
+//
+// Insert, SaveRaw and Scan are recorded as well and delegate to the
+// helpers of the jstore package, so the wrapped store is used like
+// without the fake. Other optional interfaces, like Discoverer, are
+// found by Unwrap. The results of Scan are not recorded.
+type FakeStore struct {
+	store jstore.Store
+
+	mutex  sync.Mutex
+	rules  []*Rule
+	calls  []Call
+	replay []Call
+	sleep  func(time.Duration)
+}
+
+// NewFakeStore creates a FakeStore delegating to the store.
+func NewFakeStore(store jstore.Store) *FakeStore {
+	return &FakeStore{
+		store: store,
+		sleep: time.Sleep,
+	}
+}
+
+// NewReplayStore creates a FakeStore, which answers the calls with
+// the results of the recorded calls. The calls have to be made in
+// the recorded order with the same arguments.
+func NewReplayStore(calls []Call) *FakeStore {
+	return &FakeStore{
+		replay: append([]Call{}, calls...),
+		sleep:  time.Sleep,
+	}
+}
+
+// On adds a new rule for the operation. Rules are evaluated in the
+// order they were added.
+func (fake *FakeStore) On(operation string) *Rule {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	rule := &Rule{operation: operation}
+	fake.rules = append(fake.rules, rule)
+	return rule
+}
+
+// Calls returns all recorded calls.
+func (fake *FakeStore) Calls() []Call {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	return append([]Call{}, fake.calls...)
+}
+
+// CallsTo returns the recorded calls of the operation.
+func (fake *FakeStore) CallsTo(operation string) []Call {
+	result := []Call{}
+	for _, call := range fake.Calls() {
+		if call.Operation == operation {
+			result = append(result, call)
+		}
+	}
+	return result
+}
+
+// Reset removes all rules and recorded calls.
+func (fake *FakeStore) Reset() {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	fake.rules = nil
+	fake.calls = nil
+}
+
+func (fake *FakeStore) Delete(id jstore.EntityID) error {
+	call := fake.execute(Call{Operation: OpDelete, ID: id, Project: id.Project, DocumentType: id.DocumentType},
+		func(call *Call) {
+			call.Err = fake.store.Delete(id)
+		})
+	return call.Err
+}
+
+func (fake *FakeStore) Save(id jstore.EntityID, json string) (jstore.EntityID, error) {
+	call := fake.execute(Call{Operation: OpSave, ID: id, Project: id.Project, DocumentType: id.DocumentType, JSON: json},
+		func(call *Call) {
+			call.ResultID, call.Err = fake.store.Save(id, json)
+		})
+	return call.ResultID, call.Err
+}
+
+func (fake *FakeStore) Insert(id jstore.EntityID, json string) (jstore.EntityID, error) {
+	call := fake.execute(Call{Operation: OpInsert, ID: id, Project: id.Project, DocumentType: id.DocumentType, JSON: json},
+		func(call *Call) {
+			call.ResultID, call.Err = jstore.Insert(fake.store, id, json)
+		})
+	return call.ResultID, call.Err
+}
+
+func (fake *FakeStore) SaveRaw(id jstore.EntityID, raw json.RawMessage) (jstore.EntityID, error) {
+	call := fake.execute(Call{Operation: OpSaveRaw, ID: id, Project: id.Project, DocumentType: id.DocumentType, JSON: string(raw)},
+		func(call *Call) {
+			call.ResultID, call.Err = jstore.SaveRaw(fake.store, id, raw)
+		})
+	return call.ResultID, call.Err
+}
+
+func (fake *FakeStore) Scan(project, documentType string, options ...jstore.Option) jstore.Iterator {
+	var it jstore.Iterator
+	call := fake.execute(Call{Operation: OpScan, Project: project, DocumentType: documentType, Options: options},
+		func(call *Call) {
+			it = jstore.Scan(fake.store, project, documentType, options...)
+		})
+	switch {
+	case call.Err != nil:
+		return jstore.ErrorIterator(call.Err)
+	case it == nil:
+		return jstore.NewSliceIterator(call.Results)
+	}
+	return it
+}
+
+// Unwrap returns the wrapped store, or nil for a replay store.
+func (fake *FakeStore) Unwrap() jstore.Store {
+	return fake.store
+}
+
+func (fake *FakeStore) Get(id jstore.EntityID) (jstore.Entity, error) {
+	call := fake.execute(Call{Operation: OpGet, ID: id, Project: id.Project, DocumentType: id.DocumentType},
+		func(call *Call) {
+			entity, err := fake.store.Get(id)
+			call.Results, call.Err = []jstore.Entity{entity}, err
+		})
+	return first(call.Results), call.Err
+}
+
+func (fake *FakeStore) Find(project, documentType string, options ...jstore.Option) (jstore.Entity, error) {
+	call := fake.execute(Call{Operation: OpFind, Project: project, DocumentType: documentType, Options: options},
+		func(call *Call) {
+			entity, err := fake.store.Find(project, documentType, options...)
+			call.Results, call.Err = []jstore.Entity{entity}, err
+		})
+	return first(call.Results), call.Err
+}
+
+func (fake *FakeStore) FindN(project, documentType string, maxResults int, options ...jstore.Option) ([]jstore.Entity, error) {
+	call := fake.execute(Call{Operation: OpFindN, Project: project, DocumentType: documentType, MaxResults: maxResults, Options: options},
+		func(call *Call) {
+			call.Results, call.Err = fake.store.FindN(project, documentType, maxResults, options...)
+		})
+	return call.Results, call.Err
+}
+
+func (fake *FakeStore) HealthCheck() error {
+	call := fake.execute(Call{Operation: OpHealthCheck},
+		func(call *Call) {
+			call.Err = fake.store.HealthCheck()
+		})
+	return call.Err
+}
+
+func (fake *FakeStore) execute(call Call, delegate func(call *Call)) Call {
+	fake.mutex.Lock()
+	var rule *Rule
+	for _, r := range fake.rules {
+		if r.matches(call) {
+			rule = r
+			break
+		}
+	}
+	fake.mutex.Unlock()
+
+	if rule != nil && rule.delay > 0 {
+		fake.sleep(rule.delay)
+	}
+
+	switch {
+	case rule != nil && rule.err != nil:
+		call.Err = rule.err
+	case fake.store == nil:
+		fake.replayCall(&call)
+	default:
+		delegate(&call)
+	}
+
+	fake.mutex.Lock()
+	fake.calls = append(fake.calls, call)
+	fake.mutex.Unlock()
+
+	return call
+}
+
+func (fake *FakeStore) replayCall(call *Call) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	if len(fake.replay) == 0 {
+		call.Err = fmt.Errorf("replay: unexpected call %s, no more calls recorded", call.Operation)
+		return
+	}
+
+	recorded := fake.replay[0]
+	if !sameArguments(recorded, *call) {
+		call.Err = fmt.Errorf("replay: unexpected call %s %+v, expected %s %+v", call.Operation, arguments(*call), recorded.Operation, arguments(recorded))
+		return
+	}
+	fake.replay = fake.replay[1:]
+
+	call.ResultID = recorded.ResultID
+	call.Results = recorded.Results
+	call.Err = recorded.Err
+}
+
+func sameArguments(a, b Call) bool {
+	return reflect.DeepEqual(arguments(a), arguments(b))
+}
+
+func arguments(call Call) Call {
+	return Call{
+		Operation:    call.Operation,
+		ID:           call.ID,
+		Project:      call.Project,
+		DocumentType: call.DocumentType,
+		JSON:         call.JSON,
+		MaxResults:   call.MaxResults,
+		Options:      call.Options,
+	}
+}
+
+func first(entities []jstore.Entity) jstore.Entity {
+	if len(entities) == 0 {
+		return jstore.Entity{}
+	}
+	return entities[0]
+}
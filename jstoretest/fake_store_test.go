@@ -0,0 +1,148 @@
+package jstoretest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/snabble/go-jstore/v2"
+	"github.com/snabble/go-jstore/v2/jstoretest"
+	"github.com/snabble/go-jstore/v2/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFakeStore(t *testing.T) *jstoretest.FakeStore {
+	store, err := memory.NewMemoryStore("")
+	require.NoError(t, err)
+	return jstoretest.NewFakeStore(store)
+}
+
+func Test_FakeStore_FailsNthCall(t *testing.T) {
+	fake := newFakeStore(t)
+	fake.On(jstoretest.OpSave).ForDocumentType("person").OnCall(3).Fail(jstore.OptimisticLockingError)
+
+	for i, expected := range []error{nil, nil, jstore.OptimisticLockingError, nil} {
+		_, err := fake.Save(jstore.NewID("project", "person", "ford"), `{}`)
+		assert.Equal(t, expected, err, "call %d", i+1)
+	}
+
+	_, err := fake.Save(jstore.NewID("project", "spaceship", "heartOfGold"), `{}`)
+	assert.NoError(t, err)
+}
+
+func Test_FakeStore_NotFoundAndTimes(t *testing.T) {
+	fake := newFakeStore(t)
+	_, err := fake.Save(jstore.NewID("project", "person", "ford"), `{}`)
+	require.NoError(t, err)
+
+	fake.On(jstoretest.OpGet).ForID("ford").Times(2).NotFound()
+
+	for i, expected := range []error{jstore.NotFound, jstore.NotFound, nil} {
+		_, err := fake.Get(jstore.NewID("project", "person", "ford"))
+		assert.Equal(t, expected, err, "call %d", i+1)
+	}
+}
+
+func Test_FakeStore_Delay(t *testing.T) {
+	fake := newFakeStore(t)
+	fake.On(jstoretest.OpHealthCheck).Delay(20 * time.Millisecond)
+
+	start := time.Now()
+	require.NoError(t, fake.HealthCheck())
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+}
+
+func Test_FakeStore_RecordsCalls(t *testing.T) {
+	fake := newFakeStore(t)
+
+	id, err := fake.Save(jstore.NewID("project", "person", "ford"), `{"name":"ford"}`)
+	require.NoError(t, err)
+	_, err = fake.FindN("project", "person", 10, jstore.Eq("name", "ford"))
+	require.NoError(t, err)
+
+	calls := fake.Calls()
+	require.Equal(t, 2, len(calls))
+	assert.Equal(t, jstoretest.OpSave, calls[0].Operation)
+	assert.Equal(t, `{"name":"ford"}`, calls[0].JSON)
+	assert.Equal(t, id, calls[0].ResultID)
+	assert.Equal(t, 10, calls[1].MaxResults)
+	assert.Equal(t, []jstore.Option{jstore.Eq("name", "ford")}, calls[1].Options)
+	assert.Equal(t, 1, len(calls[1].Results))
+
+	assert.Equal(t, 1, len(fake.CallsTo(jstoretest.OpFindN)))
+
+	fake.Reset()
+	assert.Empty(t, fake.Calls())
+}
+
+func Test_FakeStore_Replay(t *testing.T) {
+	recorder := newFakeStore(t)
+	_, err := recorder.Save(jstore.NewID("project", "person", "ford"), `{"name":"ford"}`)
+	require.NoError(t, err)
+	expected, err := recorder.Get(jstore.NewID("project", "person", "ford"))
+	require.NoError(t, err)
+	_, err = recorder.Get(jstore.NewID("project", "person", "zaphod"))
+	require.Equal(t, jstore.NotFound, err)
+
+	replay := jstoretest.NewReplayStore(recorder.Calls())
+
+	_, err = replay.Save(jstore.NewID("project", "person", "ford"), `{"name":"ford"}`)
+	require.NoError(t, err)
+	entity, err := replay.Get(jstore.NewID("project", "person", "ford"))
+	require.NoError(t, err)
+	assert.Equal(t, expected, entity)
+
+	// arguments differ from the recording
+	_, err = replay.Get(jstore.NewID("project", "person", "marvin"))
+	assert.Error(t, err)
+
+	_, err = replay.Get(jstore.NewID("project", "person", "zaphod"))
+	assert.Equal(t, jstore.NotFound, err)
+
+	// recording exhausted
+	_, err = replay.Get(jstore.NewID("project", "person", "zaphod"))
+	assert.Error(t, err)
+}
+
+func Test_FakeStore_RuleWithoutEffect(t *testing.T) {
+	fake := newFakeStore(t)
+	fake.On(jstoretest.OpSave).OnCall(1)
+	fake.On(jstoretest.OpSave).OnCall(2).Fail(jstore.OptimisticLockingError)
+
+	for i, expected := range []error{nil, jstore.OptimisticLockingError, nil} {
+		_, err := fake.Save(jstore.NewID("project", "person", "ford"), `{}`)
+		assert.Equal(t, expected, err, "call %d", i+1)
+	}
+}
+
+func Test_FakeStore_OptionalInterfaces(t *testing.T) {
+	fake := newFakeStore(t)
+	fake.On(jstoretest.OpScan).Times(1).Fail(jstore.NotSupported)
+
+	_, err := jstore.Insert(fake, jstore.NewID("project", "person", "ford"), `{"name":"ford"}`)
+	require.NoError(t, err)
+	_, err = jstore.Insert(fake, jstore.NewID("project", "person", "ford"), `{"name":"ford"}`)
+	assert.Equal(t, jstore.AlreadyExists, err)
+	_, err = jstore.SaveRaw(fake, jstore.NewID("project", "person", "zaphod"), []byte(`{"name":"zaphod"}`))
+	require.NoError(t, err)
+
+	it := jstore.Scan(fake, "project", "person")
+	assert.False(t, it.Next())
+	assert.Equal(t, jstore.NotSupported, it.Err())
+
+	it = jstore.Scan(fake, "project", "person")
+	defer it.Close()
+	count := 0
+	for it.Next() {
+		count++
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, 2, count)
+
+	assert.Equal(t, 2, len(fake.CallsTo(jstoretest.OpInsert)))
+	assert.Equal(t, 1, len(fake.CallsTo(jstoretest.OpSaveRaw)))
+	assert.Equal(t, 2, len(fake.CallsTo(jstoretest.OpScan)))
+
+	_, ok := jstore.AsDiscoverer(fake)
+	assert.True(t, ok)
+}
@@ -0,0 +1,283 @@
+package memory
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/snabble/go-jstore/v2"
+)
+
+type MemoryStoreOption func(store *MemoryStore) error
+
+// HashIndex indexes the property of the matching document types for
+// lookups with Eq. The documentType is a pattern in the syntax of
+// path.Match, e.g. "*" indexes the property in all document types.
+func HashIndex(documentType, property string) MemoryStoreOption {
+	return addIndex(indexDefinition{documentType, property, false})
+}
+
+// SortedIndex indexes the property of the matching document types for
+// range queries with Gt, Lt, etc. and for SortBy. The documentType is
+// a pattern in the syntax of path.Match.
+func SortedIndex(documentType, property string) MemoryStoreOption {
+	return addIndex(indexDefinition{documentType, property, true})
+}
+
+func addIndex(definition indexDefinition) MemoryStoreOption {
+	return func(store *MemoryStore) error {
+		if _, err := path.Match(definition.documentType, ""); err != nil {
+			return fmt.Errorf("invalid document type pattern %q: %w", definition.documentType, err)
+		}
+		store.indexes = append(store.indexes, definition)
+		return nil
+	}
+}
+
+type indexDefinition struct {
+	documentType string
+	property     string
+	sorted       bool
+}
+
+func (definition indexDefinition) appliesTo(documentType string) bool {
+	matches, _ := path.Match(definition.documentType, documentType)
+	return matches
+}
+
+// hashIndex maps the values of a property to the ids of the documents.
+type hashIndex struct {
+	ids map[string]map[string]struct{}
+}
+
+func newHashIndex() *hashIndex {
+	return &hashIndex{ids: map[string]map[string]struct{}{}}
+}
+
+func (index *hashIndex) add(id string, value interface{}) {
+	for _, key := range hashKeys(value) {
+		if _, ok := index.ids[key]; !ok {
+			index.ids[key] = map[string]struct{}{}
+		}
+		index.ids[key][id] = struct{}{}
+	}
+}
+
+func (index *hashIndex) remove(id string, value interface{}) {
+	for _, key := range hashKeys(value) {
+		delete(index.ids[key], id)
+		if len(index.ids[key]) == 0 {
+			delete(index.ids, key)
+		}
+	}
+}
+
+// lookup returns the ids of the documents with the value. It returns
+// false, if values of this type are not indexed.
+func (index *hashIndex) lookup(value interface{}) (map[string]struct{}, bool) {
+	keys := hashKeys(value)
+	switch len(keys) {
+	case 0:
+		return nil, false
+	case 1:
+		return index.ids[keys[0]], true
+	}
+	ids := map[string]struct{}{}
+	for _, key := range keys {
+		for id := range index.ids[key] {
+			ids[id] = struct{}{}
+		}
+	}
+	return ids, true
+}
+
+// hashKeys returns the keys, which are equal for values matched by Eq.
+// Strings containing numbers also get the key of the number, because
+// they match numbers.
+func hashKeys(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			return []string{"s" + value, numberKey(number)}
+		}
+		return []string{"s" + value}
+	case bool:
+		return []string{"b" + strconv.FormatBool(value)}
+	case float64:
+		return []string{numberKey(value)}
+	case int:
+		return []string{numberKey(float64(value))}
+	case int64:
+		return []string{numberKey(float64(value))}
+	default:
+		return nil
+	}
+}
+
+func numberKey(value float64) string {
+	if value == 0 {
+		// -0 == 0
+		value = 0
+	}
+	return "n" + strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+type sortedEntry struct {
+	number float64
+	text   string
+	time   time.Time
+	id     string
+}
+
+// sortedList keeps entries ordered by value and id.
+type sortedList struct {
+	entries []sortedEntry
+	less    func(a, b sortedEntry) bool
+}
+
+func (list *sortedList) search(entry sortedEntry) int {
+	return sort.Search(len(list.entries), func(i int) bool {
+		other := list.entries[i]
+		if list.less(other, entry) {
+			return false
+		}
+		return list.less(entry, other) || other.id >= entry.id
+	})
+}
+
+func (list *sortedList) insert(entry sortedEntry) {
+	i := list.search(entry)
+	list.entries = append(list.entries, sortedEntry{})
+	copy(list.entries[i+1:], list.entries[i:])
+	list.entries[i] = entry
+}
+
+func (list *sortedList) remove(entry sortedEntry) {
+	i := list.search(entry)
+	if i < len(list.entries) && list.entries[i].id == entry.id {
+		list.entries = append(list.entries[:i], list.entries[i+1:]...)
+	}
+}
+
+// between returns the entries matching the comparison with bound.
+func (list *sortedList) between(operation string, bound sortedEntry) ([]sortedEntry, bool) {
+	// first entry not less than bound
+	from := sort.Search(len(list.entries), func(i int) bool { return !list.less(list.entries[i], bound) })
+	// first entry greater than bound
+	to := sort.Search(len(list.entries), func(i int) bool { return list.less(bound, list.entries[i]) })
+
+	switch operation {
+	case "=":
+		return list.entries[from:to], true
+	case "<":
+		return list.entries[:from], true
+	case "<=":
+		return list.entries[:to], true
+	case ">":
+		return list.entries[to:], true
+	case ">=":
+		return list.entries[from:], true
+	default:
+		return nil, false
+	}
+}
+
+// sortedIndex keeps the documents ordered by a property. Numbers,
+// strings and strings, which are timestamps, are kept in separate
+// lists. Strings containing numbers are in the list of numbers, too.
+type sortedIndex struct {
+	numbers sortedList
+	texts   sortedList
+	times   sortedList
+}
+
+func newSortedIndex() *sortedIndex {
+	return &sortedIndex{
+		numbers: sortedList{less: func(a, b sortedEntry) bool { return a.number < b.number }},
+		texts:   sortedList{less: func(a, b sortedEntry) bool { return a.text < b.text }},
+		times:   sortedList{less: func(a, b sortedEntry) bool { return a.time.Before(b.time) }},
+	}
+}
+
+func (index *sortedIndex) add(id string, value interface{}) {
+	switch value := value.(type) {
+	case float64:
+		index.numbers.insert(sortedEntry{number: value, id: id})
+	case string:
+		index.texts.insert(sortedEntry{text: value, id: id})
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			index.numbers.insert(sortedEntry{number: number, id: id})
+		}
+		if t, err := parseTime(value); err == nil {
+			index.times.insert(sortedEntry{time: t, id: id})
+		}
+	}
+}
+
+func (index *sortedIndex) remove(id string, value interface{}) {
+	switch value := value.(type) {
+	case float64:
+		index.numbers.remove(sortedEntry{number: value, id: id})
+	case string:
+		index.texts.remove(sortedEntry{text: value, id: id})
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			index.numbers.remove(sortedEntry{number: number, id: id})
+		}
+		if t, err := parseTime(value); err == nil {
+			index.times.remove(sortedEntry{time: t, id: id})
+		}
+	}
+}
+
+// lookup returns the ids of the documents matching the comparison. It
+// returns false, if the comparison is not supported by the index.
+func (index *sortedIndex) lookup(option jstore.CompareOption) ([]sortedEntry, bool) {
+	switch value := option.Value.(type) {
+	case int:
+		return index.numbers.between(option.Operation, sortedEntry{number: float64(value)})
+	case int64:
+		return index.numbers.between(option.Operation, sortedEntry{number: float64(value)})
+	case float64:
+		return index.numbers.between(option.Operation, sortedEntry{number: value})
+	case time.Time:
+		return index.times.between(option.Operation, sortedEntry{time: value})
+	case string:
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			// matches numbers, too
+			return nil, false
+		}
+		return index.texts.between(option.Operation, sortedEntry{text: value})
+	default:
+		return nil, false
+	}
+}
+
+// ordered returns the list used to sort by the option, if all items
+// have a value of the same indexed type.
+func (index *sortedIndex) ordered(items []storageItem, option jstore.ModifiedSortOption) (*sortedList, bool) {
+	var list *sortedList
+	for _, item := range items {
+		var itemList *sortedList
+		switch value := item.object[option.Property].(type) {
+		case float64:
+			itemList = &index.numbers
+		case string:
+			itemList = &index.texts
+			if option.AsTime {
+				if _, err := parseTime(value); err != nil {
+					return nil, false
+				}
+				itemList = &index.times
+			}
+		default:
+			return nil, false
+		}
+		if list != nil && itemList != list {
+			return nil, false
+		}
+		list = itemList
+	}
+	return list, list != nil
+}
@@ -0,0 +1,373 @@
+package memory
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/snabble/go-jstore/v2"
+)
+
+// LimitExceeded is returned by writes, which would exceed a limit of
+// the store with the RejectWrites policy, or which exceed a limit on
+// their own.
+var LimitExceeded = errors.New("memory store limit exceeded")
+
+// Policy decides, how the store keeps within its limits.
+type Policy int
+
+const (
+	// EvictLeastRecentlyUsed evicts the documents, which were not read
+	// or written for the longest time. This is the default.
+	EvictLeastRecentlyUsed Policy = iota
+
+	// EvictOldest evicts the documents, which were inserted first.
+	EvictOldest
+
+	// RejectWrites fails writes exceeding a limit with LimitExceeded.
+	RejectWrites
+)
+
+// MaxDocuments limits the number of documents in the store.
+func MaxDocuments(n int) MemoryStoreOption {
+	return addLimit(limitDefinition{scope: scopeStore, maxDocuments: n})
+}
+
+// MaxBytes limits the total size of the JSON of the documents in the
+// store.
+func MaxBytes(n int64) MemoryStoreOption {
+	return addLimit(limitDefinition{scope: scopeStore, maxBytes: n})
+}
+
+// ProjectLimits limits the documents of every project matching the
+// pattern in the syntax of path.Match. Zero means no limit.
+func ProjectLimits(project string, maxDocuments int, maxBytes int64) MemoryStoreOption {
+	return addLimit(limitDefinition{scope: scopeProject, project: project, maxDocuments: maxDocuments, maxBytes: maxBytes})
+}
+
+// DocumentTypeLimits limits the documents of every document type
+// matching the patterns in the syntax of path.Match. Zero means no
+// limit.
+func DocumentTypeLimits(project, documentType string, maxDocuments int, maxBytes int64) MemoryStoreOption {
+	return addLimit(limitDefinition{scope: scopeDocumentType, project: project, documentType: documentType, maxDocuments: maxDocuments, maxBytes: maxBytes})
+}
+
+// Eviction sets the policy applied, when a write exceeds a limit.
+func Eviction(policy Policy) MemoryStoreOption {
+	return func(store *MemoryStore) error {
+		if policy < EvictLeastRecentlyUsed || policy > RejectWrites {
+			return fmt.Errorf("invalid eviction policy %d", policy)
+		}
+		store.policy = policy
+		return nil
+	}
+}
+
+// OnEvict registers a function, which is called with the id and the
+// size of every evicted document, e.g. to update metrics. It is called
+// after the write causing the eviction and may use the store.
+func OnEvict(fn func(id jstore.EntityID, size int)) MemoryStoreOption {
+	return func(store *MemoryStore) error {
+		store.onEvict = fn
+		return nil
+	}
+}
+
+func addLimit(definition limitDefinition) MemoryStoreOption {
+	return func(store *MemoryStore) error {
+		for _, pattern := range []string{definition.project, definition.documentType} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+		}
+		if definition.maxDocuments < 0 || definition.maxBytes < 0 {
+			return fmt.Errorf("invalid limit of %d documents and %d bytes", definition.maxDocuments, definition.maxBytes)
+		}
+		store.limits = append(store.limits, definition)
+		return nil
+	}
+}
+
+const (
+	scopeStore = iota
+	scopeProject
+	scopeDocumentType
+)
+
+type limitDefinition struct {
+	scope        int
+	project      string
+	documentType string
+	maxDocuments int
+	maxBytes     int64
+}
+
+// usageKey returns the key of the usage counted against the limit, if
+// the limit applies to the document type.
+func (definition limitDefinition) usageKey(project, documentType string) (string, bool) {
+	switch definition.scope {
+	case scopeStore:
+		return "", true
+	case scopeProject:
+		if matches, _ := path.Match(definition.project, project); !matches {
+			return "", false
+		}
+		return "p/" + project, true
+	default:
+		if matches, _ := path.Match(definition.project, project); !matches {
+			return "", false
+		}
+		if matches, _ := path.Match(definition.documentType, documentType); !matches {
+			return "", false
+		}
+		return "d/" + project + "/" + documentType, true
+	}
+}
+
+func (definition limitDefinition) exceeded(u usage) bool {
+	return (definition.maxDocuments > 0 && u.documents > definition.maxDocuments) ||
+		(definition.maxBytes > 0 && u.bytes > definition.maxBytes)
+}
+
+type usage struct {
+	documents int
+	bytes     int64
+}
+
+type itemKey struct {
+	project, documentType, id string
+}
+
+func keyOf(id jstore.EntityID) itemKey {
+	return itemKey{id.Project, id.DocumentType, id.ID}
+}
+
+type eviction struct {
+	id   jstore.EntityID
+	size int
+}
+
+// limiter counts the documents and bytes against the limits and keeps
+// the documents in the order of eviction.
+type limiter struct {
+	definitions []limitDefinition
+	policy      Policy
+	onEvict     func(id jstore.EntityID, size int)
+
+	// writes serializes the writes, so evicting documents of other
+	// collections can not deadlock.
+	writes    sync.Mutex
+	evictions []eviction
+
+	// mutex guards the usages and the order, which reads update, too.
+	mutex  sync.Mutex
+	usages map[string]usage
+	// the front is the most recently used or newest document
+	order    *list.List
+	elements map[itemKey]*list.Element
+}
+
+func newLimiter(definitions []limitDefinition, policy Policy, onEvict func(id jstore.EntityID, size int)) *limiter {
+	definitions = append([]limitDefinition{}, definitions...)
+	// make room in the narrowest scope first, which makes room in the
+	// wider scopes, too
+	sort.SliceStable(definitions, func(i, j int) bool { return definitions[i].scope > definitions[j].scope })
+	return &limiter{
+		definitions: definitions,
+		policy:      policy,
+		onEvict:     onEvict,
+		usages:      map[string]usage{},
+		order:       list.New(),
+		elements:    map[itemKey]*list.Element{},
+	}
+}
+
+func (l *limiter) usageKeys(project, documentType string) []string {
+	keys := []string{}
+	seen := map[string]bool{}
+	for _, definition := range l.definitions {
+		if key, ok := definition.usageKey(project, documentType); ok && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// saved counts the item, which replaces present, if replaced is set.
+func (l *limiter) saved(item, present storageItem, replaced bool) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	documents, bytes := 1, int64(len(item.entity.JSON))
+	if replaced {
+		documents, bytes = 0, bytes-int64(len(present.entity.JSON))
+	}
+	for _, key := range l.usageKeys(item.entity.Project, item.entity.DocumentType) {
+		u := l.usages[key]
+		l.usages[key] = usage{u.documents + documents, u.bytes + bytes}
+	}
+
+	key := keyOf(item.entity.EntityID)
+	if element, ok := l.elements[key]; ok {
+		if l.policy == EvictLeastRecentlyUsed {
+			l.order.MoveToFront(element)
+		}
+		return
+	}
+	l.elements[key] = l.order.PushFront(key)
+}
+
+func (l *limiter) removed(item storageItem) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, key := range l.usageKeys(item.entity.Project, item.entity.DocumentType) {
+		u := l.usages[key]
+		l.usages[key] = usage{u.documents - 1, u.bytes - int64(len(item.entity.JSON))}
+	}
+	key := keyOf(item.entity.EntityID)
+	if element, ok := l.elements[key]; ok {
+		l.order.Remove(element)
+		delete(l.elements, key)
+	}
+}
+
+// touch marks the read entities as recently used.
+func (l *limiter) touch(entities []jstore.Entity) {
+	if l == nil || l.policy != EvictLeastRecentlyUsed {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, entity := range entities {
+		if element, ok := l.elements[keyOf(entity.EntityID)]; ok {
+			l.order.MoveToFront(element)
+		}
+	}
+}
+
+// makeRoom evicts documents, until saving the item, which replaces
+// present, if replaced is set, keeps within all limits. It must be
+// called while holding writes and the lock of the collection c.
+func (l *limiter) makeRoom(store *MemoryStore, c *collection, item, present storageItem, replaced bool) error {
+	if l == nil {
+		return nil
+	}
+	project, documentType := item.entity.Project, item.entity.DocumentType
+	documents, bytes := 1, int64(len(item.entity.JSON))
+	if replaced {
+		documents, bytes = 0, bytes-int64(len(present.entity.JSON))
+	}
+
+	// reject the write before evicting anything. Otherwise evicting
+	// the other documents always makes enough room.
+	l.mutex.Lock()
+	for _, definition := range l.definitions {
+		key, ok := definition.usageKey(project, documentType)
+		if !ok {
+			continue
+		}
+		u := l.usages[key]
+		if (definition.maxBytes > 0 && int64(len(item.entity.JSON)) > definition.maxBytes) ||
+			(l.policy == RejectWrites && definition.exceeded(usage{u.documents + documents, u.bytes + bytes})) {
+			l.mutex.Unlock()
+			return LimitExceeded
+		}
+	}
+	l.mutex.Unlock()
+
+	for _, definition := range l.definitions {
+		key, ok := definition.usageKey(project, documentType)
+		if !ok {
+			continue
+		}
+		for {
+			l.mutex.Lock()
+			u := l.usages[key]
+			if !definition.exceeded(usage{u.documents + documents, u.bytes + bytes}) {
+				l.mutex.Unlock()
+				break
+			}
+			victim, found := l.victim(definition, key, keyOf(item.entity.EntityID))
+			l.mutex.Unlock()
+
+			if !found {
+				return LimitExceeded
+			}
+			if err := store.evict(c, victim); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// victim returns the next document to evict for the limit, except the
+// document being saved.
+func (l *limiter) victim(definition limitDefinition, usageKey string, except itemKey) (itemKey, bool) {
+	for element := l.order.Back(); element != nil; element = element.Prev() {
+		key := element.Value.(itemKey)
+		if key == except {
+			continue
+		}
+		if k, ok := definition.usageKey(key.project, key.documentType); ok && k == usageKey {
+			return key, true
+		}
+	}
+	return itemKey{}, false
+}
+
+// takeEvictions returns the evictions since the last call. It must be
+// called while holding writes.
+func (l *limiter) takeEvictions() []eviction {
+	evictions := l.evictions
+	l.evictions = nil
+	return evictions
+}
+
+func (l *limiter) notify(evictions []eviction) {
+	if l.onEvict == nil {
+		return
+	}
+	for _, e := range evictions {
+		l.onEvict(e.id, e.size)
+	}
+}
+
+// evict deletes the document to keep within the limits. The collection
+// locked is the one locked by the caller.
+func (store *MemoryStore) evict(locked *collection, key itemKey) error {
+	c := store.collection(key.project, key.documentType, false)
+	if c == nil {
+		return fmt.Errorf("evicting %s: missing collection", key.id)
+	}
+	if c != locked {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+	}
+
+	item, ok := c.items[key.id]
+	if !ok {
+		return fmt.Errorf("evicting %s: missing document", key.id)
+	}
+	if err := store.log(deleteRecord(item.entity.EntityID)); err != nil {
+		return err
+	}
+	c.remove(item)
+	c.modified = time.Now()
+	store.limiter.removed(item)
+	store.limiter.evictions = append(store.limiter.evictions, eviction{item.entity.EntityID, len(item.entity.JSON)})
+	return nil
+}
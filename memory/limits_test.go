@@ -0,0 +1,213 @@
+package memory
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/snabble/go-jstore/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type evictions struct {
+	mutex sync.Mutex
+	ids   []string
+	sizes []int
+}
+
+func (e *evictions) record(id jstore.EntityID, size int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.ids = append(e.ids, id.ID)
+	e.sizes = append(e.sizes, size)
+}
+
+func saveIDs(t *testing.T, store jstore.Store, documentType string, ids ...string) {
+	for _, id := range ids {
+		_, err := store.Save(jstore.NewID("project", documentType, id), `{"name":"`+id+`"}`)
+		require.NoError(t, err)
+	}
+}
+
+func storedIDs(t *testing.T, store jstore.Store, documentType string) []string {
+	entities, err := store.FindN("project", documentType, 100)
+	if err == jstore.NotFound {
+		return []string{}
+	}
+	require.NoError(t, err)
+	return ids(entities)
+}
+
+func Test_Limits_LeastRecentlyUsed(t *testing.T) {
+	evicted := &evictions{}
+	store, err := NewMemoryStore("", MaxDocuments(3), OnEvict(evicted.record))
+	require.NoError(t, err)
+
+	saveIDs(t, store, "person", "arthur", "ford", "marvin")
+	_, err = store.Get(jstore.NewID("project", "person", "arthur"))
+	require.NoError(t, err)
+	saveIDs(t, store, "person", "zaphod")
+
+	assert.Equal(t, []string{"arthur", "marvin", "zaphod"}, storedIDs(t, store, "person"))
+	assert.Equal(t, []string{"ford"}, evicted.ids)
+	assert.Equal(t, []int{len(`{"name":"ford"}`)}, evicted.sizes)
+
+	// updates count as use
+	saveIDs(t, store, "person", "arthur", "trillian")
+	assert.Equal(t, []string{"arthur", "trillian", "zaphod"}, storedIDs(t, store, "person"))
+}
+
+func Test_Limits_Oldest(t *testing.T) {
+	evicted := &evictions{}
+	store, err := NewMemoryStore("", MaxDocuments(3), Eviction(EvictOldest), OnEvict(evicted.record))
+	require.NoError(t, err)
+
+	saveIDs(t, store, "person", "arthur", "ford", "marvin")
+	_, err = store.Get(jstore.NewID("project", "person", "arthur"))
+	require.NoError(t, err)
+	saveIDs(t, store, "person", "arthur", "zaphod", "trillian")
+
+	assert.Equal(t, []string{"marvin", "trillian", "zaphod"}, storedIDs(t, store, "person"))
+	assert.Equal(t, []string{"arthur", "ford"}, evicted.ids)
+}
+
+func Test_Limits_RejectWrites(t *testing.T) {
+	store, err := NewMemoryStore("", MaxBytes(30), Eviction(RejectWrites))
+	require.NoError(t, err)
+
+	_, err = store.Save(jstore.NewID("project", "person", "ford"), `{"name":"ford"}`)
+	require.NoError(t, err)
+	_, err = store.Save(jstore.NewID("project", "person", "marvin"), `{"name":"marvin"}`)
+	assert.Equal(t, LimitExceeded, err)
+	_, err = store.Save(jstore.NewID("project", "person", "ford"), `{"name":"ford prefect"}`)
+	require.NoError(t, err)
+
+	// deletes make room
+	require.NoError(t, store.Delete(jstore.NewID("project", "person", "ford")))
+	_, err = store.Save(jstore.NewID("project", "person", "marvin"), `{"name":"marvin"}`)
+	require.NoError(t, err)
+}
+
+func Test_Limits_DocumentTooLarge(t *testing.T) {
+	evicted := &evictions{}
+	store, err := NewMemoryStore("", MaxBytes(20), OnEvict(evicted.record))
+	require.NoError(t, err)
+
+	saveIDs(t, store, "person", "ford")
+	_, err = store.Save(jstore.NewID("project", "person", "marvin"), `{"name":"marvin the paranoid android"}`)
+	assert.Equal(t, LimitExceeded, err)
+	assert.Equal(t, []string{"ford"}, storedIDs(t, store, "person"))
+	assert.Empty(t, evicted.ids)
+}
+
+func Test_Limits_DocumentTooLargeForWiderLimit(t *testing.T) {
+	evicted := &evictions{}
+	store, err := NewMemoryStore("",
+		MaxBytes(30),
+		DocumentTypeLimits("*", "person", 1, 0),
+		OnEvict(evicted.record),
+	)
+	require.NoError(t, err)
+
+	// the narrow limit must not evict ford for a write, which the store
+	// limit rejects
+	saveIDs(t, store, "person", "ford")
+	_, err = store.Save(jstore.NewID("project", "person", "marvin"), `{"name":"marvin the paranoid android"}`)
+	assert.Equal(t, LimitExceeded, err)
+	assert.Equal(t, []string{"ford"}, storedIDs(t, store, "person"))
+	assert.Empty(t, evicted.ids)
+}
+
+func Test_Limits_Scopes(t *testing.T) {
+	store, err := NewMemoryStore("",
+		DocumentTypeLimits("*", "cache*", 2, 0),
+		ProjectLimits("limited", 3, 0),
+	)
+	require.NoError(t, err)
+
+	saveIDs(t, store, "cache", "1", "2", "3")
+	saveIDs(t, store, "cacheToo", "1", "2", "3")
+	saveIDs(t, store, "person", "1", "2", "3")
+	assert.Equal(t, []string{"2", "3"}, storedIDs(t, store, "cache"))
+	assert.Equal(t, []string{"2", "3"}, storedIDs(t, store, "cacheToo"))
+	assert.Equal(t, []string{"1", "2", "3"}, storedIDs(t, store, "person"))
+
+	for i, documentType := range []string{"person", "robot", "person", "robot"} {
+		_, err := store.Save(jstore.NewID("limited", documentType, strconv.Itoa(i)), `{}`)
+		require.NoError(t, err)
+	}
+	stats, err := store.(*MemoryStore).Stats("limited", "person")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Count)
+	stats, err = store.(*MemoryStore).Stats("limited", "robot")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.Count)
+}
+
+func Test_Limits_CallbackUsesStore(t *testing.T) {
+	var store jstore.Store
+	store, err := NewMemoryStore("", DocumentTypeLimits("project", "person", 1, 0), OnEvict(func(id jstore.EntityID, size int) {
+		_, err := store.Save(jstore.NewID("project", "eviction", id.ID), `{}`)
+		assert.NoError(t, err)
+	}))
+	require.NoError(t, err)
+
+	saveIDs(t, store, "person", "ford", "marvin")
+	assert.Equal(t, []string{"ford"}, storedIDs(t, store, "eviction"))
+}
+
+func Test_Limits_Durable(t *testing.T) {
+	dir := t.TempDir()
+	store := openDurable(t, dir, "", MaxDocuments(2))
+	saveIDs(t, store, "person", "arthur", "ford", "marvin")
+	require.NoError(t, store.Close())
+
+	store = openDurable(t, dir, "", MaxDocuments(2))
+	defer store.Close()
+	assert.Equal(t, []string{"ford", "marvin"}, storedIDs(t, store, "person"))
+
+	saveIDs(t, store, "person", "zaphod")
+	assert.Equal(t, []string{"marvin", "zaphod"}, storedIDs(t, store, "person"))
+}
+
+func Test_Limits_Concurrent(t *testing.T) {
+	evicted := &evictions{}
+	store, err := NewMemoryStore("", MaxDocuments(10), OnEvict(evicted.record))
+	require.NoError(t, err)
+
+	wg := sync.WaitGroup{}
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			documentType := "type" + strconv.Itoa(worker%4)
+			for i := 0; i < 50; i++ {
+				id := jstore.NewID("project", documentType, strconv.Itoa(worker*100+i))
+				if _, err := store.Save(id, `{}`); err != nil {
+					t.Error(err)
+				}
+				store.FindN("project", documentType, 5)
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	count := 0
+	for worker := 0; worker < 4; worker++ {
+		count += len(storedIDs(t, store, "type"+strconv.Itoa(worker)))
+	}
+	assert.Equal(t, 10, count)
+	assert.Equal(t, 390, len(evicted.ids))
+}
+
+func Test_Limits_InvalidOptions(t *testing.T) {
+	for _, option := range []MemoryStoreOption{
+		MaxDocuments(-1),
+		ProjectLimits("[", 1, 0),
+		Eviction(Policy(42)),
+	} {
+		_, err := NewMemoryStore("", option)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,168 @@
+package memory
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/snabble/go-jstore/v2"
+)
+
+// StrictMatching makes queries fail with an error, if a document lacks
+// the compared property or has a value of another type. By default,
+// such documents do not match, like in Elasticsearch.
+func StrictMatching() MemoryStoreOption {
+	return func(store *MemoryStore) error {
+		store.strict = true
+		return nil
+	}
+}
+
+// matches returns true, if the item matches all options.
+func (item *storageItem) matches(strict bool, options ...jstore.Option) (bool, error) {
+	result := true
+	for _, option := range options {
+		switch option := option.(type) {
+		case jstore.SortOption, jstore.ModifiedSortOption:
+			continue
+		case jstore.IdOption:
+			result = result && (item.entity.ID == option.Value)
+		case jstore.CompareOption:
+			value, present := item.object[option.Property]
+			matches, err := compare(value, present, option, strict)
+			if err != nil {
+				return false, err
+			}
+			result = result && matches
+		default:
+			return false, fmt.Errorf("unsupported option: %+v", option)
+		}
+	}
+	return result, nil
+}
+
+// compare matches the value of a document against the option. Eq with
+// nil matches missing and null values.
+func compare(value interface{}, present bool, option jstore.CompareOption, strict bool) (bool, error) {
+	switch option.Operation {
+	case "=", "<", "<=", ">", ">=":
+	default:
+		return false, fmt.Errorf("unsupported compare option: %s", option.Operation)
+	}
+
+	if option.Value == nil {
+		if option.Operation != "=" {
+			return false, fmt.Errorf("unsupported compare option for null: %s", option.Operation)
+		}
+		return value == nil, nil
+	}
+
+	if !present || value == nil {
+		if strict {
+			return false, fmt.Errorf("missing property %q", option.Property)
+		}
+		return false, nil
+	}
+
+	c, ok, err := compareValues(value, option.Value)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		if strict {
+			return false, fmt.Errorf("property %q: cannot compare %T with %T", option.Property, value, option.Value)
+		}
+		return false, nil
+	}
+
+	switch option.Operation {
+	case "=":
+		return c == 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	default:
+		return c >= 0, nil
+	}
+}
+
+// compareValues compares the value of a document with the value of a
+// query. It returns false, if the values have incompatible types.
+// Numbers and strings containing numbers are compared as numbers, like
+// Elasticsearch does for numeric fields.
+func compareValues(value, queryValue interface{}) (int, bool, error) {
+	switch queryValue := queryValue.(type) {
+	case bool:
+		b, ok := value.(bool)
+		if !ok {
+			return 0, false, nil
+		}
+		return compareOrdered(boolToInt(b), boolToInt(queryValue)), true, nil
+
+	case string:
+		switch value := value.(type) {
+		case string:
+			return compareOrdered(value, queryValue), true, nil
+		case float64:
+			number, err := strconv.ParseFloat(queryValue, 64)
+			if err != nil {
+				return 0, false, nil
+			}
+			return compareOrdered(value, number), true, nil
+		default:
+			return 0, false, nil
+		}
+
+	case int:
+		return compareNumber(value, float64(queryValue))
+	case int64:
+		return compareNumber(value, float64(queryValue))
+	case float64:
+		return compareNumber(value, queryValue)
+
+	case time.Time:
+		s, ok := value.(string)
+		if !ok {
+			return 0, false, nil
+		}
+		t, err := parseTime(s)
+		if err != nil {
+			return 0, false, nil
+		}
+		return compareTimes(t, queryValue), true, nil
+
+	default:
+		return 0, false, fmt.Errorf("unsupported type for comparison: %T", queryValue)
+	}
+}
+
+func compareNumber(value interface{}, queryValue float64) (int, bool, error) {
+	switch value := value.(type) {
+	case float64:
+		return compareOrdered(value, queryValue), true, nil
+	case string:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, false, nil
+		}
+		return compareOrdered(number, queryValue), true, nil
+	default:
+		return 0, false, nil
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// parseTime parses timestamps in RFC 3339 format with optional
+// fractional seconds.
+func parseTime(value string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, value)
+}
@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/snabble/go-jstore/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Matching(t *testing.T) {
+	for name, options := range map[string][]jstore.StoreOption{
+		"scan":   {},
+		"hash":   {HashIndex("*", "age"), HashIndex("*", "active"), HashIndex("*", "name")},
+		"sorted": {SortedIndex("*", "age"), SortedIndex("*", "name"), SortedIndex("*", "born")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			store, err := NewMemoryStore("", options...)
+			require.NoError(t, err)
+			for id, document := range map[string]string{
+				"ford":   `{"name":"ford","age":42,"active":true,"born":"1980-01-01T00:00:00.123456789Z"}`,
+				"marvin": `{"name":"marvin","age":"1010","active":false,"born":"2042-01-01T02:00:00+02:00"}`,
+				"zaphod": `{"name":42,"age":"old","active":null,"born":42}`,
+			} {
+				_, err := store.Save(jstore.NewID("project", "person", id), document)
+				require.NoError(t, err)
+			}
+
+			for _, test := range []struct {
+				name     string
+				option   jstore.Option
+				expected []string
+			}{
+				{"number", jstore.Eq("age", 42), []string{"ford"}},
+				{"numeric string", jstore.Eq("age", "42"), []string{"ford"}},
+				{"number on numeric string", jstore.Gt("age", 100), []string{"marvin"}},
+				{"string", jstore.Eq("age", "old"), []string{"zaphod"}},
+				{"bool", jstore.Eq("active", true), []string{"ford"}},
+				{"false", jstore.Eq("active", false), []string{"marvin"}},
+				{"null", jstore.Eq("active", nil), []string{"zaphod"}},
+				{"missing as null", jstore.Eq("unknown", nil), []string{"ford", "marvin", "zaphod"}},
+				{"string range", jstore.Gte("name", "m"), []string{"marvin"}},
+				{"string range on numbers", jstore.Lt("name", "50"), []string{"zaphod"}},
+				{"time with nanoseconds", jstore.Lt("born", time.Date(1980, 1, 1, 0, 0, 0, 123456790, time.UTC)), []string{"ford"}},
+				{"time with offset", jstore.Eq("born", time.Date(2042, 1, 1, 0, 0, 0, 0, time.UTC)), []string{"marvin"}},
+				{"missing", jstore.Gt("unknown", 1), []string{}},
+			} {
+				t.Run(test.name, func(t *testing.T) {
+					entities, err := store.FindN("project", "person", 10, test.option)
+					require.NoError(t, err)
+					assert.ElementsMatch(t, test.expected, ids(entities))
+				})
+			}
+		})
+	}
+}
+
+func Test_Matching_Strict(t *testing.T) {
+	store, err := NewMemoryStore("", StrictMatching())
+	require.NoError(t, err)
+	store.Save(jstore.NewID("project", "person", "ford"), `{"name":"ford","age":42}`)
+	store.Save(jstore.NewID("project", "person", "zaphod"), `{"name":"zaphod","age":"old"}`)
+
+	entities, err := store.FindN("project", "person", 10, jstore.Eq("name", "ford"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ford"}, ids(entities))
+
+	_, err = store.FindN("project", "person", 10, jstore.Eq("age", 42))
+	assert.Error(t, err)
+
+	_, err = store.FindN("project", "person", 10, jstore.Eq("unknown", "value"))
+	assert.Error(t, err)
+}
+
+func Test_Matching_UnsupportedQuery(t *testing.T) {
+	store, _ := NewMemoryStore("")
+	store.Save(jstore.NewID("project", "person", "ford"), `{"name":"ford"}`)
+
+	_, err := store.FindN("project", "person", 10, jstore.Eq("name", struct{}{}))
+	assert.Error(t, err)
+
+	_, err = store.FindN("project", "person", 10, jstore.Gt("name", nil))
+	assert.Error(t, err)
+}
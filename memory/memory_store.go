@@ -2,6 +2,7 @@ package memory
 
 import (
 	"fmt"
+	"math"
 	"sort"
 	"sync"
 	"time"
@@ -20,203 +21,477 @@ func init() {
 type storageItem struct {
 	entity jstore.Entity
 	object map[string]interface{}
+
+	// inserted is the position of the item in the insertion order of
+	// its collection. Updates keep the position.
+	inserted uint64
 }
 
 func newItem(entity jstore.Entity) (storageItem, error) {
 	item := storageItem{
 		entity: entity,
 	}
-	err := json.Unmarshal([]byte(entity.JSON), &item.object)
+	err := json.Unmarshal(entity.Raw(), &item.object)
 	if err != nil {
 		return item, fmt.Errorf("could not unmarshall json: %w", err)
 	}
 	return item, nil
 }
 
-func (item *storageItem) matches(options ...jstore.Option) (bool, error) {
-	result := true
-	for _, option := range options {
-		switch option := option.(type) {
-		case jstore.SortOption:
-			continue
-		case jstore.IdOption:
-			result = result && (item.entity.ID == option.Value)
-		case jstore.CompareOption:
-			switch option.Value.(type) {
-			case string:
-				value, ok := item.object[option.Property].(string)
-				if !ok {
-					return false, fmt.Errorf("should be string")
-				}
+// MemoryStore keeps the documents in memory. Every document type of a
+// project is a collection with its own lock, so writes on different
+// collections do not block each other.
+type MemoryStore struct {
+	mutex       sync.RWMutex
+	collections map[string]map[string]*collection
+
+	indexes     []indexDefinition
+	persistence *persistence
+	strict      bool
+	order       Order
+
+	limits  []limitDefinition
+	policy  Policy
+	onEvict func(id jstore.EntityID, size int)
+	limiter *limiter
+}
 
-				switch option.Operation {
-				case "=":
-					result = result && (value == option.Value)
-				default:
-					return false, fmt.Errorf("unsupported compare option: %s", option.Operation)
-				}
-			case time.Time:
-				value, ok := item.object[option.Property].(string)
-				if !ok {
-					return false, fmt.Errorf("should be string")
-				}
+type collection struct {
+	mutex    sync.RWMutex
+	items    map[string]storageItem
+	modified time.Time
+	inserted uint64
 
-				t, err := time.Parse("2006-01-02T15:04:05Z", value)
-				if err != nil {
-					return false, fmt.Errorf("not a date: %w", err)
-				}
+	hashIndexes   map[string]*hashIndex
+	sortedIndexes map[string]*sortedIndex
+}
 
-				s := option.Value.(time.Time)
-
-				switch option.Operation {
-				case "=":
-					result = result && (t == s)
-				case "<":
-					result = result && (t.Before(s))
-				case "<=":
-					result = result && (t.Before(s) || t == s)
-				case ">":
-					result = result && (s.Before(t))
-				case ">=":
-					result = result && (s.Before(t) || s == t)
-				default:
-					return false, fmt.Errorf("unsupported compare option: %s", option.Operation)
-				}
+// NewMemoryStore creates a store, which keeps the documents in memory.
+// A data source name like memory:///var/lib/app makes the store
+// durable, see persistence for details.
+func NewMemoryStore(baseURL string, options ...jstore.StoreOption) (jstore.Store, error) {
+	store := &MemoryStore{
+		collections: map[string]map[string]*collection{},
+	}
+	for _, option := range options {
+		if memoryOption, ok := option.(MemoryStoreOption); ok {
+			if err := memoryOption(store); err != nil {
+				return nil, err
+			}
+		}
+	}
 
-			case int:
-				t, ok := item.object[option.Property].(float64)
-				if !ok {
-					return false, fmt.Errorf("not a number")
-				}
-				s := option.Value.(int)
-				r, err := handleNumber(option.Operation, t, float64(s))
-				if err != nil {
-					return false, err
-				}
-				result = result && r
+	if len(store.limits) > 0 {
+		store.limiter = newLimiter(store.limits, store.policy, store.onEvict)
+	}
 
-			case float64:
-				t, ok := item.object[option.Property].(float64)
-				if !ok {
-					return false, fmt.Errorf("not a number")
-				}
-				s := option.Value.(float64)
-				r, err := handleNumber(option.Operation, t, s)
-				if err != nil {
-					return false, err
-				}
-				result = result && r
+	persistence, err := parsePersistence(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if persistence != nil {
+		if err := persistence.open(store); err != nil {
+			return nil, err
+		}
+		store.persistence = persistence
+	}
+	return store, nil
+}
 
-			case int64:
-				t, ok := item.object[option.Property].(float64)
-				if !ok {
-					return false, fmt.Errorf("not a number")
-				}
-				s := option.Value.(int64)
-				r, err := handleNumber(option.Operation, t, float64(s))
-				if err != nil {
-					return false, err
-				}
-				result = result && r
+// Close closes the log of a durable store.
+func (store *MemoryStore) Close() error {
+	if store.persistence == nil {
+		return nil
+	}
+	return store.persistence.close()
+}
 
-			default:
-				return false, fmt.Errorf("unsupported type for comparison")
-			}
+// Snapshot compacts the log of a durable store into a new snapshot.
+func (store *MemoryStore) Snapshot() error {
+	if store.persistence == nil {
+		return nil
+	}
+	return store.persistence.snapshot(store)
+}
 
-		default:
-			return false, fmt.Errorf("unsupported option: %+v", option)
+// beginWrite blocks snapshots and, with limits, other writes until the
+// returned function is called.
+func (store *MemoryStore) beginWrite() func() error {
+	if store.persistence == nil && store.limiter == nil {
+		return func() error { return nil }
+	}
+	if store.persistence != nil {
+		store.persistence.gate.RLock()
+	}
+	if store.limiter != nil {
+		store.limiter.writes.Lock()
+	}
+	return func() error {
+		var evictions []eviction
+		if store.limiter != nil {
+			evictions = store.limiter.takeEvictions()
+			store.limiter.writes.Unlock()
+		}
+		var err error
+		if store.persistence != nil {
+			store.persistence.gate.RUnlock()
+			err = store.persistence.maybeSnapshot(store)
 		}
+		if len(evictions) > 0 {
+			store.limiter.notify(evictions)
+		}
+		return err
 	}
-	return result, nil
 }
 
-func handleNumber(operation string, t, s float64) (bool, error) {
-	switch operation {
-	case "=":
-		return (t == s), nil
-	case "<":
-		return (t < s), nil
-	case "<=":
-		return (t <= s), nil
-	case ">":
-		return (t > s), nil
-	case ">=":
-		return (t >= s), nil
-	default:
-		return false, fmt.Errorf("unsupported compare option: %s", operation)
+// log appends the record to the log of a durable store.
+func (store *MemoryStore) log(r record) error {
+	if store.persistence == nil {
+		return nil
 	}
+	return store.persistence.append(r)
 }
 
-type MemoryStore struct {
-	mutex sync.RWMutex
-
-	storage map[string]map[string]map[string]storageItem
+// restore applies a record of the snapshot or the log.
+func (store *MemoryStore) restore(r record) error {
+	c := store.collection(r.Project, r.DocumentType, true)
+	switch r.Operation {
+	case opSave:
+		item, err := newItem(jstore.Entity{
+			EntityID: jstore.NewIDWithVersion(r.Project, r.DocumentType, r.ID, r.Version),
+			JSON:     string(r.Document),
+		})
+		if err != nil {
+			return err
+		}
+		present, ok := c.items[r.ID]
+		if ok {
+			item.inserted = present.inserted
+			c.remove(present)
+		}
+		c.add(item)
+		store.limiter.saved(item, present, ok)
+	case opDelete:
+		if present, ok := c.items[r.ID]; ok {
+			c.remove(present)
+			store.limiter.removed(present)
+		}
+	default:
+		return fmt.Errorf("unknown operation %q", r.Operation)
+	}
+	c.modified = time.Now()
+	return nil
 }
 
-func NewMemoryStore(baseURL string, options ...jstore.StoreOption) (jstore.Store, error) {
-	return &MemoryStore{storage: map[string]map[string]map[string]storageItem{}}, nil
+// each calls fn for every stored item. The items of a collection are
+// passed in insertion order, so a restored snapshot keeps the order.
+func (store *MemoryStore) each(fn func(item storageItem) error) error {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	for _, documentTypes := range store.collections {
+		for _, c := range documentTypes {
+			c.mutex.RLock()
+			items := c.all()
+			sortByDefault(items, OrderByInsertion)
+			for _, item := range items {
+				if err := fn(item); err != nil {
+					c.mutex.RUnlock()
+					return err
+				}
+			}
+			c.mutex.RUnlock()
+		}
+	}
+	return nil
 }
 
 type Version int
 
-func (store *MemoryStore) Delete(id jstore.EntityID) error {
+// collection returns the collection of the document type. If create is
+// set, missing collections are created.
+func (store *MemoryStore) collection(project, documentType string, create bool) *collection {
+	store.mutex.RLock()
+	c, ok := store.collections[project][documentType]
+	store.mutex.RUnlock()
+	if ok || !create {
+		return c
+	}
+
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
-	if _, ok := store.storage[id.Project]; !ok {
-		return nil
+	if c, ok := store.collections[project][documentType]; ok {
+		return c
+	}
+	if _, ok := store.collections[project]; !ok {
+		store.collections[project] = map[string]*collection{}
+	}
+	c = store.newCollection(documentType)
+	store.collections[project][documentType] = c
+	return c
+}
+
+func (store *MemoryStore) newCollection(documentType string) *collection {
+	c := &collection{
+		items:         map[string]storageItem{},
+		hashIndexes:   map[string]*hashIndex{},
+		sortedIndexes: map[string]*sortedIndex{},
 	}
-	if _, ok := store.storage[id.Project][id.DocumentType]; !ok {
+	for _, definition := range store.indexes {
+		if !definition.appliesTo(documentType) {
+			continue
+		}
+		if definition.sorted {
+			c.sortedIndexes[definition.property] = newSortedIndex()
+		} else {
+			c.hashIndexes[definition.property] = newHashIndex()
+		}
+	}
+	return c
+}
+
+func (store *MemoryStore) Delete(id jstore.EntityID) (err error) {
+	c := store.collection(id.Project, id.DocumentType, false)
+	if c == nil {
 		return nil
 	}
 
-	item, ok := store.storage[id.Project][id.DocumentType][id.ID]
+	endWrite := store.beginWrite()
+	defer func() {
+		if endErr := endWrite(); err == nil {
+			err = endErr
+		}
+	}()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	item, ok := c.items[id.ID]
 	if ok && (item.entity.Version != id.Version && id.Version != nil) {
 		return jstore.OptimisticLockingError
 	}
 
-	delete(store.storage[id.Project][id.DocumentType], id.ID)
+	if ok {
+		if err := store.log(deleteRecord(id)); err != nil {
+			return err
+		}
+		c.remove(item)
+		c.modified = time.Now()
+		store.limiter.removed(item)
+	}
 
 	return nil
 }
 
-func (store *MemoryStore) Save(id jstore.EntityID, json string) (jstore.EntityID, error) {
-	store.mutex.Lock()
-	defer store.mutex.Unlock()
-
-	if _, ok := store.storage[id.Project]; !ok {
-		store.storage[id.Project] = map[string]map[string]storageItem{}
-	}
-	if _, ok := store.storage[id.Project][id.DocumentType]; !ok {
-		store.storage[id.Project][id.DocumentType] = map[string]storageItem{}
+// Save saves the document. Every save increments the version of the
+// stored document, also saves without a version, so they outdate the
+// versions read before.
+func (store *MemoryStore) Save(id jstore.EntityID, json string) (savedID jstore.EntityID, err error) {
+	item, err := newItem(jstore.Entity{EntityID: id, JSON: json})
+	if err != nil {
+		return jstore.EntityID{}, err
 	}
 
-	present, ok := store.storage[id.Project][id.DocumentType][id.ID]
+	endWrite := store.beginWrite()
+	defer func() {
+		if endErr := endWrite(); err == nil {
+			err = endErr
+		}
+	}()
+
+	c := store.collection(id.Project, id.DocumentType, true)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	present, ok := c.items[id.ID]
 	if ok && (present.entity.Version != id.Version && id.Version != jstore.NoVersion) {
 		return present.entity.EntityID, jstore.OptimisticLockingError
 	}
-	prevVersion, _ := id.Version.(Version)
 
-	entity := jstore.Entity{
-		EntityID: jstore.NewIDWithVersion(
-			id.Project,
-			id.DocumentType,
-			id.ID,
-			prevVersion+1,
-		),
-		ObjectRef: nil,
-		JSON:      json,
-	}
-	item, err := newItem(entity)
+	return store.save(c, item)
+}
+
+// Insert saves the document, if there is no document with the same id.
+func (store *MemoryStore) Insert(id jstore.EntityID, json string) (savedID jstore.EntityID, err error) {
+	item, err := newItem(jstore.Entity{EntityID: id, JSON: json})
 	if err != nil {
 		return jstore.EntityID{}, err
 	}
 
-	store.storage[id.Project][id.DocumentType][id.ID] = item
+	endWrite := store.beginWrite()
+	defer func() {
+		if endErr := endWrite(); err == nil {
+			err = endErr
+		}
+	}()
+
+	c := store.collection(id.Project, id.DocumentType, true)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.items[id.ID]; ok {
+		return jstore.EntityID{}, jstore.AlreadyExists
+	}
+	return store.save(c, item)
+}
+
+// save logs the item and replaces the present item of the collection
+// with it, incrementing the version.
+func (store *MemoryStore) save(c *collection, item storageItem) (jstore.EntityID, error) {
+	present, ok := c.items[item.entity.ID]
+	prevVersion, _ := present.entity.Version.(Version)
+
+	item.entity.EntityID = jstore.NewIDWithVersion(
+		item.entity.Project,
+		item.entity.DocumentType,
+		item.entity.ID,
+		prevVersion+1,
+	)
+	if err := store.limiter.makeRoom(store, c, item, present, ok); err != nil {
+		return jstore.EntityID{}, err
+	}
+	if err := store.log(saveRecord(item.entity)); err != nil {
+		return jstore.EntityID{}, err
+	}
+
+	if ok {
+		item.inserted = present.inserted
+		c.remove(present)
+	}
+	c.add(item)
+	c.modified = time.Now()
+	store.limiter.saved(item, present, ok)
 
 	return item.entity.EntityID, nil
 }
 
+func (c *collection) add(item storageItem) {
+	if item.inserted == 0 {
+		c.inserted++
+		item.inserted = c.inserted
+	}
+	c.items[item.entity.ID] = item
+	for property, index := range c.hashIndexes {
+		index.add(item.entity.ID, item.object[property])
+	}
+	for property, index := range c.sortedIndexes {
+		index.add(item.entity.ID, item.object[property])
+	}
+}
+
+func (c *collection) remove(item storageItem) {
+	delete(c.items, item.entity.ID)
+	for property, index := range c.hashIndexes {
+		index.remove(item.entity.ID, item.object[property])
+	}
+	for property, index := range c.sortedIndexes {
+		index.remove(item.entity.ID, item.object[property])
+	}
+}
+
+// candidates returns the items, which may match the options. It uses
+// the first applicable index and falls back to all items.
+func (c *collection) candidates(options ...jstore.Option) []storageItem {
+	for _, option := range options {
+		var ids []string
+		switch option := option.(type) {
+		case jstore.IdOption:
+			if item, ok := c.items[option.Value]; ok {
+				return []storageItem{item}
+			}
+			return []storageItem{}
+		case jstore.CompareOption:
+			if index, ok := c.hashIndexes[option.Property]; ok && option.Operation == "=" {
+				found, ok := index.lookup(option.Value)
+				if !ok {
+					continue
+				}
+				for id := range found {
+					ids = append(ids, id)
+				}
+			} else if index, ok := c.sortedIndexes[option.Property]; ok {
+				found, ok := index.lookup(option)
+				if !ok {
+					continue
+				}
+				for _, entry := range found {
+					ids = append(ids, entry.id)
+				}
+			} else {
+				continue
+			}
+		default:
+			continue
+		}
+
+		items := make([]storageItem, 0, len(ids))
+		for _, id := range ids {
+			items = append(items, c.items[id])
+		}
+		return items
+	}
+
+	return c.all()
+}
+
+func (c *collection) all() []storageItem {
+	items := make([]storageItem, 0, len(c.items))
+	for _, item := range c.items {
+		items = append(items, item)
+	}
+	return items
+}
+
+// sortByIndex sorts the items with a sorted index on the property of
+// the only sort option. It returns false, if there is no such index or
+// sorting the items directly is cheaper.
+func (c *collection) sortByIndex(items []storageItem, sorts []jstore.ModifiedSortOption) ([]storageItem, bool) {
+	if len(sorts) != 1 {
+		return nil, false
+	}
+	option := sorts[0]
+	index, ok := c.sortedIndexes[option.Property]
+	if !ok {
+		return nil, false
+	}
+	list, ok := index.ordered(items, option)
+	if !ok || len(items) < len(list.entries)/8 {
+		// sorting few items is cheaper than walking the index
+		return nil, false
+	}
+
+	byID := make(map[string]storageItem, len(items))
+	for _, item := range items {
+		byID[item.entity.ID] = item
+	}
+
+	sorted := make([]storageItem, 0, len(items))
+	collect := func(entries []sortedEntry) {
+		for _, entry := range entries {
+			if item, ok := byID[entry.id]; ok {
+				sorted = append(sorted, item)
+			}
+		}
+	}
+
+	entries := list.entries
+	if option.Ascending {
+		collect(entries)
+		return sorted, true
+	}
+	// walk backwards over runs of equal values, which stay ordered by id
+	for end := len(entries); end > 0 && len(sorted) < len(items); {
+		start := end - 1
+		for start > 0 && !list.less(entries[start-1], entries[end-1]) {
+			start--
+		}
+		collect(entries[start:end])
+		end = start
+	}
+	return sorted, true
+}
+
 func (store *MemoryStore) Get(id jstore.EntityID) (jstore.Entity, error) {
 	return store.Find(id.Project, id.DocumentType, jstore.Id(id.ID))
 }
@@ -233,21 +508,17 @@ func (store *MemoryStore) Find(project, documentType string, options ...jstore.O
 }
 
 func (store *MemoryStore) FindN(project, documentType string, maxCount int, options ...jstore.Option) ([]jstore.Entity, error) {
-	store.mutex.RLock()
-	defer store.mutex.RUnlock()
-
-	if _, ok := store.storage[project]; !ok {
-		return []jstore.Entity{}, jstore.NotFound
-	}
-	if _, ok := store.storage[project][documentType]; !ok {
+	c := store.collection(project, documentType, false)
+	if c == nil {
 		return []jstore.Entity{}, jstore.NotFound
 	}
 
-	list := store.storage[project][documentType]
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 
 	items := []storageItem{}
-	for _, item := range list {
-		matches, err := item.matches(options...)
+	for _, item := range c.candidates(options...) {
+		matches, err := item.matches(store.strict, options...)
 		if err != nil {
 			return []jstore.Entity{}, err
 		}
@@ -260,16 +531,14 @@ func (store *MemoryStore) FindN(project, documentType string, maxCount int, opti
 		return []jstore.Entity{}, nil
 	}
 
-	for _, o := range options {
-		switch o.(type) {
-		case jstore.SortOption:
-			var err error
-			items, err = store.sort(items, o.(jstore.SortOption))
-			if err != nil {
-				return []jstore.Entity{}, err
-			}
-		default:
+	if sorts := sortOptions(options); len(sorts) > 0 {
+		if sorted, ok := c.sortByIndex(items, sorts); ok {
+			items = sorted
+		} else {
+			sortItems(items, sorts)
 		}
+	} else {
+		sortByDefault(items, store.order)
 	}
 
 	if len(items) > maxCount {
@@ -280,54 +549,67 @@ func (store *MemoryStore) FindN(project, documentType string, maxCount int, opti
 	for _, item := range items {
 		result = append(result, item.entity)
 	}
+	store.limiter.touch(result)
 
 	return result, nil
 }
 
-func (store *MemoryStore) sort(items []storageItem, option jstore.SortOption) ([]storageItem, error) {
-	switch t := items[0].object[option.Property].(type) {
-	case string:
-		sort.Slice(items, func(i, j int) bool {
-			return option.Ascending == (items[i].object[option.Property].(string) <= items[j].object[option.Property].(string))
-		})
-	case int:
-		items = intSort(items, option.Property, option.Ascending)
-	case int8:
-		items = intSort(items, option.Property, option.Ascending)
-	case int16:
-		items = intSort(items, option.Property, option.Ascending)
-	case int32:
-		items = intSort(items, option.Property, option.Ascending)
-	case int64:
-		items = intSort(items, option.Property, option.Ascending)
-	case float32:
-		items = floatSort(items, option.Property, option.Ascending)
-	case float64:
-		items = floatSort(items, option.Property, option.Ascending)
-	case time.Time:
-		sort.Slice(items, func(i, j int) bool {
-			return option.Ascending ==
-				(items[i].object[option.Property].(time.Time).
-					Before(items[j].object[option.Property].(time.Time)))
-		})
-	default:
-		return []storageItem{}, fmt.Errorf("unsupported sort option on %s with type %T", option.Property, t)
+// Scan iterates over a snapshot of the matching documents.
+func (store *MemoryStore) Scan(project, documentType string, options ...jstore.Option) jstore.Iterator {
+	entities, err := store.FindN(project, documentType, math.MaxInt, options...)
+	if err == jstore.NotFound {
+		return jstore.NewSliceIterator(nil)
 	}
-	return items, nil
+	if err != nil {
+		return jstore.ErrorIterator(err)
+	}
+	return jstore.NewSliceIterator(entities)
 }
 
-func floatSort(items []storageItem, property string, ascending bool) []storageItem {
-	sort.Slice(items, func(i, j int) bool {
-		return ascending == (items[i].object[property].(float64) <= items[j].object[property].(float64))
-	})
-	return items
+func (store *MemoryStore) ListProjects() ([]string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return sortedKeys(store.collections), nil
 }
 
-func intSort(items []storageItem, property string, ascending bool) []storageItem {
-	sort.Slice(items, func(i, j int) bool {
-		return ascending == (items[i].object[property].(int64) <= items[j].object[property].(int64))
-	})
-	return items
+func (store *MemoryStore) ListDocumentTypes(project string) ([]string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	documentTypes, ok := store.collections[project]
+	if !ok {
+		return []string{}, jstore.NotFound
+	}
+	return sortedKeys(documentTypes), nil
+}
+
+func (store *MemoryStore) Stats(project, documentType string) (jstore.Stats, error) {
+	c := store.collection(project, documentType, false)
+	if c == nil {
+		return jstore.Stats{}, jstore.NotFound
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	stats := jstore.Stats{
+		Count:        int64(len(c.items)),
+		LastModified: c.modified,
+	}
+	for _, item := range c.items {
+		stats.SizeInBytes += int64(len(item.entity.JSON))
+	}
+	return stats, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 func (store *MemoryStore) HealthCheck() error {
@@ -3,10 +3,12 @@ package memory
 import (
 	"encoding/json"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/snabble/go-jstore/v2"
+	"github.com/snabble/go-jstore/v2/jstoretest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -50,227 +52,322 @@ func Test_BasicStoring(t *testing.T) {
 	assert.Equal(t, zaphod, result)
 }
 
-func Test_OptimisticLocking_Update(t *testing.T) {
+func Test_OptimisticLocking_SaveWithoutVersion(t *testing.T) {
 	store, _ := jstore.NewStore("memory", "memory")
 
 	id, _ := store.Marshal(ford, jstore.NewID("project", "person", "ford"))
+	unversionedID, err := store.Marshal(ford, jstore.NewID("project", "person", "ford"))
+	require.NoError(t, err)
+	assert.Equal(t, Version(2), unversionedID.Version)
 
-	assert.Equal(t, Version(1), id.Version)
-
-	updatedID, _ := store.Marshal(Person{"Ford Prefect", 43, day("1980-01-01")}, id)
-
-	assert.Equal(t, Version(2), updatedID.Version)
+	_, err = store.Marshal(ford, id)
+	assert.Equal(t, jstore.OptimisticLockingError, err)
+}
 
-	conflictedID, err := store.Marshal(Person{"Ford Prefect", 41, day("1980-01-01")}, id)
+func Test_FindN_SortBy_Types(t *testing.T) {
+	store, _ := NewMemoryStore("")
+	for id, document := range map[string]string{
+		"a": `{"name":"b","count":10,"at":"2042-01-01T12:00:00+02:00"}`,
+		"b": `{"name":"a","count":9,"at":"2042-01-01T11:00:00.5Z"}`,
+		"c": `{"name":"b","count":10,"at":"2042-01-01T11:00:00Z"}`,
+		"d": `{"name":"a","count":null,"at":"not a time"}`,
+	} {
+		_, err := store.Save(jstore.NewID("project", "type", id), document)
+		require.NoError(t, err)
+	}
 
-	assert.Equal(t, Version(2), conflictedID.Version)
-	assert.Equal(t, jstore.OptimisticLockingError, err)
+	for _, test := range []struct {
+		name     string
+		options  []jstore.Option
+		expected []string
+	}{
+		{"numbers", []jstore.Option{jstore.SortBy("count", true)}, []string{"b", "a", "c", "d"}},
+		{"ties by id", []jstore.Option{jstore.SortBy("name", false)}, []string{"a", "c", "b", "d"}},
+		{"strings", []jstore.Option{jstore.SortBy("at", true)}, []string{"b", "c", "a", "d"}},
+		{"times", []jstore.Option{jstore.SortBy("at", true, jstore.AsTime())}, []string{"a", "c", "b", "d"}},
+		{"times descending", []jstore.Option{jstore.SortBy("at", false, jstore.AsTime())}, []string{"b", "c", "a", "d"}},
+		{"missing first", []jstore.Option{jstore.SortBy("count", false, jstore.MissingFirst())}, []string{"d", "a", "c", "b"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			entities, err := store.FindN("project", "type", 10, test.options...)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, ids(entities))
+		})
+	}
 }
 
-func Test_OptimisticLocking_Delete(t *testing.T) {
-	store, _ := jstore.NewStore("memory", "memory")
+func Test_FindN_DefaultOrder(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		options  []jstore.StoreOption
+		expected []string
+	}{
+		{"by id", nil, []string{"arthur", "ford", "marvin", "zaphod"}},
+		{"by id explicitly", []jstore.StoreOption{DefaultOrder(OrderByID)}, []string{"arthur", "ford", "marvin", "zaphod"}},
+		{"by insertion", []jstore.StoreOption{DefaultOrder(OrderByInsertion)}, []string{"ford", "marvin", "arthur", "zaphod"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := NewMemoryStore("", test.options...)
+			require.NoError(t, err)
+			for _, id := range []string{"zaphod", "ford", "marvin", "arthur"} {
+				store.Save(jstore.NewID("project", "person", id), `{"age":42}`)
+			}
+			// updates keep the position, saving after a delete does not
+			store.Save(jstore.NewID("project", "person", "ford"), `{"age":43}`)
+			store.Delete(jstore.NewID("project", "person", "zaphod"))
+			store.Save(jstore.NewID("project", "person", "zaphod"), `{"age":42}`)
+
+			for i := 0; i < 10; i++ {
+				entities, err := store.FindN("project", "person", 10, jstore.Gt("age", 0))
+				require.NoError(t, err)
+				assert.Equal(t, test.expected, ids(entities))
+			}
 
-	id, _ := store.Marshal(ford, jstore.NewID("project", "person", "ford"))
-	store.Marshal(Person{"Ford Prefect", 43, day("1980-01-01")}, id)
+			entities, err := store.FindN("project", "person", 2)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected[:2], ids(entities))
 
-	err := store.Delete(id)
+			entity, err := store.Find("project", "person", jstore.Lt("age", 50))
+			require.NoError(t, err)
+			assert.Equal(t, test.expected[0], entity.ID)
+		})
+	}
 
-	assert.Equal(t, jstore.OptimisticLockingError, err)
+	_, err := NewMemoryStore("", DefaultOrder(Order(42)))
+	assert.Error(t, err)
 }
 
-func Test_FindInMissingProject(t *testing.T) {
-	store, _ := jstore.NewStore("memory", "memory")
+func day(theDay string) time.Time {
+	dayPattern := "2006-01-02"
+	t, err := time.Parse(dayPattern, theDay)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
 
-	_, err := store.Find("project", "person", jstore.Id("ford"))
+func Test_Discovery(t *testing.T) {
+	store, err := NewMemoryStore("")
+	require.NoError(t, err)
+	discoverer, ok := jstore.AsDiscoverer(jstore.WrapStore(store))
+	require.True(t, ok)
 
+	_, err = discoverer.ListDocumentTypes("project")
 	assert.Equal(t, jstore.NotFound, err)
-}
 
-func Test_CompareOptions(t *testing.T) {
-	store, err := jstore.NewStore("memory", "memory")
+	before := time.Now()
+	store.Save(jstore.NewID("project", "person", "ford"), `{"name":"Ford"}`)
+	store.Save(jstore.NewID("project", "person", "marvin"), `{"name":"Marvin"}`)
+	store.Save(jstore.NewID("project", "spaceship", "heartOfGold"), `{}`)
+	store.Save(jstore.NewID("other", "person", "zaphod"), `{}`)
+
+	projects, err := discoverer.ListProjects()
 	require.NoError(t, err)
+	assert.Equal(t, []string{"other", "project"}, projects)
 
-	store.Marshal(ford, jstore.NewID("project", "person", "ford"))
-	store.Marshal(marvin, jstore.NewID("project", "person", "marvin"))
-	store.Marshal(zaphod, jstore.NewID("project", "person", "zaphod"))
+	documentTypes, err := discoverer.ListDocumentTypes("project")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"person", "spaceship"}, documentTypes)
 
-	tests := []struct {
-		name     string
-		options  []jstore.Option
-		expected *Person
-	}{
-		{
-			"integer equal",
-			[]jstore.Option{jstore.Eq("age", 42)},
-			&ford,
-		},
-		{
-			"string equal",
-			[]jstore.Option{jstore.Eq("name", "Ford Prefect")},
-			&ford,
-		},
-		{
-			" > ",
-			[]jstore.Option{jstore.Gt("age", 1010)},
-			&zaphod,
-		},
-		{
-			" > ",
-			[]jstore.Option{jstore.Gt("age", 4200)},
-			nil,
-		},
-		{
-			" >= ",
-			[]jstore.Option{jstore.Gte("age", 4200)},
-			&zaphod,
-		},
-		{
-			" < ",
-			[]jstore.Option{jstore.Lt("age", 42)},
-			nil,
-		},
-		{
-			" <= ",
-			[]jstore.Option{jstore.Lte("age", 42)},
-			&ford,
-		},
-		{
-			" gt and lt ",
-			[]jstore.Option{jstore.Gt("age", 42), jstore.Lt("age", 4200)},
-			&marvin,
-		},
-		{
-			"date =",
-			[]jstore.Option{jstore.Eq("birthDay", day("2042-01-01"))},
-			&marvin,
-		},
-		{
-			" < on date",
-			[]jstore.Option{jstore.Lt("birthDay", day("1980-01-01"))},
-			&zaphod,
-		},
-		{
-			" <= and >= on date",
-			[]jstore.Option{jstore.Lte("birthDay", day("1980-01-01")), jstore.Gte("birthDay", day("1980-01-01"))},
-			&ford,
+	stats, err := discoverer.Stats("project", "person")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.Count)
+	assert.Equal(t, int64(len(`{"name":"Ford"}`)+len(`{"name":"Marvin"}`)), stats.SizeInBytes)
+	assert.False(t, stats.LastModified.Before(before))
+
+	_, err = discoverer.Stats("project", "unknown")
+	assert.Equal(t, jstore.NotFound, err)
+}
+
+func Test_Conformance(t *testing.T) {
+	jstoretest.RunConformance(t,
+		func(t *testing.T) jstore.Store {
+			store, err := NewMemoryStore("")
+			require.NoError(t, err)
+			return store
 		},
-	}
+	)
+}
 
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			result := &Person{}
-			err = store.Unmarshal(&result, "project", "person", test.options...)
-			if test.expected == nil {
-				assert.Equal(t, jstore.NotFound, err)
-			} else {
-				assert.NoError(t, err)
-				assert.Equal(t, test.expected, result)
-			}
-		})
-	}
+func Test_Conformance_Indexed(t *testing.T) {
+	jstoretest.RunConformance(t,
+		func(t *testing.T) jstore.Store {
+			store, err := NewMemoryStore("",
+				HashIndex("*", "name"),
+				HashIndex("person", "age"),
+				SortedIndex("*", "age"),
+				SortedIndex("*", "height"),
+				SortedIndex("*", "birthDay"),
+				SortedIndex("*", "name"),
+			)
+			require.NoError(t, err)
+			return store
+		},
+	)
 }
 
-func Test_FindN(t *testing.T) {
-	store, err := jstore.NewStore("memory", "memory")
+func Test_Indexes(t *testing.T) {
+	store, err := NewMemoryStore("", HashIndex("person", "name"), SortedIndex("person", "age"))
 	require.NoError(t, err)
 
-	for i := 0; i < 50; i++ {
-		p := Person{
-			Name: "person-" + strconv.Itoa(i),
-			Age:  i,
-		}
-		_, err := store.Marshal(p, jstore.NewID("project", "person", strconv.Itoa(i)))
-		require.NoError(t, err)
-	}
+	store.Save(jstore.NewID("project", "person", "ford"), `{"name":"ford","age":42}`)
+	store.Save(jstore.NewID("project", "person", "marvin"), `{"name":"marvin","age":1010}`)
+	store.Save(jstore.NewID("project", "person", "zaphod"), `{"name":"zaphod","age":4200}`)
 
-	// find a subset
-	docs, err := store.FindN("project", "person", 20)
+	// updates and deletes are reflected in the indexes
+	store.Save(jstore.NewID("project", "person", "ford"), `{"name":"ford prefect","age":43}`)
+	store.Delete(jstore.NewID("project", "person", "zaphod"))
+
+	entities, err := store.FindN("project", "person", 10, jstore.Eq("name", "ford"))
 	require.NoError(t, err)
-	assert.Equal(t, 20, len(docs))
+	assert.Empty(t, entities)
 
-	for _, d := range docs {
-		p := Person{}
-		err = json.Unmarshal([]byte(d.JSON), &p)
-		require.NoError(t, err)
-		assert.Contains(t, p.Name, "person-")
-	}
+	entities, err = store.FindN("project", "person", 10, jstore.Eq("name", "ford prefect"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ford"}, ids(entities))
 
-	// find all
-	docs, err = store.FindN("project", "person", 1000)
+	entities, err = store.FindN("project", "person", 10, jstore.Gt("age", 42))
 	require.NoError(t, err)
-	assert.Equal(t, 50, len(docs))
-}
+	assert.ElementsMatch(t, []string{"ford", "marvin"}, ids(entities))
 
-func Test_FindN_SortBy(t *testing.T) {
-	store, err := jstore.NewStore("memory", "memory")
+	entities, err = store.FindN("project", "person", 10, jstore.Lte("age", 43), jstore.Eq("name", "ford prefect"))
 	require.NoError(t, err)
+	assert.Equal(t, []string{"ford"}, ids(entities))
 
-	for i := 0; i < 50; i++ {
-		p := Person{
-			Name: "person-" + strconv.Itoa(i),
-			Age:  i,
-		}
-		_, err := store.Marshal(p, jstore.NewID("project", "person", strconv.Itoa(i)))
-		require.NoError(t, err)
-	}
+	entities, err = store.FindN("project", "person", 10, jstore.SortBy("age", false))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"marvin", "ford"}, ids(entities))
 
-	// ascending
-	docs, err := store.FindN("project", "person", 20, jstore.SortBy("age", true))
+	// ties are ordered by id in both directions
+	store.Save(jstore.NewID("project", "person", "arthur"), `{"name":"arthur","age":43}`)
+	entities, err = store.FindN("project", "person", 10, jstore.SortBy("age", false))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"marvin", "arthur", "ford"}, ids(entities))
+	entities, err = store.FindN("project", "person", 10, jstore.SortBy("age", true))
 	require.NoError(t, err)
-	assert.Equal(t, 20, len(docs))
+	assert.Equal(t, []string{"arthur", "ford", "marvin"}, ids(entities))
 
-	age := -1
-	for _, d := range docs {
-		p := Person{}
-		err = json.Unmarshal([]byte(d.JSON), &p)
-		require.NoError(t, err)
-		assert.True(t, age <= p.Age)
-		age = p.Age
+	_, err = NewMemoryStore("", HashIndex("[", "name"))
+	assert.Error(t, err)
+}
+
+func ids(entities []jstore.Entity) []string {
+	result := []string{}
+	for _, entity := range entities {
+		result = append(result, entity.ID)
 	}
+	return result
+}
 
-	// descending
-	docs, err = store.FindN("project", "person", 20, jstore.SortBy("age", false))
-	require.NoError(t, err)
-	assert.Equal(t, 20, len(docs))
+func Benchmark_FindN(b *testing.B) {
+	for _, indexed := range []bool{false, true} {
+		options := []jstore.StoreOption{}
+		if indexed {
+			options = append(options, HashIndex("person", "name"), SortedIndex("person", "age"))
+		}
+		store, _ := NewMemoryStore("", options...)
+		for i := 0; i < 100000; i++ {
+			store.Save(
+				jstore.NewID("project", "person", strconv.Itoa(i)),
+				`{"name":"person`+strconv.Itoa(i%1000)+`","age":`+strconv.Itoa(i)+`}`,
+			)
+		}
 
-	age = 9999999
-	for _, d := range docs {
-		p := Person{}
-		err = json.Unmarshal([]byte(d.JSON), &p)
-		require.NoError(t, err)
-		assert.True(t, age >= p.Age)
-		age = p.Age
+		name := "scan"
+		if indexed {
+			name = "indexed"
+		}
+		b.Run(name+"/Eq", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				store.FindN("project", "person", 10, jstore.Eq("name", "person42"))
+			}
+		})
+		b.Run(name+"/Range", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				store.FindN("project", "person", 10, jstore.Gte("age", 99990))
+			}
+		})
+		b.Run(name+"/SortBy", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				store.FindN("project", "person", 10, jstore.Lt("age", 1000), jstore.SortBy("age", false))
+			}
+		})
 	}
+}
 
+func Benchmark_Save_Parallel(b *testing.B) {
+	store, _ := NewMemoryStore("")
+	b.RunParallel(func(pb *testing.PB) {
+		documentType := nextDocumentType()
+		i := 0
+		for pb.Next() {
+			store.Save(jstore.NewID("project", documentType, strconv.Itoa(i%1000)), `{"name":"ford"}`)
+			i++
+		}
+	})
 }
 
-func Test_Delete(t *testing.T) {
-	store, err := jstore.NewStore("memory", "memory")
-	require.NoError(t, err)
+var documentTypes int64
 
-	store.Marshal(ford, jstore.NewID("project", "person", "ford"))
-	store.Marshal(zaphod, jstore.NewID("project", "person", "zaphod"))
+func nextDocumentType() string {
+	return "type" + strconv.FormatInt(atomic.AddInt64(&documentTypes, 1), 10)
+}
 
-	// ford is there
-	var result Person
-	require.NoError(t, store.Unmarshal(&result, "project", "person", jstore.Id("ford")))
+func Benchmark_Marshal(b *testing.B) {
+	store, _ := jstore.NewStore(DriverName, "")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := store.Marshal(ford, jstore.NewID("project", "person", strconv.Itoa(i%1000))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
 
-	// delete ford
-	require.NoError(t, store.Delete(jstore.NewID("project", "person", "ford")))
+func Benchmark_Save(b *testing.B) {
+	store, _ := jstore.NewStore(DriverName, "")
+	document := benchmarkDocument()
+	b.SetBytes(int64(len(document)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := store.Save(jstore.NewID("project", "person", strconv.Itoa(i%1000)), string(document)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
 
-	// ford is away
-	err = store.Unmarshal(&result, "project", "person", jstore.Id("ford"))
-	assert.Equal(t, jstore.NotFound, err)
+func Benchmark_SaveRaw(b *testing.B) {
+	store, _ := jstore.NewStore(DriverName, "")
+	document := benchmarkDocument()
+	b.SetBytes(int64(len(document)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := store.SaveRaw(jstore.NewID("project", "person", strconv.Itoa(i%1000)), document); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
 
-	// but zaphod is still there
-	require.NoError(t, store.Unmarshal(&result, "project", "person", jstore.Id("zaphod")))
+func Benchmark_Unmarshal(b *testing.B) {
+	store, _ := jstore.NewStore(DriverName, "")
+	store.Marshal(ford, jstore.NewID("project", "person", "ford"))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result := Person{}
+		if err := store.Unmarshal(&result, "project", "person", jstore.Id("ford")); err != nil {
+			b.Fatal(err)
+		}
+	}
 }
 
-func day(theDay string) time.Time {
-	dayPattern := "2006-01-02"
-	t, err := time.Parse(dayPattern, theDay)
-	if err != nil {
-		panic(err)
+// benchmarkDocument returns a document of about 100kb.
+func benchmarkDocument() json.RawMessage {
+	items := make([]Person, 1000)
+	for i := range items {
+		items[i] = ford
 	}
-	return t
+	document, _ := json.Marshal(map[string]interface{}{"items": items})
+	return document
 }
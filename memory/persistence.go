@@ -0,0 +1,333 @@
+package memory
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/snabble/go-jstore/v2"
+)
+
+const (
+	snapshotFile = "snapshot"
+	walFile      = "wal"
+
+	// DefaultSnapshotAfter is the number of log records, after which
+	// the log is compacted into a new snapshot.
+	DefaultSnapshotAfter = 10000
+)
+
+// persistence writes every change to a write-ahead log and compacts
+// the log into snapshots. It is enabled by a data source name like
+//
+//	memory:///var/lib/app?fsync=always&snapshotAfter=10000
+//
+// With fsync=always, every write is synced to disk before it returns.
+// The default fsync=never leaves flushing to the operating system.
+type persistence struct {
+	dir           string
+	fsync         bool
+	snapshotAfter int64
+
+	// writes hold the gate shared, snapshots exclusively, so a
+	// snapshot sees no half applied writes.
+	gate sync.RWMutex
+
+	walMutex     sync.Mutex
+	wal          *os.File
+	size         int64
+	records      int64
+	snapshotting int32
+}
+
+type record struct {
+	Operation    string          `json:"op"`
+	Project      string          `json:"project"`
+	DocumentType string          `json:"documentType"`
+	ID           string          `json:"id"`
+	Version      Version         `json:"version,omitempty"`
+	Document     json.RawMessage `json:"document,omitempty"`
+}
+
+const (
+	opSave   = "save"
+	opDelete = "delete"
+)
+
+// parsePersistence returns the persistence configured by the data
+// source name or nil, if the store is not durable. Only the empty data
+// source name and, for compatibility, the plain driver name are not
+// durable.
+func parsePersistence(dataSourceName string) (*persistence, error) {
+	if dataSourceName == "" || dataSourceName == DriverName {
+		return nil, nil
+	}
+	dsn, err := url.Parse(dataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data source name: %w", err)
+	}
+	if dsn.Scheme != DriverName || dsn.Host != "" || dsn.Path == "" {
+		return nil, fmt.Errorf("invalid data source name %q, expected %s:///path/to/dir", dataSourceName, DriverName)
+	}
+
+	p := &persistence{
+		dir:           filepath.FromSlash(dsn.Path),
+		snapshotAfter: DefaultSnapshotAfter,
+	}
+	query := dsn.Query()
+	switch query.Get("fsync") {
+	case "always":
+		p.fsync = true
+	case "", "never":
+	default:
+		return nil, fmt.Errorf("invalid fsync mode %q, expected always or never", query.Get("fsync"))
+	}
+	if value := query.Get("snapshotAfter"); value != "" {
+		p.snapshotAfter, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || p.snapshotAfter <= 0 {
+			return nil, fmt.Errorf("invalid snapshotAfter %q", value)
+		}
+	}
+	return p, nil
+}
+
+// open restores the store from the snapshot and the log. An incomplete
+// last record of the log, e.g. from a crash while writing, is
+// truncated. Corrupted records followed by more data fail the open, so
+// the records behind them are not lost.
+func (p *persistence) open(store *MemoryStore) error {
+	if err := os.MkdirAll(p.dir, 0o755); err != nil {
+		return fmt.Errorf("creating %s: %w", p.dir, err)
+	}
+
+	snapshot, err := os.Open(filepath.Join(p.dir, snapshotFile))
+	if err == nil {
+		_, err = readRecords(snapshot, store.restore)
+		snapshot.Close()
+		if err != nil {
+			return fmt.Errorf("reading snapshot: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("reading snapshot: %w", err)
+	}
+
+	p.wal, err = os.OpenFile(filepath.Join(p.dir, walFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening log: %w", err)
+	}
+	valid, err := readRecords(p.wal, func(r record) error {
+		atomic.AddInt64(&p.records, 1)
+		return store.restore(r)
+	})
+	if err != nil && !errors.Is(err, errTornTail) {
+		p.wal.Close()
+		return fmt.Errorf("reading log: %w", err)
+	}
+	if err := p.wal.Truncate(valid); err != nil {
+		p.wal.Close()
+		return fmt.Errorf("truncating log: %w", err)
+	}
+	if _, err := p.wal.Seek(valid, io.SeekStart); err != nil {
+		p.wal.Close()
+		return fmt.Errorf("opening log: %w", err)
+	}
+	p.size = valid
+	return nil
+}
+
+// append writes the record to the log.
+func (p *persistence) append(r record) error {
+	p.walMutex.Lock()
+	defer p.walMutex.Unlock()
+
+	n, err := writeRecord(p.wal, r)
+	if err == nil && p.fsync {
+		err = p.wal.Sync()
+	}
+	if err != nil {
+		// remove a partially written record, so later records are not
+		// lost behind it on replay
+		p.wal.Truncate(p.size)
+		p.wal.Seek(p.size, io.SeekStart)
+		return fmt.Errorf("writing log: %w", err)
+	}
+	p.size += int64(n)
+	atomic.AddInt64(&p.records, 1)
+	return nil
+}
+
+// maybeSnapshot writes a snapshot, if the log is long enough. It must
+// not be called while holding the gate.
+func (p *persistence) maybeSnapshot(store *MemoryStore) error {
+	if atomic.LoadInt64(&p.records) < p.snapshotAfter {
+		return nil
+	}
+	if !atomic.CompareAndSwapInt32(&p.snapshotting, 0, 1) {
+		return nil
+	}
+	defer atomic.StoreInt32(&p.snapshotting, 0)
+
+	return p.snapshot(store)
+}
+
+// snapshot writes all documents to a new snapshot and truncates the
+// log.
+func (p *persistence) snapshot(store *MemoryStore) error {
+	p.gate.Lock()
+	defer p.gate.Unlock()
+
+	tmp := filepath.Join(p.dir, snapshotFile+".tmp")
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+	w := bufio.NewWriter(file)
+	err = store.each(func(item storageItem) error {
+		_, err := writeRecord(w, saveRecord(item.entity))
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(p.dir, snapshotFile)); err != nil {
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+	syncDir(p.dir)
+
+	p.walMutex.Lock()
+	defer p.walMutex.Unlock()
+
+	// replaying the log on top of the snapshot is idempotent, so a crash
+	// before the truncation does no harm.
+	if err := p.wal.Truncate(0); err != nil {
+		return fmt.Errorf("truncating log: %w", err)
+	}
+	if _, err := p.wal.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("truncating log: %w", err)
+	}
+	p.size = 0
+	atomic.StoreInt64(&p.records, 0)
+	return nil
+}
+
+func (p *persistence) close() error {
+	p.walMutex.Lock()
+	defer p.walMutex.Unlock()
+
+	return p.wal.Close()
+}
+
+func saveRecord(entity jstore.Entity) record {
+	version, _ := entity.Version.(Version)
+	return record{
+		Operation:    opSave,
+		Project:      entity.Project,
+		DocumentType: entity.DocumentType,
+		ID:           entity.ID,
+		Version:      version,
+		Document:     entity.Raw(),
+	}
+}
+
+func deleteRecord(id jstore.EntityID) record {
+	return record{
+		Operation:    opDelete,
+		Project:      id.Project,
+		DocumentType: id.DocumentType,
+		ID:           id.ID,
+	}
+}
+
+var (
+	errCorrupted = errors.New("corrupted record")
+	errTornTail  = errors.New("incomplete last record")
+)
+
+const maxRecordSize = 1 << 30
+
+// writeRecord writes the record framed by its length and checksum and
+// returns the number of written bytes.
+func writeRecord(w io.Writer, r record) (int, error) {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return 0, err
+	}
+	frame := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	copy(frame[8:], payload)
+	return w.Write(frame)
+}
+
+// readRecords calls apply for every record and returns the offset after
+// the last valid one. An incomplete or invalid last record, e.g. from a
+// crash while writing, stops the reading with errTornTail. Invalid
+// records followed by more data stop it with errCorrupted.
+func readRecords(r io.Reader, apply func(r record) error) (int64, error) {
+	reader := bufio.NewReader(r)
+	offset := int64(0)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(reader, header); err == io.EOF {
+			return offset, nil
+		} else if err != nil {
+			return offset, fmt.Errorf("%w at offset %d: %v", errTornTail, offset, err)
+		}
+
+		size := binary.BigEndian.Uint32(header[0:4])
+		if size > maxRecordSize {
+			invalid := errCorrupted
+			if _, err := io.CopyN(io.Discard, reader, int64(size)); err != nil {
+				invalid = errTornTail
+			}
+			return offset, fmt.Errorf("%w at offset %d: invalid size %d", invalid, offset, size)
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return offset, fmt.Errorf("%w at offset %d: %v", errTornTail, offset, err)
+		}
+
+		invalid := errCorrupted
+		if _, err := reader.Peek(1); err == io.EOF {
+			invalid = errTornTail
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+			return offset, fmt.Errorf("%w at offset %d: checksum mismatch", invalid, offset)
+		}
+		rec := record{}
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			return offset, fmt.Errorf("%w at offset %d: %v", invalid, offset, err)
+		}
+		if err := apply(rec); err != nil {
+			return offset, err
+		}
+		offset += int64(len(header) + len(payload))
+	}
+}
+
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,183 @@
+package memory
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/snabble/go-jstore/v2"
+	"github.com/snabble/go-jstore/v2/jstoretest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openDurable(t *testing.T, dir string, params string, options ...jstore.StoreOption) *MemoryStore {
+	store, err := NewMemoryStore("memory://"+filepath.ToSlash(dir)+params, options...)
+	require.NoError(t, err)
+	return store.(*MemoryStore)
+}
+
+func Test_Durable_Restart(t *testing.T) {
+	dir := t.TempDir()
+	store := openDurable(t, dir, "?fsync=always")
+
+	store.Save(jstore.NewID("project", "person", "ford"), `{"name":"ford"}`)
+	store.Save(jstore.NewID("project", "person", "ford"), `{"name":"ford prefect"}`)
+	store.Save(jstore.NewID("project", "person", "zaphod"), `{"name":"zaphod"}`)
+	store.Delete(jstore.NewID("project", "person", "zaphod"))
+	require.NoError(t, store.Close())
+
+	store = openDurable(t, dir, "")
+	defer store.Close()
+
+	entity, err := store.Get(jstore.NewID("project", "person", "ford"))
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"ford prefect"}`, entity.JSON)
+	assert.Equal(t, Version(2), entity.Version)
+
+	_, err = store.Get(jstore.NewID("project", "person", "zaphod"))
+	assert.Equal(t, jstore.NotFound, err)
+
+	// versions continue after the restart
+	id, err := store.Save(entity.EntityID, `{"name":"ford"}`)
+	require.NoError(t, err)
+	assert.Equal(t, Version(3), id.Version)
+}
+
+func Test_Durable_Snapshot(t *testing.T) {
+	dir := t.TempDir()
+	store := openDurable(t, dir, "?snapshotAfter=10")
+
+	for i := 0; i < 25; i++ {
+		_, err := store.Save(jstore.NewID("project", "person", strconv.Itoa(i%5)), `{"count":`+strconv.Itoa(i)+`}`)
+		require.NoError(t, err)
+	}
+	require.NoError(t, store.Close())
+
+	// the log contains only the writes after the last snapshot
+	assert.Equal(t, 5, countRecords(t, filepath.Join(dir, walFile)))
+	assert.Equal(t, 5, countRecords(t, filepath.Join(dir, snapshotFile)))
+
+	store = openDurable(t, dir, "")
+	defer store.Close()
+
+	entities, err := store.FindN("project", "person", 10, jstore.SortBy("count", true))
+	require.NoError(t, err)
+	require.Equal(t, 5, len(entities))
+	assert.Equal(t, `{"count":20}`, entities[0].JSON)
+	assert.Equal(t, Version(5), entities[0].Version)
+}
+
+func Test_Durable_InsertionOrder(t *testing.T) {
+	dir := t.TempDir()
+	store := openDurable(t, dir, "", DefaultOrder(OrderByInsertion))
+	for _, id := range []string{"zaphod", "ford", "marvin", "arthur"} {
+		_, err := store.Save(jstore.NewID("project", "person", id), `{}`)
+		require.NoError(t, err)
+	}
+	require.NoError(t, store.Close())
+
+	for _, snapshot := range []bool{false, true} {
+		store = openDurable(t, dir, "", DefaultOrder(OrderByInsertion))
+		entities, err := store.FindN("project", "person", 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"zaphod", "ford", "marvin", "arthur"}, ids(entities), "snapshot %v", snapshot)
+		require.NoError(t, store.Snapshot())
+		require.NoError(t, store.Close())
+	}
+}
+
+func countRecords(t *testing.T, path string) int {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	count := 0
+	_, err = readRecords(file, func(r record) error {
+		count++
+		return nil
+	})
+	require.NoError(t, err)
+	return count
+}
+
+func Test_Durable_CorruptedTail(t *testing.T) {
+	for name, corrupt := range map[string]func(data []byte) []byte{
+		"truncated": func(data []byte) []byte { return data[:len(data)-3] },
+		"checksum":  func(data []byte) []byte { data[len(data)-2] ^= 0xff; return data },
+		"garbage":   func(data []byte) []byte { return append(data, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 1) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			store := openDurable(t, dir, "")
+			store.Save(jstore.NewID("project", "person", "ford"), `{"name":"ford"}`)
+			store.Save(jstore.NewID("project", "person", "zaphod"), `{"name":"zaphod"}`)
+			require.NoError(t, store.Close())
+
+			path := filepath.Join(dir, walFile)
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(path, corrupt(data), 0o644))
+
+			store = openDurable(t, dir, "")
+			_, err = store.Get(jstore.NewID("project", "person", "ford"))
+			assert.NoError(t, err)
+
+			// the store keeps working after the corrupted tail
+			_, err = store.Save(jstore.NewID("project", "person", "marvin"), `{"name":"marvin"}`)
+			require.NoError(t, err)
+			require.NoError(t, store.Close())
+
+			store = openDurable(t, dir, "")
+			defer store.Close()
+			_, err = store.Get(jstore.NewID("project", "person", "marvin"))
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func Test_Durable_CorruptedRecord(t *testing.T) {
+	dir := t.TempDir()
+	store := openDurable(t, dir, "")
+	store.Save(jstore.NewID("project", "person", "ford"), `{"name":"ford"}`)
+	store.Save(jstore.NewID("project", "person", "zaphod"), `{"name":"zaphod"}`)
+	require.NoError(t, store.Close())
+
+	path := filepath.Join(dir, walFile)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[10] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	_, err = NewMemoryStore("memory://" + filepath.ToSlash(dir))
+	assert.ErrorIs(t, err, errCorrupted)
+
+	// the records behind the corrupted one are kept
+	corrupted, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, data, corrupted)
+}
+
+func Test_Durable_InvalidDataSourceName(t *testing.T) {
+	_, err := NewMemoryStore("memory://" + filepath.ToSlash(t.TempDir()) + "?fsync=sometimes")
+	assert.Error(t, err)
+
+	_, err = NewMemoryStore("memory://" + filepath.ToSlash(t.TempDir()) + "?snapshotAfter=-1")
+	assert.Error(t, err)
+
+	for _, dataSourceName := range []string{"memory://var/lib/app", "memory://", "memroy:///var/lib/app", "memory:///%zz"} {
+		_, err = NewMemoryStore(dataSourceName)
+		assert.Error(t, err, dataSourceName)
+	}
+}
+
+func Test_Durable_Conformance(t *testing.T) {
+	jstoretest.RunConformance(t,
+		func(t *testing.T) jstore.Store {
+			store := openDurable(t, t.TempDir(), "?snapshotAfter=7")
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+	)
+}
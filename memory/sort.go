@@ -0,0 +1,170 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/snabble/go-jstore/v2"
+)
+
+// Order is the order of the results of queries without SortBy.
+type Order int
+
+const (
+	// OrderByID orders the results by document id. This is the
+	// default.
+	OrderByID Order = iota
+
+	// OrderByInsertion orders the results by the first save of the
+	// documents. Updates keep the position of a document.
+	OrderByInsertion
+)
+
+// DefaultOrder sets the order of the results of queries without
+// SortBy.
+func DefaultOrder(order Order) MemoryStoreOption {
+	return func(store *MemoryStore) error {
+		if order != OrderByID && order != OrderByInsertion {
+			return fmt.Errorf("invalid order %d", order)
+		}
+		store.order = order
+		return nil
+	}
+}
+
+// sortByDefault sorts the items in the order.
+func sortByDefault(items []storageItem, order Order) {
+	if order == OrderByInsertion {
+		sort.Slice(items, func(i, j int) bool { return items[i].inserted < items[j].inserted })
+		return
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].entity.ID < items[j].entity.ID })
+}
+
+// kinds of sort values. Values of different kinds are ordered by kind,
+// which only happens, if a property has different types.
+const (
+	kindMissing = iota
+	kindBool
+	kindNumber
+	kindTime
+	kindString
+)
+
+type sortValue struct {
+	kind   int
+	number float64
+	time   time.Time
+	text   string
+}
+
+func toSortValue(value interface{}, asTime bool) sortValue {
+	switch value := value.(type) {
+	case bool:
+		if value {
+			return sortValue{kind: kindBool, number: 1}
+		}
+		return sortValue{kind: kindBool}
+	case float64:
+		return sortValue{kind: kindNumber, number: value}
+	case string:
+		if !asTime {
+			return sortValue{kind: kindString, text: value}
+		}
+		t, err := parseTime(value)
+		if err != nil {
+			return sortValue{kind: kindMissing}
+		}
+		return sortValue{kind: kindTime, time: t}
+	default:
+		// null, objects and arrays
+		return sortValue{kind: kindMissing}
+	}
+}
+
+// compare returns -1, 0 or 1, if a is less, equal or greater than b.
+// Missing values are not handled here.
+func (a sortValue) compare(b sortValue) int {
+	switch {
+	case a.kind != b.kind:
+		return compareOrdered(a.kind, b.kind)
+	case a.kind == kindTime:
+		return compareTimes(a.time, b.time)
+	case a.kind == kindString:
+		return compareOrdered(a.text, b.text)
+	default:
+		return compareOrdered(a.number, b.number)
+	}
+}
+
+func compareOrdered[T int | float64 | string](a, b T) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareTimes(a, b time.Time) int {
+	if a.Before(b) {
+		return -1
+	}
+	if b.Before(a) {
+		return 1
+	}
+	return 0
+}
+
+// sortItems sorts the items by the sort options. Earlier options take
+// precedence, ties are ordered by id.
+func sortItems(items []storageItem, options []jstore.ModifiedSortOption) {
+	values := make(map[string][]sortValue, len(items))
+	for _, item := range items {
+		itemValues := make([]sortValue, len(options))
+		for i, option := range options {
+			itemValues[i] = toSortValue(item.object[option.Property], option.AsTime)
+		}
+		values[item.entity.ID] = itemValues
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := values[items[i].entity.ID], values[items[j].entity.ID]
+		for k, option := range options {
+			if c := compareSortValues(a[k], b[k], option); c != 0 {
+				return c < 0
+			}
+		}
+		return items[i].entity.ID < items[j].entity.ID
+	})
+}
+
+func compareSortValues(a, b sortValue, option jstore.ModifiedSortOption) int {
+	aMissing, bMissing := a.kind == kindMissing, b.kind == kindMissing
+	switch {
+	case aMissing && bMissing:
+		return 0
+	case aMissing != bMissing:
+		// missing values are ordered independent of the direction
+		if aMissing == option.MissingFirst {
+			return -1
+		}
+		return 1
+	case option.Ascending:
+		return a.compare(b)
+	default:
+		return b.compare(a)
+	}
+}
+
+func sortOptions(options []jstore.Option) []jstore.ModifiedSortOption {
+	result := []jstore.ModifiedSortOption{}
+	for _, option := range options {
+		if option, ok := jstore.AsSortOption(option); ok {
+			result = append(result, option)
+		}
+	}
+	return result
+}
@@ -0,0 +1,360 @@
+package jstore
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sync"
+)
+
+// Mismatch describes a read, which returned different results on the
+// primary and the secondary store of a MirrorStore.
+type Mismatch struct {
+	Operation    string
+	Project      string
+	DocumentType string
+	Options      []Option
+
+	Primary        []Entity
+	PrimaryError   error
+	Secondary      []Entity
+	SecondaryError error
+}
+
+type MirrorOption func(store *mirrorStore)
+
+// ShadowReads enables the comparison of every read with the result
+// of the secondary store. Differences are reported to onMismatch.
+func ShadowReads(onMismatch func(mismatch Mismatch)) MirrorOption {
+	return func(store *mirrorStore) {
+		store.onMismatch = onMismatch
+	}
+}
+
+// SecondaryErrors sets a callback for failed writes on the secondary
+// store. Those errors are not returned to the caller, because the
+// primary store is the source of truth.
+func SecondaryErrors(onError func(operation string, id EntityID, err error)) MirrorOption {
+	return func(store *mirrorStore) {
+		store.onSecondaryError = onError
+	}
+}
+
+// TrackedVersions limits the number of documents, for which the
+// version of the secondary store is kept. Default is
+// DefaultTrackedVersions. The least recently written documents are
+// forgotten first; their next versioned write overwrites the secondary
+// without a version.
+func TrackedVersions(n int) MirrorOption {
+	return func(store *mirrorStore) {
+		store.maxTrackedVersions = n
+	}
+}
+
+// DefaultTrackedVersions is the default of TrackedVersions.
+const DefaultTrackedVersions = 10000
+
+// MirrorStore returns a store, which writes to the primary and the
+// secondary store and reads from the primary store only.
+//
+// The versions of the returned EntityIDs are the versions of the
+// primary store. The versions of the secondary store are tracked
+// internally for the recently written documents, see TrackedVersions,
+// and used for the writes on the secondary.
+//
+// The returned store unwraps to the primary store, so Scan and
+// AsDiscoverer bypass the secondary store and the shadow reads.
+func MirrorStore(primary, secondary Store, options ...MirrorOption) Store {
+	store := &mirrorStore{
+		primary:            primary,
+		secondary:          secondary,
+		maxTrackedVersions: DefaultTrackedVersions,
+		secondaryVersions:  map[string]*list.Element{},
+		versionOrder:       list.New(),
+	}
+	for _, option := range options {
+		option(store)
+	}
+	return store
+}
+
+type mirrorStore struct {
+	primary   Store
+	secondary Store
+
+	onMismatch       func(mismatch Mismatch)
+	onSecondaryError func(operation string, id EntityID, err error)
+
+	// writes on the same document are serialized, so that both
+	// stores see them in the same order.
+	locks [64]sync.Mutex
+
+	mutex              sync.Mutex
+	maxTrackedVersions int
+	secondaryVersions  map[string]*list.Element
+	versionOrder       *list.List // of *trackedVersion, most recent first
+}
+
+type trackedVersion struct {
+	key     string
+	version Version
+}
+
+func (store *mirrorStore) Delete(id EntityID) error {
+	lock := store.lock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := store.primary.Delete(id); err != nil {
+		return err
+	}
+
+	secondaryID := store.secondaryID(id)
+	if err := store.secondary.Delete(secondaryID); err != nil {
+		store.secondaryFailed("delete", secondaryID, err)
+	}
+	store.trackSecondaryVersion(id, NoVersion)
+	return nil
+}
+
+func (store *mirrorStore) Save(id EntityID, json string) (EntityID, error) {
+	lock := store.lock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	savedID, err := store.primary.Save(id, json)
+	if err != nil {
+		return savedID, err
+	}
+
+	secondaryID := store.secondaryID(id)
+	secondarySavedID, err := store.secondary.Save(secondaryID, json)
+	if err != nil {
+		store.secondaryFailed("save", secondaryID, err)
+		store.trackSecondaryVersion(id, NoVersion)
+		return savedID, nil
+	}
+	store.trackSecondaryVersion(id, secondarySavedID.Version)
+
+	return savedID, nil
+}
+
+// Insert inserts into the primary store. The secondary store is
+// overwritten, because the primary store decides on conflicts.
+func (store *mirrorStore) Insert(id EntityID, json string) (EntityID, error) {
+	lock := store.lock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	savedID, err := Insert(store.primary, id, json)
+	if err != nil {
+		return savedID, err
+	}
+
+	secondaryID := NewID(id.Project, id.DocumentType, id.ID)
+	secondarySavedID, err := store.secondary.Save(secondaryID, json)
+	if err != nil {
+		store.secondaryFailed("insert", secondaryID, err)
+		store.trackSecondaryVersion(id, NoVersion)
+		return savedID, nil
+	}
+	store.trackSecondaryVersion(id, secondarySavedID.Version)
+
+	return savedID, nil
+}
+
+func (store *mirrorStore) Get(id EntityID) (Entity, error) {
+	entity, err := store.primary.Get(id)
+	if store.onMismatch != nil {
+		shadow, shadowErr := store.secondary.Get(id)
+		store.compare(Mismatch{
+			Operation:      "get",
+			Project:        id.Project,
+			DocumentType:   id.DocumentType,
+			Options:        []Option{Id(id.ID)},
+			Primary:        []Entity{entity},
+			PrimaryError:   err,
+			Secondary:      []Entity{shadow},
+			SecondaryError: shadowErr,
+		}, false)
+	}
+	return entity, err
+}
+
+func (store *mirrorStore) Find(project, documentType string, options ...Option) (Entity, error) {
+	entity, err := store.primary.Find(project, documentType, options...)
+	if store.onMismatch != nil {
+		shadow, shadowErr := store.secondary.Find(project, documentType, options...)
+		store.compare(Mismatch{
+			Operation:      "find",
+			Project:        project,
+			DocumentType:   documentType,
+			Options:        options,
+			Primary:        []Entity{entity},
+			PrimaryError:   err,
+			Secondary:      []Entity{shadow},
+			SecondaryError: shadowErr,
+		}, false)
+	}
+	return entity, err
+}
+
+func (store *mirrorStore) FindN(project, documentType string, maxResults int, options ...Option) ([]Entity, error) {
+	entities, err := store.primary.FindN(project, documentType, maxResults, options...)
+	if store.onMismatch != nil {
+		shadow, shadowErr := store.secondary.FindN(project, documentType, maxResults, options...)
+		store.compare(Mismatch{
+			Operation:      "findN",
+			Project:        project,
+			DocumentType:   documentType,
+			Options:        options,
+			Primary:        entities,
+			PrimaryError:   err,
+			Secondary:      shadow,
+			SecondaryError: shadowErr,
+		}, !hasSortOption(options))
+	}
+	return entities, err
+}
+
+func (store *mirrorStore) HealthCheck() error {
+	if err := store.primary.HealthCheck(); err != nil {
+		return fmt.Errorf("primary: %w", err)
+	}
+	if err := store.secondary.HealthCheck(); err != nil {
+		return fmt.Errorf("secondary: %w", err)
+	}
+	return nil
+}
+
+// Unwrap returns the primary store. Functions looking through wrapping
+// stores, like Scan and AsDiscoverer, therefore read the primary store
+// directly, without shadow reads on the secondary.
+func (store *mirrorStore) Unwrap() Store {
+	return store.primary
+}
+
+func (store *mirrorStore) lock(id EntityID) *sync.Mutex {
+	hash := fnv.New32a()
+	hash.Write([]byte(mirrorKey(id)))
+	return &store.locks[hash.Sum32()%uint32(len(store.locks))]
+}
+
+// secondaryID replaces the primary version by the tracked version of
+// the secondary store.
+func (store *mirrorStore) secondaryID(id EntityID) EntityID {
+	version := NoVersion
+	if id.Version != NoVersion {
+		store.mutex.Lock()
+		if element, ok := store.secondaryVersions[mirrorKey(id)]; ok {
+			version = element.Value.(*trackedVersion).version
+		}
+		store.mutex.Unlock()
+	}
+	return NewIDWithVersion(id.Project, id.DocumentType, id.ID, version)
+}
+
+func (store *mirrorStore) trackSecondaryVersion(id EntityID, version Version) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	key := mirrorKey(id)
+	if element, ok := store.secondaryVersions[key]; ok {
+		store.versionOrder.Remove(element)
+		delete(store.secondaryVersions, key)
+	}
+	if version == NoVersion || store.maxTrackedVersions <= 0 {
+		return
+	}
+
+	store.secondaryVersions[key] = store.versionOrder.PushFront(&trackedVersion{key, version})
+	for store.versionOrder.Len() > store.maxTrackedVersions {
+		oldest := store.versionOrder.Back()
+		store.versionOrder.Remove(oldest)
+		delete(store.secondaryVersions, oldest.Value.(*trackedVersion).key)
+	}
+}
+
+func (store *mirrorStore) secondaryFailed(operation string, id EntityID, err error) {
+	if store.onSecondaryError != nil {
+		store.onSecondaryError(operation, id, err)
+	}
+}
+
+func (store *mirrorStore) compare(mismatch Mismatch, unordered bool) {
+	if mismatch.PrimaryError != nil || mismatch.SecondaryError != nil {
+		if !sameError(mismatch.PrimaryError, mismatch.SecondaryError) {
+			store.onMismatch(mismatch)
+		}
+		return
+	}
+	if !sameEntities(mismatch.Primary, mismatch.Secondary, unordered) {
+		store.onMismatch(mismatch)
+	}
+}
+
+// sameError compares the errors by the sentinel errors they wrap, as
+// the stores report them differently.
+func sameError(a, b error) bool {
+	if (a == nil) != (b == nil) {
+		return false
+	}
+	for _, sentinel := range []error{NotFound, AlreadyExists, OptimisticLockingError} {
+		if errors.Is(a, sentinel) != errors.Is(b, sentinel) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameEntities(a, b []Entity, unordered bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	if unordered {
+		byID := make(map[string]Entity, len(b))
+		for _, entity := range b {
+			byID[entity.ID] = entity
+		}
+		for _, entity := range a {
+			other, ok := byID[entity.ID]
+			if !ok || !sameJSON(entity.JSON, other.JSON) {
+				return false
+			}
+		}
+		return true
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID || !sameJSON(a[i].JSON, b[i].JSON) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameJSON(a, b string) bool {
+	if a == b {
+		return true
+	}
+	var objectA, objectB interface{}
+	if json.Unmarshal([]byte(a), &objectA) != nil || json.Unmarshal([]byte(b), &objectB) != nil {
+		return false
+	}
+	return reflect.DeepEqual(objectA, objectB)
+}
+
+func hasSortOption(options []Option) bool {
+	for _, option := range options {
+		if _, ok := AsSortOption(option); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func mirrorKey(id EntityID) string {
+	return id.Project + "/" + id.DocumentType + "/" + id.ID
+}
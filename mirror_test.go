@@ -0,0 +1,121 @@
+package jstore_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/snabble/go-jstore/v2"
+	"github.com/snabble/go-jstore/v2/jstoretest"
+	"github.com/snabble/go-jstore/v2/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MirrorStore_WritesBoth(t *testing.T) {
+	primary, _ := memory.NewMemoryStore("")
+	secondary, _ := memory.NewMemoryStore("")
+	store := jstore.WrapStore(jstore.MirrorStore(primary, secondary))
+
+	// the secondary is ahead, so versions differ between both stores
+	_, err := secondary.Save(jstore.NewID("project", "person", "ford"), `{"name":"Ford"}`)
+	require.NoError(t, err)
+
+	id, err := store.Save(jstore.NewID("project", "person", "ford"), `{"name":"Ford"}`)
+	require.NoError(t, err)
+	assert.Equal(t, memory.Version(1), id.Version)
+
+	id, err = store.Save(id, `{"name":"Ford Prefect"}`)
+	require.NoError(t, err)
+	assert.Equal(t, memory.Version(2), id.Version)
+
+	_, err = store.Save(jstore.NewIDWithVersion("project", "person", "ford", memory.Version(1)), `{"name":"Ford"}`)
+	assert.Equal(t, jstore.OptimisticLockingError, err)
+
+	entity, err := secondary.Get(jstore.NewID("project", "person", "ford"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"Ford Prefect"}`, entity.JSON)
+
+	require.NoError(t, store.Delete(id))
+	_, err = secondary.Get(jstore.NewID("project", "person", "ford"))
+	assert.Equal(t, jstore.NotFound, err)
+}
+
+func Test_MirrorStore_ShadowReads(t *testing.T) {
+	primary, _ := memory.NewMemoryStore("")
+	secondary, _ := memory.NewMemoryStore("")
+
+	mismatches := []jstore.Mismatch{}
+	store := jstore.MirrorStore(primary, secondary, jstore.ShadowReads(func(mismatch jstore.Mismatch) {
+		mismatches = append(mismatches, mismatch)
+	}))
+
+	_, err := store.Save(jstore.NewID("project", "person", "ford"), `{"name":"Ford","age":42}`)
+	require.NoError(t, err)
+
+	_, err = store.Get(jstore.NewID("project", "person", "ford"))
+	require.NoError(t, err)
+	_, err = store.FindN("project", "person", 10)
+	require.NoError(t, err)
+	assert.Empty(t, mismatches)
+
+	_, err = secondary.Save(jstore.NewID("project", "person", "ford"), `{"name":"Ford","age":43}`)
+	require.NoError(t, err)
+
+	entity, err := store.Find("project", "person", jstore.Id("ford"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"Ford","age":42}`, entity.JSON)
+
+	require.Equal(t, 1, len(mismatches))
+	assert.Equal(t, "find", mismatches[0].Operation)
+	assert.JSONEq(t, `{"name":"Ford","age":43}`, mismatches[0].Secondary[0].JSON)
+}
+
+func Test_MirrorStore_TrackedVersions(t *testing.T) {
+	primary, _ := memory.NewMemoryStore("")
+	backend, _ := memory.NewMemoryStore("")
+	secondary := jstoretest.NewFakeStore(backend)
+	store := jstore.MirrorStore(primary, secondary, jstore.TrackedVersions(1))
+
+	ford, err := store.Save(jstore.NewID("project", "person", "ford"), `{"name":"Ford"}`)
+	require.NoError(t, err)
+	marvin, err := store.Save(jstore.NewID("project", "person", "marvin"), `{"name":"Marvin"}`)
+	require.NoError(t, err)
+
+	secondary.Reset()
+	_, err = store.Save(marvin, `{"name":"Marvin the Paranoid Android"}`)
+	require.NoError(t, err)
+	_, err = store.Save(ford, `{"name":"Ford Prefect"}`)
+	require.NoError(t, err)
+
+	saves := secondary.CallsTo(jstoretest.OpSave)
+	require.Len(t, saves, 2)
+	assert.Equal(t, memory.Version(1), saves[0].ID.Version)
+	assert.Equal(t, jstore.NoVersion, saves[1].ID.Version, "ford was forgotten")
+
+	entity, err := backend.Get(jstore.NewID("project", "person", "ford"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"Ford Prefect"}`, entity.JSON)
+}
+
+func Test_MirrorStore_ShadowReadErrors(t *testing.T) {
+	primary, _ := memory.NewMemoryStore("")
+	backend, _ := memory.NewMemoryStore("")
+	secondary := jstoretest.NewFakeStore(backend)
+
+	mismatches := []jstore.Mismatch{}
+	store := jstore.MirrorStore(primary, secondary, jstore.ShadowReads(func(mismatch jstore.Mismatch) {
+		mismatches = append(mismatches, mismatch)
+	}))
+
+	// both report a missing document, but with different errors
+	secondary.On(jstoretest.OpGet).Fail(fmt.Errorf("elasticsearch: %w", jstore.NotFound)).Times(1)
+	_, err := store.Get(jstore.NewID("project", "person", "ford"))
+	assert.Equal(t, jstore.NotFound, err)
+	assert.Empty(t, mismatches)
+
+	secondary.On(jstoretest.OpGet).Fail(errors.New("unavailable")).Times(1)
+	_, err = store.Get(jstore.NewID("project", "person", "ford"))
+	assert.Equal(t, jstore.NotFound, err)
+	assert.Len(t, mismatches, 1)
+}
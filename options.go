@@ -37,14 +37,72 @@ type IdOption struct {
 	Value string
 }
 
-func SortBy(property string, ascending bool) Option {
-	return SortOption{
-		property,
-		ascending,
+// SortBy sorts by the property. Multiple SortBy options are applied in
+// order, so later ones only decide between documents with equal
+// values. Documents without the property are ordered last, unless
+// modified by MissingFirst.
+//
+// Without modifiers, SortBy returns a SortOption, otherwise a
+// ModifiedSortOption. Stores read both with AsSortOption.
+func SortBy(property string, ascending bool, modifiers ...SortModifier) Option {
+	if len(modifiers) == 0 {
+		return SortOption{property, ascending}
 	}
+	option := ModifiedSortOption{SortOption: SortOption{property, ascending}}
+	for _, modifier := range modifiers {
+		modifier(&option)
+	}
+	return option
 }
 
 type SortOption struct {
 	Property  string
 	Ascending bool
 }
+
+// ModifiedSortOption is a SortOption with the settings of its
+// SortModifiers.
+type ModifiedSortOption struct {
+	SortOption
+	// AsTime sorts RFC3339 strings as timestamps.
+	AsTime bool
+	// MissingFirst orders documents without the property first.
+	MissingFirst bool
+}
+
+// AsSortOption returns the option as ModifiedSortOption, if it is a
+// SortOption or a ModifiedSortOption.
+func AsSortOption(option Option) (ModifiedSortOption, bool) {
+	switch option := option.(type) {
+	case SortOption:
+		return ModifiedSortOption{SortOption: option}, true
+	case ModifiedSortOption:
+		return option, true
+	}
+	return ModifiedSortOption{}, false
+}
+
+type SortModifier func(option *ModifiedSortOption)
+
+// AsTime sorts RFC3339 strings as timestamps instead of strings, so
+// different time zones and fractions of seconds are ordered correctly.
+func AsTime() SortModifier {
+	return func(option *ModifiedSortOption) {
+		option.AsTime = true
+	}
+}
+
+// MissingFirst orders documents without the property first.
+func MissingFirst() SortModifier {
+	return func(option *ModifiedSortOption) {
+		option.MissingFirst = true
+	}
+}
+
+// MissingLast orders documents without the property last, which is the
+// default.
+func MissingLast() SortModifier {
+	return func(option *ModifiedSortOption) {
+		option.MissingFirst = false
+	}
+}
@@ -0,0 +1,27 @@
+package jstore_test
+
+import (
+	"testing"
+
+	"github.com/snabble/go-jstore/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SortBy(t *testing.T) {
+	assert.Equal(t, jstore.SortOption{Property: "age", Ascending: true}, jstore.SortBy("age", true))
+
+	option, ok := jstore.AsSortOption(jstore.SortBy("age", false, jstore.AsTime(), jstore.MissingFirst()))
+	assert.True(t, ok)
+	assert.Equal(t, jstore.ModifiedSortOption{
+		SortOption:   jstore.SortOption{Property: "age", Ascending: false},
+		AsTime:       true,
+		MissingFirst: true,
+	}, option)
+
+	option, ok = jstore.AsSortOption(jstore.SortBy("age", true))
+	assert.True(t, ok)
+	assert.Equal(t, jstore.ModifiedSortOption{SortOption: jstore.SortOption{Property: "age", Ascending: true}}, option)
+
+	_, ok = jstore.AsSortOption(jstore.Id("ford"))
+	assert.False(t, ok)
+}
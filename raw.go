@@ -0,0 +1,60 @@
+package jstore
+
+import (
+	"encoding/json"
+	"unsafe"
+)
+
+// RawSaver is implemented by stores, which are able to save raw json
+// without converting it to a string first.
+type RawSaver interface {
+	// SaveRaw saves the document like Save. The store must not retain
+	// or modify raw after returning.
+	SaveRaw(id EntityID, raw json.RawMessage) (EntityID, error)
+}
+
+// SaveRaw saves the raw json in the store. Stores which do not
+// implement RawSaver get a copy of raw as string.
+func SaveRaw(store Store, id EntityID, raw json.RawMessage) (EntityID, error) {
+	if saver, ok := store.(RawSaver); ok {
+		return saver.SaveRaw(id, raw)
+	}
+	return store.Save(id, string(raw))
+}
+
+// NewRawEntity returns an entity, which takes the ownership of raw. The
+// JSON of the entity shares the memory of raw, so raw must not be
+// modified afterwards.
+func NewRawEntity(id EntityID, raw json.RawMessage) Entity {
+	return Entity{
+		EntityID: id,
+		JSON:     bytesToString(raw),
+	}
+}
+
+// Raw returns the JSON of the entity without copying it. The returned
+// slice shares the memory of the JSON string and must not be modified.
+func (entity Entity) Raw() json.RawMessage {
+	return stringToBytes(entity.JSON)
+}
+
+// bytesToString converts without copying, so b must not be modified
+// afterwards.
+func bytesToString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+// stringToBytes converts without copying, so the result must not be
+// modified.
+func stringToBytes(s string) []byte {
+	if len(s) == 0 {
+		return nil
+	}
+	return *(*[]byte)(unsafe.Pointer(&struct {
+		string
+		int
+	}{s, len(s)}))
+}
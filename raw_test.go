@@ -0,0 +1,40 @@
+package jstore_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/snabble/go-jstore/v2"
+	"github.com/snabble/go-jstore/v2/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SaveRaw(t *testing.T) {
+	memoryStore, _ := memory.NewMemoryStore("")
+	store := jstore.WrapStore(memoryStore)
+
+	raw := json.RawMessage(`{"name":"ford"}`)
+	_, err := store.Bucket("project", "person").SaveRaw(jstore.NewID("", "", "ford"), raw)
+	require.NoError(t, err)
+
+	// the store must not retain raw
+	copy(raw, `{"name":"zaph"}`)
+
+	entity, err := store.Get(jstore.NewID("project", "person", "ford"))
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"ford"}`, entity.JSON)
+	assert.Equal(t, json.RawMessage(`{"name":"ford"}`), entity.Raw())
+}
+
+func Test_NewRawEntity(t *testing.T) {
+	id := jstore.NewID("project", "person", "ford")
+	entity := jstore.NewRawEntity(id, json.RawMessage(`{"name":"ford"}`))
+
+	assert.Equal(t, id, entity.EntityID)
+	assert.Equal(t, `{"name":"ford"}`, entity.JSON)
+	assert.Equal(t, json.RawMessage(`{"name":"ford"}`), entity.Raw())
+
+	assert.Equal(t, "", jstore.NewRawEntity(id, nil).JSON)
+	assert.Empty(t, jstore.Entity{}.Raw())
+}
@@ -0,0 +1,236 @@
+package jstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Route maps projects and document types to a store. The patterns
+// use the syntax of path.Match, e.g. "*" matches everything.
+type Route struct {
+	Project      string
+	DocumentType string
+	Store        Store
+}
+
+// RouteTo creates a Route for the given patterns.
+func RouteTo(projectPattern, documentTypePattern string, store Store) Route {
+	return Route{
+		Project:      projectPattern,
+		DocumentType: documentTypePattern,
+		Store:        store,
+	}
+}
+
+// RoutingStore delegates every call to the store of the first route
+// matching the project and document type.
+type RoutingStore struct {
+	routes []Route
+}
+
+func NewRoutingStore(routes ...Route) (*RoutingStore, error) {
+	for _, route := range routes {
+		if route.Store == nil {
+			return nil, fmt.Errorf("route %s/%s has no store", route.Project, route.DocumentType)
+		}
+		if _, err := path.Match(route.Project, ""); err != nil {
+			return nil, fmt.Errorf("invalid project pattern '%s': %w", route.Project, err)
+		}
+		if _, err := path.Match(route.DocumentType, ""); err != nil {
+			return nil, fmt.Errorf("invalid document type pattern '%s': %w", route.DocumentType, err)
+		}
+	}
+	return &RoutingStore{routes: routes}, nil
+}
+
+func (store *RoutingStore) Delete(id EntityID) error {
+	target, err := store.route(id.Project, id.DocumentType)
+	if err != nil {
+		return err
+	}
+	return target.Delete(id)
+}
+
+func (store *RoutingStore) Save(id EntityID, json string) (EntityID, error) {
+	target, err := store.route(id.Project, id.DocumentType)
+	if err != nil {
+		return EntityID{}, err
+	}
+	return target.Save(id, json)
+}
+
+func (store *RoutingStore) SaveRaw(id EntityID, raw json.RawMessage) (EntityID, error) {
+	target, err := store.route(id.Project, id.DocumentType)
+	if err != nil {
+		return EntityID{}, err
+	}
+	return SaveRaw(target, id, raw)
+}
+
+func (store *RoutingStore) Insert(id EntityID, json string) (EntityID, error) {
+	target, err := store.route(id.Project, id.DocumentType)
+	if err != nil {
+		return EntityID{}, err
+	}
+	return Insert(target, id, json)
+}
+
+func (store *RoutingStore) Get(id EntityID) (Entity, error) {
+	target, err := store.route(id.Project, id.DocumentType)
+	if err != nil {
+		return Entity{}, err
+	}
+	return target.Get(id)
+}
+
+func (store *RoutingStore) Find(project, documentType string, options ...Option) (Entity, error) {
+	target, err := store.route(project, documentType)
+	if err != nil {
+		return Entity{}, err
+	}
+	return target.Find(project, documentType, options...)
+}
+
+func (store *RoutingStore) FindN(project, documentType string, maxResults int, options ...Option) ([]Entity, error) {
+	target, err := store.route(project, documentType)
+	if err != nil {
+		return nil, err
+	}
+	return target.FindN(project, documentType, maxResults, options...)
+}
+
+func (store *RoutingStore) Scan(project, documentType string, options ...Option) Iterator {
+	target, err := store.route(project, documentType)
+	if err != nil {
+		return ErrorIterator(err)
+	}
+	return Scan(target, project, documentType, options...)
+}
+
+// HealthCheck checks all distinct backends and reports every failing
+// one.
+func (store *RoutingStore) HealthCheck() error {
+	failures := []string{}
+	for i, s := range store.stores() {
+		if err := s.HealthCheck(); err != nil {
+			failures = append(failures, fmt.Sprintf("backend %d: %v", i, err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("routing store health: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// ListProjects lists the projects of all backends.
+func (store *RoutingStore) ListProjects() ([]string, error) {
+	return store.collect(
+		func(discoverer Discoverer) ([]string, error) {
+			return discoverer.ListProjects()
+		},
+		func(project string, backend Store) bool {
+			for _, route := range store.routes {
+				if matches, _ := path.Match(route.Project, project); matches && sameStore(route.Store, backend) {
+					return true
+				}
+			}
+			return false
+		},
+	)
+}
+
+// ListDocumentTypes lists the document types of the project in all
+// backends.
+func (store *RoutingStore) ListDocumentTypes(project string) ([]string, error) {
+	return store.collect(
+		func(discoverer Discoverer) ([]string, error) {
+			return discoverer.ListDocumentTypes(project)
+		},
+		func(documentType string, backend Store) bool {
+			target, err := store.route(project, documentType)
+			return err == nil && sameStore(target, backend)
+		},
+	)
+}
+
+func (store *RoutingStore) Stats(project, documentType string) (Stats, error) {
+	target, err := store.route(project, documentType)
+	if err != nil {
+		return Stats{}, err
+	}
+	discoverer, ok := AsDiscoverer(target)
+	if !ok {
+		return Stats{}, NotSupported
+	}
+	return discoverer.Stats(project, documentType)
+}
+
+// collect merges the names listed by all backends. Only names, which
+// are routed to the listing backend are taken into account.
+func (store *RoutingStore) collect(
+	list func(discoverer Discoverer) ([]string, error),
+	routed func(name string, backend Store) bool,
+) ([]string, error) {
+	names := map[string]bool{}
+	for _, backend := range store.stores() {
+		discoverer, ok := AsDiscoverer(backend)
+		if !ok {
+			return nil, NotSupported
+		}
+		listed, err := list(discoverer)
+		if err != nil && err != NotFound {
+			return nil, err
+		}
+		for _, name := range listed {
+			if routed(name, backend) {
+				names[name] = true
+			}
+		}
+	}
+
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (store *RoutingStore) route(project, documentType string) (Store, error) {
+	for _, route := range store.routes {
+		projectMatches, _ := path.Match(route.Project, project)
+		documentTypeMatches, _ := path.Match(route.DocumentType, documentType)
+		if projectMatches && documentTypeMatches {
+			return route.Store, nil
+		}
+	}
+	return nil, fmt.Errorf("no store routed for %s/%s", project, documentType)
+}
+
+// stores returns the distinct stores of all routes
+func (store *RoutingStore) stores() []Store {
+	stores := []Store{}
+	for _, route := range store.routes {
+		if !containsStore(stores, route.Store) {
+			stores = append(stores, route.Store)
+		}
+	}
+	return stores
+}
+
+func containsStore(stores []Store, store Store) bool {
+	for _, s := range stores {
+		if sameStore(s, store) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameStore(a, b Store) bool {
+	return reflect.TypeOf(a) == reflect.TypeOf(b) && reflect.TypeOf(a).Comparable() && a == b
+}
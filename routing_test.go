@@ -0,0 +1,116 @@
+package jstore_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/snabble/go-jstore/v2"
+	"github.com/snabble/go-jstore/v2/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type unhealthyStore struct {
+	jstore.Store
+}
+
+func (store unhealthyStore) HealthCheck() error {
+	return errors.New("down")
+}
+
+func Test_RoutingStore_Routes(t *testing.T) {
+	ephemeral, _ := memory.NewMemoryStore("")
+	dedicated, _ := memory.NewMemoryStore("")
+	fallback, _ := memory.NewMemoryStore("")
+
+	routing, err := jstore.NewRoutingStore(
+		jstore.RouteTo("*", "session", ephemeral),
+		jstore.RouteTo("big-*", "*", dedicated),
+		jstore.RouteTo("*", "*", fallback),
+	)
+	require.NoError(t, err)
+	store := jstore.WrapStore(routing)
+
+	_, err = store.Save(jstore.NewID("big-project", "session", "1"), `{}`)
+	require.NoError(t, err)
+	_, err = store.Save(jstore.NewID("big-project", "order", "2"), `{}`)
+	require.NoError(t, err)
+	_, err = store.Save(jstore.NewID("project", "order", "3"), `{}`)
+	require.NoError(t, err)
+
+	_, err = ephemeral.Get(jstore.NewID("big-project", "session", "1"))
+	assert.NoError(t, err)
+	_, err = dedicated.Get(jstore.NewID("big-project", "order", "2"))
+	assert.NoError(t, err)
+	_, err = fallback.Get(jstore.NewID("project", "order", "3"))
+	assert.NoError(t, err)
+
+	entities, err := store.FindN("big-project", "order", 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(entities))
+
+	require.NoError(t, store.Delete(jstore.NewID("project", "order", "3")))
+	_, err = fallback.Get(jstore.NewID("project", "order", "3"))
+	assert.Equal(t, jstore.NotFound, err)
+}
+
+func Test_RoutingStore_NoRoute(t *testing.T) {
+	ephemeral, _ := memory.NewMemoryStore("")
+	store, err := jstore.NewRoutingStore(jstore.RouteTo("*", "session", ephemeral))
+	require.NoError(t, err)
+
+	_, err = store.Save(jstore.NewID("project", "order", "1"), `{}`)
+	assert.Error(t, err)
+}
+
+func Test_RoutingStore_InvalidPattern(t *testing.T) {
+	ephemeral, _ := memory.NewMemoryStore("")
+	_, err := jstore.NewRoutingStore(jstore.RouteTo("[", "*", ephemeral))
+	assert.Error(t, err)
+}
+
+func Test_RoutingStore_HealthCheck(t *testing.T) {
+	healthy, _ := memory.NewMemoryStore("")
+
+	store, err := jstore.NewRoutingStore(
+		jstore.RouteTo("a", "*", healthy),
+		jstore.RouteTo("b", "*", healthy),
+	)
+	require.NoError(t, err)
+	assert.NoError(t, store.HealthCheck())
+
+	store, err = jstore.NewRoutingStore(
+		jstore.RouteTo("a", "*", healthy),
+		jstore.RouteTo("b", "*", unhealthyStore{healthy}),
+	)
+	require.NoError(t, err)
+	assert.Error(t, store.HealthCheck())
+}
+
+func Test_RoutingStore_Discovery(t *testing.T) {
+	ephemeral, _ := memory.NewMemoryStore("")
+	fallback, _ := memory.NewMemoryStore("")
+	store, err := jstore.NewRoutingStore(
+		jstore.RouteTo("*", "session", ephemeral),
+		jstore.RouteTo("*", "*", fallback),
+	)
+	require.NoError(t, err)
+
+	store.Save(jstore.NewID("project", "session", "1"), `{}`)
+	store.Save(jstore.NewID("project", "order", "2"), `{}`)
+	store.Save(jstore.NewID("other", "order", "3"), `{}`)
+	// not reachable through the routes
+	ephemeral.Save(jstore.NewID("project", "order", "4"), `{}`)
+
+	projects, err := store.ListProjects()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other", "project"}, projects)
+
+	documentTypes, err := store.ListDocumentTypes("project")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"order", "session"}, documentTypes)
+
+	stats, err := store.Stats("project", "order")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Count)
+}
@@ -0,0 +1,109 @@
+package jstore
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ScanMaxResults is the maximum number of documents, Scan reads from
+// stores which do not implement the Scanner interface.
+var ScanMaxResults = 10000
+
+// ScanLimitExceeded is returned by the iterator of Scan, if a store
+// without Scanner holds more than ScanMaxResults matching documents.
+var ScanLimitExceeded = errors.New("Scan exceeds ScanMaxResults")
+
+// Iterator walks over a sequence of entities:
+//
+//	it := jstore.Scan(store, project, documentType)
+//	defer it.Close()
+//	for it.Next() {
+//		entity := it.Entity()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+type Iterator interface {
+	// Next advances to the next entity and returns false, if there
+	// is none or an error occurred.
+	Next() bool
+	// Entity returns the current entity.
+	Entity() Entity
+	// Err returns the error which stopped the iteration.
+	Err() error
+	// Close releases the resources of the iteration.
+	Close() error
+}
+
+// Scanner is implemented by stores, which are able to stream all
+// documents matching the options.
+type Scanner interface {
+	Scan(project, documentType string, options ...Option) Iterator
+}
+
+// Scan returns an iterator over all documents matching the options.
+// A missing project or document type results in an empty iteration.
+//
+// Stores which do not implement Scanner are read with FindN. If they
+// hold more than ScanMaxResults matching documents, the iteration fails
+// with ScanLimitExceeded instead of returning a part of them.
+func Scan(store Store, project, documentType string, options ...Option) Iterator {
+	for s := store; s != nil; s = unwrap(s) {
+		if scanner, ok := s.(Scanner); ok {
+			return scanner.Scan(project, documentType, options...)
+		}
+	}
+
+	entities, err := store.FindN(project, documentType, ScanMaxResults+1, options...)
+	if err == NotFound {
+		return NewSliceIterator(nil)
+	}
+	if err != nil {
+		return ErrorIterator(err)
+	}
+	if len(entities) > ScanMaxResults {
+		return ErrorIterator(fmt.Errorf("%w: more than %d documents of %s/%s", ScanLimitExceeded, ScanMaxResults, project, documentType))
+	}
+	return NewSliceIterator(entities)
+}
+
+// NewSliceIterator returns an iterator over the entities.
+func NewSliceIterator(entities []Entity) Iterator {
+	return &sliceIterator{entities: entities, position: -1}
+}
+
+// ErrorIterator returns an empty iterator failing with err.
+func ErrorIterator(err error) Iterator {
+	return &sliceIterator{err: err, position: -1}
+}
+
+type sliceIterator struct {
+	entities []Entity
+	position int
+	err      error
+}
+
+func (it *sliceIterator) Next() bool {
+	if it.err != nil || it.position+1 >= len(it.entities) {
+		it.position = len(it.entities)
+		return false
+	}
+	it.position++
+	return true
+}
+
+func (it *sliceIterator) Entity() Entity {
+	if it.position < 0 || it.position >= len(it.entities) {
+		return Entity{}
+	}
+	return it.entities[it.position]
+}
+
+func (it *sliceIterator) Err() error {
+	return it.err
+}
+
+func (it *sliceIterator) Close() error {
+	it.entities = nil
+	it.position = 0
+	return nil
+}
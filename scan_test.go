@@ -0,0 +1,91 @@
+package jstore_test
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/snabble/go-jstore/v2"
+	"github.com/snabble/go-jstore/v2/jstoretest"
+	"github.com/snabble/go-jstore/v2/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// findOnlyStore hides the optional interfaces of the wrapped store.
+type findOnlyStore struct {
+	jstore.Store
+}
+
+func Test_Scan_FallbackToFindN(t *testing.T) {
+	store, _ := memory.NewMemoryStore("")
+	fake := jstoretest.NewFakeStore(store)
+
+	fake.Save(jstore.NewID("project", "person", "ford"), `{}`)
+	fake.Save(jstore.NewID("project", "person", "zaphod"), `{}`)
+
+	it := jstore.Scan(findOnlyStore{fake}, "project", "person")
+	defer it.Close()
+
+	count := 0
+	for it.Next() {
+		count++
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, 2, count)
+	assert.Equal(t, jstore.ScanMaxResults+1, fake.CallsTo(jstoretest.OpFindN)[0].MaxResults)
+}
+
+func Test_Scan_FallbackLimit(t *testing.T) {
+	defer func(max int) { jstore.ScanMaxResults = max }(jstore.ScanMaxResults)
+	jstore.ScanMaxResults = 2
+
+	store, _ := memory.NewMemoryStore("")
+	fake := jstoretest.NewFakeStore(store)
+	for _, id := range []string{"ford", "marvin"} {
+		fake.Save(jstore.NewID("project", "person", id), `{}`)
+	}
+
+	it := jstore.Scan(findOnlyStore{fake}, "project", "person")
+	count := 0
+	for it.Next() {
+		count++
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, 2, count)
+
+	fake.Save(jstore.NewID("project", "person", "zaphod"), `{}`)
+	it = jstore.Scan(findOnlyStore{fake}, "project", "person")
+	assert.False(t, it.Next())
+	assert.True(t, errors.Is(it.Err(), jstore.ScanLimitExceeded))
+
+	// exports are not truncated either
+	err := jstore.Export(findOnlyStore{fake}, "project", []string{"person"}, io.Discard)
+	assert.True(t, errors.Is(err, jstore.ScanLimitExceeded))
+}
+
+func Test_Scan_FallbackError(t *testing.T) {
+	store, _ := memory.NewMemoryStore("")
+	fake := jstoretest.NewFakeStore(store)
+	fake.On(jstoretest.OpFindN).Fail(errors.New("broken"))
+
+	it := jstore.Scan(findOnlyStore{fake}, "project", "person")
+	assert.False(t, it.Next())
+	assert.EqualError(t, it.Err(), "broken")
+}
+
+func Test_Scan_Bucket(t *testing.T) {
+	store, _ := jstore.NewStore(memory.DriverName, "")
+	bucket := store.Bucket("project", "person")
+
+	bucket.Save(jstore.NewID("", "", "ford"), `{"age":42}`)
+	bucket.Save(jstore.NewID("", "", "zaphod"), `{"age":4200}`)
+
+	it := bucket.Scan(jstore.Gt("age", 100))
+	defer it.Close()
+
+	require.True(t, it.Next())
+	assert.Equal(t, "zaphod", it.Entity().ID)
+	assert.False(t, it.Next())
+	assert.NoError(t, it.Err())
+}
@@ -3,6 +3,7 @@ package jstore
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 )
 
 type Version interface{}
@@ -48,28 +49,79 @@ type Store interface {
 	HealthCheck() error
 }
 
+// Inserter is implemented by stores, which are able to save a document
+// only if it does not exist yet.
+type Inserter interface {
+	// Insert saves the document and fails with AlreadyExists,
+	// if there is a document with the same id.
+	Insert(id EntityID, json string) (EntityID, error)
+}
+
 type JStore interface {
 	Store
+	Scanner
+	Inserter
+	RawSaver
+	EntityUnmarshaler
+	// Create saves a new document with a generated id.
+	Create(project, documentType string, json string) (EntityID, error)
 	Marshal(object interface{}, id EntityID) (EntityID, error)
+	MarshalCreate(object interface{}, project, documentType string) (EntityID, error)
+	MarshalInsert(object interface{}, id EntityID) (EntityID, error)
 	Unmarshal(entityOrObjectRef interface{}, project, documentType string, options ...Option) error
+	// UnmarshalN decodes the found documents into the slice slicePtr
+	// points to. If the elements are structs with a field of type
+	// EntityID, that field is set to the id of the document, unless it
+	// is tagged with `jstore:"-"`.
+	UnmarshalN(slicePtr interface{}, project, documentType string, maxResults int, options ...Option) error
+	// UnmarshalByID decodes the document with the id, using Get.
+	UnmarshalByID(entityOrObjectRef interface{}, id EntityID) error
 	Bucket(project, documentType string) Bucket
 }
 
 type Bucket interface {
 	Delete(id EntityID) error
 	Save(id EntityID, json string) (EntityID, error)
+	SaveRaw(id EntityID, raw json.RawMessage) (EntityID, error)
+	Create(json string) (EntityID, error)
+	Insert(id EntityID, json string) (EntityID, error)
 	Get(id EntityID) (Entity, error)
 	Find(options ...Option) (Entity, error)
 	FindN(maxResults int, options ...Option) ([]Entity, error)
+	Scan(options ...Option) Iterator
 	Marshal(object interface{}, id EntityID) (EntityID, error)
+	MarshalCreate(object interface{}) (EntityID, error)
+	MarshalInsert(object interface{}, id EntityID) (EntityID, error)
 	Unmarshal(entityOrObjectRef interface{}, options ...Option) error
+	UnmarshalN(slicePtr interface{}, maxResults int, options ...Option) error
+	UnmarshalByID(entityOrObjectRef interface{}, id EntityID) error
 }
 
 var (
 	NotFound               = errors.New("Document not found")
 	OptimisticLockingError = errors.New("Optimistic locking failed")
+	AlreadyExists          = errors.New("Document already exists")
+	NotSupported           = errors.New("Operation not supported by store")
 )
 
+// Insert saves the document, if it does not exist yet. Stores which do
+// not implement Inserter are checked with Get before saving, which is
+// not atomic.
+func Insert(store Store, id EntityID, json string) (EntityID, error) {
+	if inserter, ok := store.(Inserter); ok {
+		return inserter.Insert(id, json)
+	}
+
+	_, err := store.Get(id)
+	if err == nil {
+		return EntityID{}, AlreadyExists
+	}
+	if err != NotFound {
+		return EntityID{}, err
+	}
+	return store.Save(NewID(id.Project, id.DocumentType, id.ID), json)
+}
+
 func NewStore(driverName, dataSourceName string, options ...StoreOption) (JStore, error) {
 	p, found := getProvider(driverName)
 	if !found {
@@ -81,13 +133,19 @@ func NewStore(driverName, dataSourceName string, options ...StoreOption) (JStore
 		return nil, err
 	}
 
-	return WrapStore(store), nil
+	marshalStore := newMarshalStore(store, options...)
+	if err := marshalStore.checkTimestamps(); err != nil {
+		return nil, err
+	}
+	return marshalStore, nil
 }
 
-func WrapStore(store Store) JStore {
-	return &marshalStore{
-		Store: store,
-	}
+// JStoreOption configures the JStore wrapping a Store. It may be
+// passed along with the options of the store provider.
+type JStoreOption func(store *marshalStore)
+
+func WrapStore(store Store, options ...StoreOption) JStore {
+	return newMarshalStore(store, options...)
 }
 
 func NewBucket(driverName, dataSourceName, project, documentType string, options ...StoreOption) (Bucket, error) {
@@ -100,22 +158,135 @@ func NewBucket(driverName, dataSourceName, project, documentType string, options
 		return nil, err
 	}
 
-	marshalStore := &marshalStore{
-		Store: store,
+	marshalStore := newMarshalStore(store, options...)
+	if err := marshalStore.checkTimestamps(); err != nil {
+		return nil, err
 	}
 	return marshalStore.Bucket(project, documentType), nil
 }
 
 type marshalStore struct {
 	Store
+	codec            Codec
+	generateID       IDGenerator
+	omitTaggedFields bool
+	timestamps       timestamps
 }
 
+func newMarshalStore(store Store, options ...StoreOption) *marshalStore {
+	marshalStore := &marshalStore{
+		Store:      store,
+		codec:      StandardJSON,
+		generateID: UUIDv4,
+	}
+	for _, option := range options {
+		switch option := option.(type) {
+		case JStoreOption:
+			option(marshalStore)
+		case Codec:
+			marshalStore.codec = option
+		}
+	}
+	return marshalStore
+}
+
+func (store *marshalStore) Unwrap() Store {
+	return store.Store
+}
+
+func (store *marshalStore) Scan(project, documentType string, options ...Option) Iterator {
+	return Scan(store.Store, project, documentType, options...)
+}
+
+func (store *marshalStore) Create(project, documentType string, json string) (EntityID, error) {
+	id, err := store.generateID()
+	if err != nil {
+		return EntityID{}, fmt.Errorf("could not generate id: %w", err)
+	}
+	return store.Insert(NewID(project, documentType, id), json)
+}
+
+func (store *marshalStore) Save(id EntityID, json string) (EntityID, error) {
+	if store.timestamps.enabled() {
+		return store.saveStamped(id, json)
+	}
+	return store.Store.Save(id, json)
+}
+
+func (store *marshalStore) Insert(id EntityID, json string) (EntityID, error) {
+	if store.timestamps.enabled() {
+		return store.insertStamped(id, json)
+	}
+	return Insert(store.Store, id, json)
+}
+
+func (store *marshalStore) SaveRaw(id EntityID, raw json.RawMessage) (EntityID, error) {
+	if store.timestamps.enabled() {
+		// stamping creates a new document, so raw is not retained
+		return store.Save(id, bytesToString(raw))
+	}
+	return SaveRaw(store.Store, id, raw)
+}
+
+// Marshal saves the object. The id and the version are taken from the
+// fields tagged with `jstore:"id"` and `jstore:"version"`, if the id
+// leaves them empty. After saving, the tagged fields are updated, if
+// object is a pointer.
 func (store *marshalStore) Marshal(object interface{}, id EntityID) (EntityID, error) {
-	j, err := json.Marshal(object)
+	id, err := bindID(object, id)
+	if err != nil {
+		return EntityID{}, err
+	}
+	j, err := store.marshal(object)
 	if err != nil {
 		return EntityID{}, err
 	}
-	return store.Save(id, string(j))
+	savedID, err := store.Save(id, bytesToString(j))
+	if err != nil {
+		return savedID, err
+	}
+	return savedID, setBoundFields(object, savedID)
+}
+
+func (store *marshalStore) MarshalCreate(object interface{}, project, documentType string) (EntityID, error) {
+	if err := checkBoundFields(object); err != nil {
+		return EntityID{}, err
+	}
+	j, err := store.marshal(object)
+	if err != nil {
+		return EntityID{}, err
+	}
+	savedID, err := store.Create(project, documentType, bytesToString(j))
+	if err != nil {
+		return savedID, err
+	}
+	return savedID, setBoundFields(object, savedID)
+}
+
+func (store *marshalStore) MarshalInsert(object interface{}, id EntityID) (EntityID, error) {
+	id, err := bindID(object, id)
+	if err != nil {
+		return EntityID{}, err
+	}
+	j, err := store.marshal(object)
+	if err != nil {
+		return EntityID{}, err
+	}
+	savedID, err := store.Insert(id, bytesToString(j))
+	if err != nil {
+		return savedID, err
+	}
+	return savedID, setBoundFields(object, savedID)
+}
+
+// marshal returns the json of the object, which is passed to the store
+// without copying, because it is not used elsewhere.
+func (store *marshalStore) marshal(object interface{}) ([]byte, error) {
+	j, err := marshalJSON(store.codec, object)
+	if err != nil || !store.omitTaggedFields {
+		return j, err
+	}
+	return omitBoundFields(object, j)
 }
 
 func (store *marshalStore) Unmarshal(entityOrObjectRef interface{}, project, documentType string, options ...Option) error {
@@ -123,15 +294,27 @@ func (store *marshalStore) Unmarshal(entityOrObjectRef interface{}, project, doc
 	if err != nil {
 		return err
 	}
+	return unmarshalEntity(store.codec, found, entityOrObjectRef)
+}
+
+func (store *marshalStore) UnmarshalEntity(found Entity, entityOrObjectRef interface{}) error {
+	return unmarshalEntity(store.codec, found, entityOrObjectRef)
+}
 
-	objectRef := entityOrObjectRef
-	if entity, ok := entityOrObjectRef.(*Entity); ok {
-		entity.EntityID = found.EntityID
-		entity.JSON = found.JSON
-		objectRef = entity.ObjectRef
+func (store *marshalStore) UnmarshalN(slicePtr interface{}, project, documentType string, maxResults int, options ...Option) error {
+	found, err := store.FindN(project, documentType, maxResults, options...)
+	if err != nil {
+		return err
 	}
+	return unmarshalEntities(store.codec, found, slicePtr)
+}
 
-	return json.Unmarshal([]byte(found.JSON), objectRef)
+func (store *marshalStore) UnmarshalByID(entityOrObjectRef interface{}, id EntityID) error {
+	found, err := store.Get(id)
+	if err != nil {
+		return err
+	}
+	return unmarshalEntity(store.codec, found, entityOrObjectRef)
 }
 
 func (store *marshalStore) Bucket(project, documentType string) Bucket {
@@ -156,6 +339,18 @@ func (b *bucket) Save(id EntityID, json string) (EntityID, error) {
 	return b.store.Save(b.resolveRelativeToBucket(id), json)
 }
 
+func (b *bucket) SaveRaw(id EntityID, raw json.RawMessage) (EntityID, error) {
+	return b.store.SaveRaw(b.resolveRelativeToBucket(id), raw)
+}
+
+func (b *bucket) Create(json string) (EntityID, error) {
+	return b.store.Create(b.project, b.documentType, json)
+}
+
+func (b *bucket) Insert(id EntityID, json string) (EntityID, error) {
+	return b.store.Insert(b.resolveRelativeToBucket(id), json)
+}
+
 func (b *bucket) Get(id EntityID) (Entity, error) {
 	return b.store.Get(b.resolveRelativeToBucket(id))
 }
@@ -168,14 +363,34 @@ func (b *bucket) FindN(maxResults int, options ...Option) ([]Entity, error) {
 	return b.store.FindN(b.project, b.documentType, maxResults, options...)
 }
 
+func (b *bucket) Scan(options ...Option) Iterator {
+	return b.store.Scan(b.project, b.documentType, options...)
+}
+
 func (b *bucket) Marshal(object interface{}, id EntityID) (EntityID, error) {
 	return b.store.Marshal(object, b.resolveRelativeToBucket(id))
 }
 
+func (b *bucket) MarshalCreate(object interface{}) (EntityID, error) {
+	return b.store.MarshalCreate(object, b.project, b.documentType)
+}
+
+func (b *bucket) MarshalInsert(object interface{}, id EntityID) (EntityID, error) {
+	return b.store.MarshalInsert(object, b.resolveRelativeToBucket(id))
+}
+
 func (b *bucket) Unmarshal(entityOrObjectRef interface{}, options ...Option) error {
 	return b.store.Unmarshal(entityOrObjectRef, b.project, b.documentType, options...)
 }
 
+func (b *bucket) UnmarshalN(slicePtr interface{}, maxResults int, options ...Option) error {
+	return b.store.UnmarshalN(slicePtr, b.project, b.documentType, maxResults, options...)
+}
+
+func (b *bucket) UnmarshalByID(entityOrObjectRef interface{}, id EntityID) error {
+	return b.store.UnmarshalByID(entityOrObjectRef, b.resolveRelativeToBucket(id))
+}
+
 func (b *bucket) resolveRelativeToBucket(id EntityID) EntityID {
 	return EntityID{
 		Project:      b.project,
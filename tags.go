@@ -0,0 +1,191 @@
+package jstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// TagName is the name of the struct tag, which binds fields to the
+// EntityID of a document:
+//
+//	type Person struct {
+//		ID      string         `json:"id" jstore:"id"`
+//		Version jstore.Version `json:"-" jstore:"version"`
+//	}
+//
+// Marshal takes the id and the version from the tagged fields, if the
+// EntityID leaves them empty. The unmarshal methods set the fields to
+// the id and version of the document. The id field must be a string
+// and the version field a Version, which holds the versions of every
+// store. A field of type EntityID tagged with `jstore:"-"` is not set by
+// UnmarshalN.
+const TagName = "jstore"
+
+// OmitTaggedFields removes the fields tagged with `jstore:"id"` and
+// `jstore:"version"` from the persisted json.
+func OmitTaggedFields() JStoreOption {
+	return func(store *marshalStore) {
+		store.omitTaggedFields = true
+	}
+}
+
+type boundField struct {
+	index    int
+	jsonName string
+}
+
+type boundFields struct {
+	id      *boundField
+	version *boundField
+}
+
+var boundFieldsCache sync.Map
+
+type cachedBoundFields struct {
+	fields boundFields
+	err    error
+}
+
+// boundFieldsOf returns the tagged fields of the struct type.
+func boundFieldsOf(t reflect.Type) (boundFields, error) {
+	if cached, ok := boundFieldsCache.Load(t); ok {
+		return cached.(cachedBoundFields).fields, cached.(cachedBoundFields).err
+	}
+
+	fields := boundFields{}
+	var err error
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup(TagName)
+		if !ok || !field.IsExported() {
+			continue
+		}
+		bound := &boundField{index: i, jsonName: jsonName(field)}
+		switch tag {
+		case "id":
+			if field.Type.Kind() != reflect.String {
+				err = fmt.Errorf("%s.%s: id field must be a string", t, field.Name)
+			}
+			fields.id = bound
+		case "version":
+			if field.Type.Kind() != reflect.Interface || field.Type.NumMethod() != 0 {
+				err = fmt.Errorf("%s.%s: version field must be a jstore.Version", t, field.Name)
+			}
+			fields.version = bound
+		case "-":
+		default:
+			err = fmt.Errorf("%s.%s: unknown %s tag %q", t, field.Name, TagName, tag)
+		}
+	}
+
+	boundFieldsCache.Store(t, cachedBoundFields{fields, err})
+	return fields, err
+}
+
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// structOf dereferences the object, if it is a pointer to a struct.
+func structOf(object interface{}) (reflect.Value, bool) {
+	value := reflect.ValueOf(object)
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return reflect.Value{}, false
+		}
+		value = value.Elem()
+	}
+	return value, value.Kind() == reflect.Struct
+}
+
+// checkBoundFields validates the tagged fields of the object, so
+// setBoundFields can not fail after a write.
+func checkBoundFields(object interface{}) error {
+	value, ok := structOf(object)
+	if !ok {
+		return nil
+	}
+	_, err := boundFieldsOf(value.Type())
+	return err
+}
+
+// bindID completes the id with the tagged fields of the object.
+func bindID(object interface{}, id EntityID) (EntityID, error) {
+	value, ok := structOf(object)
+	if !ok {
+		return id, nil
+	}
+	fields, err := boundFieldsOf(value.Type())
+	if err != nil {
+		return id, err
+	}
+
+	if fields.id != nil && id.ID == "" {
+		id.ID = value.Field(fields.id.index).String()
+	}
+	if fields.version != nil && id.Version == NoVersion {
+		if field := value.Field(fields.version.index); !field.IsZero() {
+			id.Version = field.Interface()
+		}
+	}
+	return id, nil
+}
+
+// setBoundFields sets the tagged fields of the object to the id, if
+// the object is addressable.
+func setBoundFields(object interface{}, id EntityID) error {
+	value, ok := structOf(object)
+	if !ok || !value.CanSet() {
+		return nil
+	}
+	fields, err := boundFieldsOf(value.Type())
+	if err != nil {
+		return err
+	}
+
+	if fields.id != nil {
+		value.Field(fields.id.index).SetString(id.ID)
+	}
+	if fields.version != nil {
+		field := value.Field(fields.version.index)
+		switch {
+		case id.Version == NoVersion:
+			field.Set(reflect.Zero(field.Type()))
+		case reflect.TypeOf(id.Version).AssignableTo(field.Type()):
+			field.Set(reflect.ValueOf(id.Version))
+		default:
+			return fmt.Errorf("can not assign version of type %T to %s", id.Version, field.Type())
+		}
+	}
+	return nil
+}
+
+// omitBoundFields removes the tagged fields from the marshalled json.
+func omitBoundFields(object interface{}, j []byte) ([]byte, error) {
+	value, ok := structOf(object)
+	if !ok {
+		return j, nil
+	}
+	fields, err := boundFieldsOf(value.Type())
+	if err != nil || (fields.id == nil && fields.version == nil) {
+		return j, err
+	}
+
+	properties := map[string]json.RawMessage{}
+	if err := json.Unmarshal(j, &properties); err != nil {
+		return nil, err
+	}
+	for _, field := range []*boundField{fields.id, fields.version} {
+		if field != nil {
+			delete(properties, field.jsonName)
+		}
+	}
+	return json.Marshal(properties)
+}
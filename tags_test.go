@@ -0,0 +1,132 @@
+package jstore_test
+
+import (
+	"testing"
+
+	"github.com/snabble/go-jstore/v2"
+	"github.com/snabble/go-jstore/v2/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type taggedPerson struct {
+	ID      string         `json:"id" jstore:"id"`
+	Version jstore.Version `json:"version,omitempty" jstore:"version"`
+	Name    string         `json:"name"`
+}
+
+func Test_Tags_Marshal(t *testing.T) {
+	store, _ := jstore.NewStore(memory.DriverName, "")
+	bucket := store.Bucket("project", "person")
+
+	ford := &taggedPerson{ID: "ford", Name: "ford"}
+	id, err := bucket.Marshal(ford, jstore.EntityID{})
+	require.NoError(t, err)
+	assert.Equal(t, "ford", id.ID)
+	assert.Equal(t, memory.Version(1), ford.Version)
+
+	// the version of the object is used for optimistic locking
+	ford.Name = "ford prefect"
+	_, err = bucket.Marshal(ford, jstore.EntityID{})
+	require.NoError(t, err)
+	assert.Equal(t, memory.Version(2), ford.Version)
+
+	stale := &taggedPerson{ID: "ford", Version: memory.Version(1), Name: "stale"}
+	_, err = bucket.Marshal(stale, jstore.EntityID{})
+	assert.Equal(t, jstore.OptimisticLockingError, err)
+
+	// the EntityID has precedence
+	_, err = bucket.Marshal(taggedPerson{ID: "ford", Name: "marvin"}, jstore.NewID("", "", "marvin"))
+	require.NoError(t, err)
+	_, err = store.Get(jstore.NewID("project", "person", "marvin"))
+	assert.NoError(t, err)
+
+	zaphod := &taggedPerson{Name: "zaphod"}
+	id, err = bucket.MarshalCreate(zaphod)
+	require.NoError(t, err)
+	assert.Equal(t, id.ID, zaphod.ID)
+}
+
+func Test_Tags_Unmarshal(t *testing.T) {
+	store, _ := jstore.NewStore(memory.DriverName, "")
+	store.Save(jstore.NewID("project", "person", "ford"), `{"name":"ford"}`)
+	store.Save(jstore.NewID("project", "person", "marvin"), `{"name":"marvin"}`)
+
+	ford := taggedPerson{}
+	require.NoError(t, store.Unmarshal(&ford, "project", "person", jstore.Id("ford")))
+	assert.Equal(t, taggedPerson{ID: "ford", Version: memory.Version(1), Name: "ford"}, ford)
+
+	ford = taggedPerson{}
+	require.NoError(t, store.UnmarshalByID(&ford, jstore.NewID("project", "person", "ford")))
+	assert.Equal(t, "ford", ford.ID)
+
+	persons := []taggedPerson{}
+	require.NoError(t, store.UnmarshalN(&persons, "project", "person", 10, jstore.SortBy("name", true)))
+	require.Equal(t, 2, len(persons))
+	assert.Equal(t, "ford", persons[0].ID)
+	assert.Equal(t, "marvin", persons[1].ID)
+	assert.Equal(t, memory.Version(1), persons[1].Version)
+}
+
+func Test_Tags_Omit(t *testing.T) {
+	store, _ := jstore.NewStore(memory.DriverName, "", jstore.OmitTaggedFields())
+
+	_, err := store.Marshal(&taggedPerson{ID: "ford", Name: "ford"}, jstore.NewID("project", "person", ""))
+	require.NoError(t, err)
+
+	entity, err := store.Get(jstore.NewID("project", "person", "ford"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"ford"}`, entity.JSON)
+
+	ford := taggedPerson{}
+	require.NoError(t, store.UnmarshalByID(&ford, jstore.NewID("project", "person", "ford")))
+	assert.Equal(t, taggedPerson{ID: "ford", Version: memory.Version(1), Name: "ford"}, ford)
+}
+
+func Test_Tags_Invalid(t *testing.T) {
+	store, _ := jstore.NewStore(memory.DriverName, "")
+
+	_, err := store.Marshal(struct {
+		ID int `jstore:"id"`
+	}{42}, jstore.NewID("project", "person", ""))
+	assert.Error(t, err)
+
+	_, err = store.Marshal(struct {
+		ID string `jstore:"key"`
+	}{"ford"}, jstore.NewID("project", "person", ""))
+	assert.Error(t, err)
+}
+
+func Test_Tags_InvalidFields(t *testing.T) {
+	type wrongVersion struct {
+		ID      string `json:"id" jstore:"id"`
+		Version int64  `json:"-" jstore:"version"`
+	}
+	type wrongID struct {
+		ID int `json:"id" jstore:"id"`
+	}
+	store, _ := jstore.NewStore(memory.DriverName, "")
+	bucket := store.Bucket("project", "person")
+
+	for _, marshal := range []func(object interface{}) error{
+		func(object interface{}) error {
+			_, err := bucket.Marshal(object, jstore.NewID("", "", "ford"))
+			return err
+		},
+		func(object interface{}) error {
+			_, err := bucket.MarshalInsert(object, jstore.NewID("", "", "ford"))
+			return err
+		},
+		func(object interface{}) error {
+			_, err := bucket.MarshalCreate(object)
+			return err
+		},
+	} {
+		assert.Error(t, marshal(&wrongVersion{ID: "ford"}))
+		assert.Error(t, marshal(&wrongID{ID: 42}))
+	}
+
+	// nothing was saved, which a retry would duplicate
+	_, err := bucket.FindN(10)
+	assert.Equal(t, jstore.NotFound, err)
+}
@@ -0,0 +1,208 @@
+package jstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TimestampLayout is the format of the maintained timestamps. It is
+// parsed as date by Elasticsearch and compares with time.Time values in
+// Gt, Lt, etc.
+const TimestampLayout = "2006-01-02T15:04:05.000Z"
+
+type timestamps struct {
+	createdAt      string
+	updatedAt      string
+	timeSourceFunc func() time.Time
+}
+
+// Timestamps maintains the properties createdAt and updatedAt of the
+// saved documents. createdAt is preserved across updates.
+func Timestamps() JStoreOption {
+	return TimestampProperties("createdAt", "updatedAt")
+}
+
+// TimestampProperties maintains timestamps like Timestamps, using the
+// given property names.
+func TimestampProperties(createdAt, updatedAt string) JStoreOption {
+	return func(store *marshalStore) {
+		store.timestamps.createdAt = createdAt
+		store.timestamps.updatedAt = updatedAt
+	}
+}
+
+// TimeSource sets the clock for the timestamps. Default is time.Now.
+func TimeSource(timeSourceFunc func() time.Time) JStoreOption {
+	return func(store *marshalStore) {
+		store.timestamps.timeSourceFunc = timeSourceFunc
+	}
+}
+
+func (t timestamps) enabled() bool {
+	return t.createdAt != "" || t.updatedAt != ""
+}
+
+func (t timestamps) now() string {
+	if t.timeSourceFunc == nil {
+		return time.Now().UTC().Format(TimestampLayout)
+	}
+	return t.timeSourceFunc().UTC().Format(TimestampLayout)
+}
+
+// maxStampAttempts limits the retries of saveStamped on conflicting
+// concurrent writes.
+const maxStampAttempts = 10
+
+var errAsyncTimestamps = errors.New("timestamps can not be combined with asynchronous writes")
+
+// checkTimestamps rejects timestamps on stores with asynchronous writes,
+// because their reads miss the queued documents and createdAt would be
+// reset.
+func (store *marshalStore) checkTimestamps() error {
+	if !store.timestamps.enabled() {
+		return nil
+	}
+	for s := store.Store; s != nil; s = unwrap(s) {
+		if async, ok := s.(interface{ WritesAsync() bool }); ok && async.WritesAsync() {
+			return errAsyncTimestamps
+		}
+	}
+	return nil
+}
+
+// saveStamped saves the document with timestamps, keeping the createdAt
+// of the present document. Without a version, the save is conditional
+// on the read document, so concurrent saves of a new document can not
+// both set createdAt. Conflicting saves are read and stamped again.
+func (store *marshalStore) saveStamped(id EntityID, document string) (EntityID, error) {
+	if err := store.checkTimestamps(); err != nil {
+		return EntityID{}, err
+	}
+	if store.timestamps.createdAt == "" {
+		stamped, err := store.stamp(id, document, nil)
+		if err != nil {
+			return EntityID{}, err
+		}
+		return store.Store.Save(id, stamped)
+	}
+
+	for attempt := 1; ; attempt++ {
+		previous, found, err := store.previous(id)
+		if err != nil {
+			return EntityID{}, err
+		}
+		stamped, err := store.stamp(id, document, previous)
+		if err != nil {
+			return EntityID{}, err
+		}
+
+		var savedID EntityID
+		switch {
+		case id.Version != NoVersion:
+			return store.Store.Save(id, stamped)
+		case found.Version != NoVersion:
+			savedID, err = store.Store.Save(found, stamped)
+		case previous == nil:
+			savedID, err = Insert(store.Store, id, stamped)
+		default:
+			// the store has no versions to make the save conditional
+			return store.Store.Save(id, stamped)
+		}
+		conflict := errors.Is(err, OptimisticLockingError) || errors.Is(err, AlreadyExists)
+		if !conflict || attempt == maxStampAttempts {
+			return savedID, err
+		}
+	}
+}
+
+// insertStamped inserts the document with timestamps.
+func (store *marshalStore) insertStamped(id EntityID, document string) (EntityID, error) {
+	if err := store.checkTimestamps(); err != nil {
+		return EntityID{}, err
+	}
+	stamped, err := store.stamp(id, document, nil)
+	if err != nil {
+		return EntityID{}, err
+	}
+	return Insert(store.Store, id, stamped)
+}
+
+// stamp sets the timestamps of the document. The createdAt of the
+// previous document is kept.
+func (store *marshalStore) stamp(id EntityID, document string, previous json.RawMessage) (string, error) {
+	properties := map[string]json.RawMessage{}
+	if err := json.Unmarshal(stringToBytes(document), &properties); err != nil {
+		return "", fmt.Errorf("adding timestamps to %v: %w", id, err)
+	}
+
+	now, err := json.Marshal(store.timestamps.now())
+	if err != nil {
+		return "", err
+	}
+
+	if createdAt := store.timestamps.createdAt; createdAt != "" {
+		if !isZeroTime(previous) {
+			properties[createdAt] = previous
+		}
+		if isZeroTime(properties[createdAt]) {
+			properties[createdAt] = now
+		}
+	}
+	if updatedAt := store.timestamps.updatedAt; updatedAt != "" {
+		properties[updatedAt] = now
+	}
+
+	stamped, err := json.Marshal(properties)
+	if err != nil {
+		return "", err
+	}
+	return bytesToString(stamped), nil
+}
+
+// isZeroTime reports, if the time is missing, e.g. a zero time.Time
+// marshalled by a struct, null or empty.
+func isZeroTime(raw json.RawMessage) bool {
+	if len(raw) == 0 {
+		return true
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return false
+	}
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		if v == "" {
+			return true
+		}
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return err == nil && t.IsZero()
+	}
+	return false
+}
+
+// previous returns the createdAt and the id of the present document.
+// The createdAt is nil, if the document does not exist.
+func (store *marshalStore) previous(id EntityID) (json.RawMessage, EntityID, error) {
+	present, err := store.Store.Get(id)
+	if errors.Is(err, NotFound) {
+		return nil, EntityID{}, nil
+	}
+	if err != nil {
+		return nil, EntityID{}, fmt.Errorf("reading timestamps of %v: %w", id, err)
+	}
+
+	properties := map[string]json.RawMessage{}
+	if err := json.Unmarshal(present.Raw(), &properties); err != nil {
+		return nil, EntityID{}, fmt.Errorf("reading timestamps of %v: %w", id, err)
+	}
+	createdAt := properties[store.timestamps.createdAt]
+	if createdAt == nil {
+		// the document exists without createdAt
+		createdAt = json.RawMessage("null")
+	}
+	return createdAt, present.EntityID, nil
+}
@@ -0,0 +1,157 @@
+package jstore_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/snabble/go-jstore/v2"
+	"github.com/snabble/go-jstore/v2/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stampedPerson struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func Test_Timestamps(t *testing.T) {
+	now := time.Date(2042, 1, 1, 12, 0, 0, 0, time.UTC)
+	store, err := jstore.NewStore(memory.DriverName, "",
+		jstore.Timestamps(),
+		jstore.TimeSource(func() time.Time { return now }),
+	)
+	require.NoError(t, err)
+	bucket := store.Bucket("project", "person")
+
+	_, err = bucket.Save(jstore.NewID("", "", "ford"), `{"name":"ford"}`)
+	require.NoError(t, err)
+
+	ford := stampedPerson{}
+	require.NoError(t, bucket.UnmarshalByID(&ford, jstore.NewID("", "", "ford")))
+	assert.Equal(t, stampedPerson{"ford", now, now}, ford)
+
+	created := now
+	now = now.Add(time.Hour + time.Millisecond)
+	_, err = bucket.Marshal(stampedPerson{Name: "ford prefect"}, jstore.NewID("", "", "ford"))
+	require.NoError(t, err)
+
+	require.NoError(t, bucket.UnmarshalByID(&ford, jstore.NewID("", "", "ford")))
+	assert.Equal(t, stampedPerson{"ford prefect", created, now}, ford)
+
+	_, err = bucket.Insert(jstore.NewID("", "", "marvin"), `{"name":"marvin"}`)
+	require.NoError(t, err)
+
+	entities, err := bucket.FindN(10, jstore.Gt("createdAt", created), jstore.Lte("updatedAt", now))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(entities))
+	assert.Equal(t, "marvin", entities[0].ID)
+}
+
+func Test_TimestampProperties(t *testing.T) {
+	store, _ := jstore.NewStore(memory.DriverName, "", jstore.TimestampProperties("created", ""))
+
+	_, err := store.SaveRaw(jstore.NewID("project", "person", "ford"), []byte(`{"name":"ford"}`))
+	require.NoError(t, err)
+
+	entity, err := store.Get(jstore.NewID("project", "person", "ford"))
+	require.NoError(t, err)
+	assert.Contains(t, entity.JSON, `"created":`)
+	assert.NotContains(t, entity.JSON, `"updatedAt"`)
+
+	_, err = store.Save(jstore.NewID("project", "person", "ford"), `["not","an","object"]`)
+	assert.Error(t, err)
+}
+
+func Test_Timestamps_ZeroCreatedAt(t *testing.T) {
+	now := time.Date(2042, 1, 1, 12, 0, 0, 0, time.UTC)
+	store, err := jstore.NewStore(memory.DriverName, "",
+		jstore.Timestamps(),
+		jstore.TimeSource(func() time.Time { return now }),
+	)
+	require.NoError(t, err)
+	bucket := store.Bucket("project", "person")
+
+	for i, write := range []func(id jstore.EntityID) error{
+		func(id jstore.EntityID) error {
+			_, err := bucket.Marshal(stampedPerson{Name: "ford"}, id)
+			return err
+		},
+		func(id jstore.EntityID) error {
+			_, err := bucket.MarshalInsert(stampedPerson{Name: "ford"}, id)
+			return err
+		},
+		func(id jstore.EntityID) error {
+			_, err := bucket.Save(id, `{"name":"ford","createdAt":""}`)
+			return err
+		},
+		func(id jstore.EntityID) error {
+			_, err := bucket.Save(id, `{"name":"ford","createdAt":null}`)
+			return err
+		},
+	} {
+		id := jstore.NewID("", "", strconv.Itoa(i))
+		require.NoError(t, write(id))
+
+		ford := stampedPerson{}
+		require.NoError(t, bucket.UnmarshalByID(&ford, id))
+		assert.Equal(t, stampedPerson{"ford", now, now}, ford)
+	}
+}
+
+// racingStore saves the document of a concurrent writer, after the first
+// Get of a missing document.
+type racingStore struct {
+	jstore.Store
+	concurrent string
+}
+
+func (store *racingStore) Get(id jstore.EntityID) (jstore.Entity, error) {
+	entity, err := store.Store.Get(id)
+	if err == jstore.NotFound && store.concurrent != "" {
+		store.Store.Save(id, store.concurrent)
+		store.concurrent = ""
+	}
+	return entity, err
+}
+
+func Test_Timestamps_ConcurrentSave(t *testing.T) {
+	now := time.Date(2042, 1, 1, 12, 0, 0, 0, time.UTC)
+	memoryStore, _ := memory.NewMemoryStore("")
+	racing := &racingStore{memoryStore, `{"name":"ford","createdAt":"2041-01-01T12:00:00.000Z"}`}
+	store := jstore.WrapStore(racing,
+		jstore.Timestamps(),
+		jstore.TimeSource(func() time.Time { return now }),
+	)
+
+	_, err := store.Save(jstore.NewID("project", "person", "ford"), `{"name":"ford prefect"}`)
+	require.NoError(t, err)
+
+	ford := stampedPerson{}
+	require.NoError(t, store.UnmarshalByID(&ford, jstore.NewID("project", "person", "ford")))
+	assert.Equal(t, stampedPerson{"ford prefect", now.AddDate(-1, 0, 0), now}, ford)
+}
+
+// asyncStore pretends to queue its writes.
+type asyncStore struct {
+	jstore.Store
+}
+
+func (store asyncStore) WritesAsync() bool {
+	return true
+}
+
+func Test_Timestamps_AsyncWrites(t *testing.T) {
+	memoryStore, _ := memory.NewMemoryStore("")
+	store := jstore.WrapStore(asyncStore{memoryStore}, jstore.Timestamps())
+
+	_, err := store.Save(jstore.NewID("project", "person", "ford"), `{"name":"ford"}`)
+	assert.Error(t, err)
+	_, err = store.Insert(jstore.NewID("project", "person", "ford"), `{"name":"ford"}`)
+	assert.Error(t, err)
+
+	_, err = jstore.WrapStore(asyncStore{memoryStore}).Save(jstore.NewID("project", "person", "ford"), `{"name":"ford"}`)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,94 @@
+package jstore
+
+import (
+	"fmt"
+	"reflect"
+)
+
+var entityIDType = reflect.TypeOf(EntityID{})
+
+// UnmarshalEntity decodes the JSON of the entity into the object and
+// sets its fields tagged with `jstore:"id"` and `jstore:"version"`. If
+// entityOrObjectRef is an *Entity, its EntityID and JSON are set and
+// the JSON is decoded into its ObjectRef, if there is one.
+func UnmarshalEntity(found Entity, entityOrObjectRef interface{}) error {
+	return unmarshalEntity(StandardJSON, found, entityOrObjectRef)
+}
+
+// EntityUnmarshaler is implemented by stores, which decode documents
+// with their own Codec, like the stores returned by NewStore.
+type EntityUnmarshaler interface {
+	// UnmarshalEntity decodes the entity like the package function
+	// UnmarshalEntity, using the codec of the store.
+	UnmarshalEntity(found Entity, entityOrObjectRef interface{}) error
+}
+
+func unmarshalEntity(codec Codec, found Entity, entityOrObjectRef interface{}) error {
+	objectRef := entityOrObjectRef
+	if entity, ok := entityOrObjectRef.(*Entity); ok {
+		entity.EntityID = found.EntityID
+		entity.JSON = found.JSON
+		objectRef = entity.ObjectRef
+		if objectRef == nil {
+			return nil
+		}
+	}
+
+	if err := codec.Unmarshal(found.Raw(), objectRef); err != nil {
+		return err
+	}
+	return setBoundFields(objectRef, found.EntityID)
+}
+
+// unmarshalEntities decodes the entities into the slice slicePtr points
+// to. The elements may be structs or pointers to structs. A field of
+// type EntityID is set to the id of the entity, unless it is tagged
+// with `jstore:"-"`.
+func unmarshalEntities(codec Codec, entities []Entity, slicePtr interface{}) error {
+	slice := reflect.ValueOf(slicePtr)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("expected a pointer to a slice, got %T", slicePtr)
+	}
+	slice = slice.Elem()
+
+	elementType := slice.Type().Elem()
+	isPtr := elementType.Kind() == reflect.Ptr
+	if isPtr {
+		elementType = elementType.Elem()
+	}
+
+	result := reflect.MakeSlice(slice.Type(), 0, len(entities))
+	for _, entity := range entities {
+		element := reflect.New(elementType)
+		if err := unmarshalEntity(codec, entity, element.Interface()); err != nil {
+			return fmt.Errorf("unmarshal %v: %w", entity.EntityID, err)
+		}
+		setEntityID(element.Elem(), entity.EntityID)
+
+		if isPtr {
+			result = reflect.Append(result, element)
+		} else {
+			result = reflect.Append(result, element.Elem())
+		}
+	}
+	slice.Set(result)
+	return nil
+}
+
+// setEntityID sets the first field of type EntityID of the struct, which
+// is not tagged with `jstore:"-"`.
+func setEntityID(object reflect.Value, id EntityID) {
+	if object.Kind() != reflect.Struct || object.Type() == reflect.TypeOf(Entity{}) {
+		return
+	}
+	for i := 0; i < object.NumField(); i++ {
+		field := object.Field(i)
+		if object.Type().Field(i).Tag.Get(TagName) == "-" {
+			continue
+		}
+		if field.Type() == entityIDType && field.CanSet() {
+			field.Set(reflect.ValueOf(id))
+			return
+		}
+	}
+}
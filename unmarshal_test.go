@@ -0,0 +1,86 @@
+package jstore_test
+
+import (
+	"testing"
+
+	"github.com/snabble/go-jstore/v2"
+	"github.com/snabble/go-jstore/v2/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type person struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+type personWithID struct {
+	jstore.EntityID `json:"-"`
+	Name            string `json:"name"`
+}
+
+type personWithReference struct {
+	Friend jstore.EntityID `json:"friend" jstore:"-"`
+	Name   string          `json:"name"`
+}
+
+func Test_UnmarshalN(t *testing.T) {
+	store, _ := jstore.NewStore(memory.DriverName, "")
+	store.Marshal(person{"ford", 42}, jstore.NewID("project", "person", "ford"))
+	store.Marshal(person{"zaphod", 4200}, jstore.NewID("project", "person", "zaphod"))
+	store.Marshal(person{"marvin", 1010}, jstore.NewID("project", "person", "marvin"))
+
+	persons := []person{}
+	err := store.UnmarshalN(&persons, "project", "person", 2, jstore.SortBy("age", true))
+	require.NoError(t, err)
+	assert.Equal(t, []person{{"ford", 42}, {"marvin", 1010}}, persons)
+
+	pointers := []*person{}
+	err = store.Bucket("project", "person").UnmarshalN(&pointers, 10, jstore.Id("zaphod"))
+	require.NoError(t, err)
+	assert.Equal(t, []*person{{"zaphod", 4200}}, pointers)
+
+	withIDs := []personWithID{}
+	err = store.UnmarshalN(&withIDs, "project", "person", 10, jstore.Id("ford"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(withIDs))
+	assert.Equal(t, "ford", withIDs[0].Name)
+	assert.Equal(t, "ford", withIDs[0].ID)
+	assert.Equal(t, memory.Version(1), withIDs[0].Version)
+
+	friend := jstore.NewID("project", "person", "arthur")
+	store.Marshal(personWithReference{friend, "trillian"}, jstore.NewID("project", "person", "trillian"))
+	withReferences := []personWithReference{}
+	err = store.UnmarshalN(&withReferences, "project", "person", 10, jstore.Id("trillian"))
+	require.NoError(t, err)
+	assert.Equal(t, []personWithReference{{friend, "trillian"}}, withReferences)
+
+	entities := []jstore.Entity{}
+	err = store.UnmarshalN(&entities, "project", "person", 10, jstore.Id("ford"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(entities))
+	assert.Equal(t, "ford", entities[0].ID)
+
+	err = store.UnmarshalN(persons, "project", "person", 10)
+	assert.Error(t, err)
+
+	err = store.UnmarshalN(&persons, "unknown", "person", 10)
+	assert.Equal(t, jstore.NotFound, err)
+}
+
+func Test_UnmarshalByID(t *testing.T) {
+	store, _ := jstore.NewStore(memory.DriverName, "")
+	store.Marshal(person{"ford", 42}, jstore.NewID("project", "person", "ford"))
+
+	result := person{}
+	require.NoError(t, store.UnmarshalByID(&result, jstore.NewID("project", "person", "ford")))
+	assert.Equal(t, person{"ford", 42}, result)
+
+	entity := jstore.Entity{ObjectRef: &person{}}
+	require.NoError(t, store.Bucket("project", "person").UnmarshalByID(&entity, jstore.NewID("", "", "ford")))
+	assert.Equal(t, "ford", entity.ID)
+	assert.Equal(t, &person{"ford", 42}, entity.ObjectRef)
+
+	err := store.UnmarshalByID(&result, jstore.NewID("project", "person", "zaphod"))
+	assert.Equal(t, jstore.NotFound, err)
+}